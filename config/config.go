@@ -1,12 +1,16 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/pkg/imaging"
 	"github.com/joho/godotenv"
 )
 
@@ -57,6 +61,94 @@ func LoadConfig(path string) IConfig {
 				return b
 			}(),
 			gcpbucket: envMap["APP_GCP_BUCKET"],
+			imageMaxWidth: func() int {
+				if envMap["APP_IMAGE_MAX_WIDTH"] == "" {
+					return 8192
+				}
+				w, err := strconv.Atoi(envMap["APP_IMAGE_MAX_WIDTH"])
+				if err != nil {
+					log.Fatalf("load image max width failed: %v", err)
+				}
+				return w
+			}(),
+			imageMaxHeight: func() int {
+				if envMap["APP_IMAGE_MAX_HEIGHT"] == "" {
+					return 8192
+				}
+				h, err := strconv.Atoi(envMap["APP_IMAGE_MAX_HEIGHT"])
+				if err != nil {
+					log.Fatalf("load image max height failed: %v", err)
+				}
+				return h
+			}(),
+			imageDerivatives: func() map[string]int {
+				// name:longest edge in pixels, e.g. thumbnail:150,medium:600
+				raw := envMap["APP_IMAGE_DERIVATIVES"]
+				if raw == "" {
+					raw = "thumbnail:150,medium:600,large:1200"
+				}
+				derivatives := make(map[string]int)
+				if raw == "none" {
+					return derivatives
+				}
+				for _, pair := range strings.Split(raw, ",") {
+					kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+					if len(kv) != 2 {
+						log.Fatalf("load image derivatives failed: %q is invalid", pair)
+					}
+					size, err := strconv.Atoi(kv[1])
+					if err != nil || size <= 0 {
+						log.Fatalf("load image derivatives failed: %q is invalid", pair)
+					}
+					derivatives[kv[0]] = size
+				}
+				return derivatives
+			}(),
+			imageDerivativeFormat: func() string {
+				switch envMap["APP_IMAGE_DERIVATIVE_FORMAT"] {
+				case "":
+					return "jpeg"
+				case "jpeg":
+					return envMap["APP_IMAGE_DERIVATIVE_FORMAT"]
+				case "webp":
+					if !imaging.WebpSupported {
+						log.Fatalf("load image derivative format failed: webp needs a build with cgo and the webp tag")
+					}
+					return envMap["APP_IMAGE_DERIVATIVE_FORMAT"]
+				default:
+					log.Fatalf("load image derivative format failed: %q is not supported", envMap["APP_IMAGE_DERIVATIVE_FORMAT"])
+					return ""
+				}
+			}(),
+			// A header the proxies in front of the app overwrite, e.g. X-Real-Ip,
+			// a header clients can set would let them pick their ip
+			proxyHeader: envMap["APP_PROXY_HEADER"],
+			trustedProxies: func() []string {
+				raw := strings.TrimSpace(envMap["APP_TRUSTED_PROXIES"])
+				if raw == "" {
+					if envMap["APP_PROXY_HEADER"] != "" {
+						log.Fatalf("load trusted proxies failed: APP_PROXY_HEADER needs APP_TRUSTED_PROXIES")
+					}
+					return nil
+				}
+				proxies := make([]string, 0)
+				for _, proxy := range strings.Split(raw, ",") {
+					if proxy = strings.TrimSpace(proxy); proxy != "" {
+						proxies = append(proxies, proxy)
+					}
+				}
+				return proxies
+			}(),
+			requireVerifiedEmail: func() bool {
+				if envMap["APP_REQUIRE_VERIFIED_EMAIL"] == "" {
+					return false
+				}
+				b, err := strconv.ParseBool(envMap["APP_REQUIRE_VERIFIED_EMAIL"])
+				if err != nil {
+					log.Fatalf("load require verified email failed: %v", err)
+				}
+				return b
+			}(),
 		},
 		db: &db{
 			host: envMap["DB_HOST"],
@@ -81,9 +173,33 @@ func LoadConfig(path string) IConfig {
 			}(),
 		},
 		jwt: &jwt{
-			adminKey:  envMap["JWT_ADMIN_KEY"],
-			secertKey: envMap["JWT_SECRET_KEY"],
-			apiKey:    envMap["JWT_API_KEY"],
+			adminKey:   envMap["JWT_ADMIN_KEY"],
+			secertKey:  envMap["JWT_SECRET_KEY"],
+			apiKey:     envMap["JWT_API_KEY"],
+			keysetFile: envMap["JWT_KEYSET_FILE"],
+			legacyApiKeys: func() bool {
+				// On until operators opt out, the clients of an upgraded deployment
+				// still hold jwt api keys only
+				b := true
+				if envMap["JWT_LEGACY_API_KEYS"] != "" {
+					var err error
+					b, err = strconv.ParseBool(envMap["JWT_LEGACY_API_KEYS"])
+					if err != nil {
+						log.Fatalf("load legacy api keys failed: %v", err)
+					}
+				}
+				if b {
+					log.Printf("jwt api keys are deprecated, move the clients to database api keys and set JWT_LEGACY_API_KEYS=false")
+				}
+				return b
+			}(),
+			bootstrapApiKey: func() string {
+				key := envMap["JWT_BOOTSTRAP_API_KEY"]
+				if key != "" && len(key) < 32 {
+					log.Fatalf("load bootstrap api key failed: it needs at least 32 characters")
+				}
+				return key
+			}(),
 			accessExpiresAt: func() int {
 				t, err := strconv.Atoi(envMap["JWT_ACCESS_EXPIRES"])
 				if err != nil {
@@ -98,6 +214,454 @@ func LoadConfig(path string) IConfig {
 				}
 				return t
 			}(),
+			challengeExpiresAt: func() int {
+				if envMap["JWT_CHALLENGE_EXPIRES"] == "" {
+					return 300
+				}
+				t, err := strconv.Atoi(envMap["JWT_CHALLENGE_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load challenge expires at failed: %v", err)
+				}
+				return t
+			}(),
+		},
+		storage: &storage{
+			driver: func() string {
+				// GCS stays the default so existing deployments keep working
+				if envMap["STORAGE_DRIVER"] == "" {
+					return "gcs"
+				}
+				return envMap["STORAGE_DRIVER"]
+			}(),
+			localRoot: func() string {
+				if envMap["STORAGE_LOCAL_ROOT"] == "" {
+					return "./assets/images"
+				}
+				return envMap["STORAGE_LOCAL_ROOT"]
+			}(),
+			uploadDir: func() string {
+				if envMap["STORAGE_UPLOAD_DIR"] == "" {
+					return "./assets/uploads"
+				}
+				return envMap["STORAGE_UPLOAD_DIR"]
+			}(),
+			s3Bucket:    envMap["STORAGE_S3_BUCKET"],
+			s3Region:    envMap["STORAGE_S3_REGION"],
+			s3Endpoint:  envMap["STORAGE_S3_ENDPOINT"],
+			s3AccessKey: envMap["STORAGE_S3_ACCESS_KEY"],
+			s3SecretKey: envMap["STORAGE_S3_SECRET_KEY"],
+			s3PathStyle: func() bool {
+				if envMap["STORAGE_S3_PATH_STYLE"] == "" {
+					return false
+				}
+				b, err := strconv.ParseBool(envMap["STORAGE_S3_PATH_STYLE"])
+				if err != nil {
+					log.Fatalf("load s3 path style failed: %v", err)
+				}
+				return b
+			}(),
+			workers: func() int {
+				if envMap["STORAGE_WORKERS"] == "" {
+					return 5
+				}
+				n, err := strconv.Atoi(envMap["STORAGE_WORKERS"])
+				if err != nil {
+					log.Fatalf("load storage workers failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load storage workers failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			timeout: func() time.Duration {
+				if envMap["STORAGE_TIMEOUT"] == "" {
+					return 60 * time.Second
+				}
+				t, err := strconv.Atoi(envMap["STORAGE_TIMEOUT"])
+				if err != nil {
+					log.Fatalf("load storage timeout failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			sweepInterval: func() time.Duration {
+				if envMap["STORAGE_SWEEP_INTERVAL"] == "" {
+					return time.Hour
+				}
+				t, err := strconv.Atoi(envMap["STORAGE_SWEEP_INTERVAL"])
+				if err != nil {
+					log.Fatalf("load sweep interval failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			orphanGrace: func() time.Duration {
+				if envMap["STORAGE_ORPHAN_GRACE"] == "" {
+					return 24 * time.Hour
+				}
+				t, err := strconv.Atoi(envMap["STORAGE_ORPHAN_GRACE"])
+				if err != nil {
+					log.Fatalf("load orphan grace failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			urlSecret: func() string {
+				// The jwt secret is empty when tokens are signed with a keyset file.
+				// A secret made at start is fine for development only, the signed
+				// urls break on restart and between replicas.
+				if envMap["STORAGE_URL_SECRET"] == "" {
+					secret := make([]byte, 32)
+					if _, err := rand.Read(secret); err != nil {
+						log.Fatalf("generate storage url secret failed: %v", err)
+					}
+					log.Printf("STORAGE_URL_SECRET is not set, the signed urls of private files only last until the app restarts")
+					return hex.EncodeToString(secret)
+				}
+				return envMap["STORAGE_URL_SECRET"]
+			}(),
+			urlExpires: func() time.Duration {
+				if envMap["STORAGE_URL_EXPIRES"] == "" {
+					return 15 * time.Minute
+				}
+				t, err := strconv.Atoi(envMap["STORAGE_URL_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load url expires failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			// Unset, the links are relative to the app, e.g. /images/products/x.png.
+			// APP_HOST is the address listened on, often 0.0.0.0, never one to link to
+			publicUrl: strings.TrimSuffix(envMap["STORAGE_PUBLIC_URL"], "/"),
+			cacheControl: func() map[string]string {
+				// prefix=policy pairs separated by semicolons, e.g. images/products=public, max-age=31536000, immutable
+				raw := envMap["STORAGE_CACHE_CONTROL"]
+				if raw == "" {
+					raw = "images=public, max-age=86400"
+				}
+
+				policies := make(map[string]string)
+				for _, entry := range strings.Split(raw, ";") {
+					if strings.TrimSpace(entry) == "" {
+						continue
+					}
+					prefix, policy, ok := strings.Cut(entry, "=")
+					if !ok || strings.TrimSpace(policy) == "" {
+						log.Fatalf("load cache control failed: %q is invalid", entry)
+					}
+					policies[strings.Trim(strings.TrimSpace(prefix), "/")] = strings.TrimSpace(policy)
+				}
+				return policies
+			}(),
+		},
+		mail: &mail{
+			driver: func() string {
+				// Reset and verification mails written to the log never reach
+				// anyone, fine for development only
+				switch envMap["MAIL_DRIVER"] {
+				case "":
+					log.Printf("MAIL_DRIVER is not set, mails are written to the log instead of being sent")
+					return "log"
+				case "smtp", "file", "memory", "log":
+					return envMap["MAIL_DRIVER"]
+				default:
+					log.Fatalf("load mail driver failed: %q is not supported", envMap["MAIL_DRIVER"])
+					return ""
+				}
+			}(),
+			from: func() string {
+				if envMap["MAIL_FROM"] == "" {
+					return "no-reply@localhost"
+				}
+				return envMap["MAIL_FROM"]
+			}(),
+			smtpHost: envMap["MAIL_SMTP_HOST"],
+			smtpPort: func() int {
+				if envMap["MAIL_SMTP_PORT"] == "" {
+					return 587
+				}
+				p, err := strconv.Atoi(envMap["MAIL_SMTP_PORT"])
+				if err != nil {
+					log.Fatalf("load smtp port failed: %v", err)
+				}
+				return p
+			}(),
+			smtpUsername: envMap["MAIL_SMTP_USERNAME"],
+			smtpPassword: envMap["MAIL_SMTP_PASSWORD"],
+			fileDir: func() string {
+				if envMap["MAIL_FILE_DIR"] == "" {
+					return "./assets/mails"
+				}
+				return envMap["MAIL_FILE_DIR"]
+			}(),
+			appUrl: func() string {
+				// The links of the mails open the app itself unless a storefront serves them
+				if envMap["MAIL_APP_URL"] == "" {
+					return fmt.Sprintf("http://%s:%s", envMap["APP_HOST"], envMap["APP_PORT"])
+				}
+				return strings.TrimSuffix(envMap["MAIL_APP_URL"], "/")
+			}(),
+			passwordResetExpires: func() time.Duration {
+				if envMap["MAIL_PASSWORD_RESET_EXPIRES"] == "" {
+					return 30 * time.Minute
+				}
+				t, err := strconv.Atoi(envMap["MAIL_PASSWORD_RESET_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load password reset expires failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			passwordResetInterval: func() time.Duration {
+				if envMap["MAIL_PASSWORD_RESET_INTERVAL"] == "" {
+					return time.Minute
+				}
+				t, err := strconv.Atoi(envMap["MAIL_PASSWORD_RESET_INTERVAL"])
+				if err != nil {
+					log.Fatalf("load password reset interval failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			verificationExpires: func() time.Duration {
+				if envMap["MAIL_VERIFICATION_EXPIRES"] == "" {
+					return 24 * time.Hour
+				}
+				t, err := strconv.Atoi(envMap["MAIL_VERIFICATION_EXPIRES"])
+				if err != nil {
+					log.Fatalf("load verification expires failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			verificationResendInterval: func() time.Duration {
+				if envMap["MAIL_VERIFICATION_RESEND_INTERVAL"] == "" {
+					return time.Minute
+				}
+				t, err := strconv.Atoi(envMap["MAIL_VERIFICATION_RESEND_INTERVAL"])
+				if err != nil {
+					log.Fatalf("load verification resend interval failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+		},
+		totp: &totp{
+			issuer: func() string {
+				// The name authenticator apps list the account under
+				if envMap["TOTP_ISSUER"] == "" {
+					return envMap["APP_NAME"]
+				}
+				return envMap["TOTP_ISSUER"]
+			}(),
+			adminRequired: func() bool {
+				if envMap["TOTP_ADMIN_REQUIRED"] == "" {
+					return false
+				}
+				b, err := strconv.ParseBool(envMap["TOTP_ADMIN_REQUIRED"])
+				if err != nil {
+					log.Fatalf("load totp admin required failed: %v", err)
+				}
+				return b
+			}(),
+			recoveryCodes: func() int {
+				if envMap["TOTP_RECOVERY_CODES"] == "" {
+					return 10
+				}
+				n, err := strconv.Atoi(envMap["TOTP_RECOVERY_CODES"])
+				if err != nil {
+					log.Fatalf("load totp recovery codes failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load totp recovery codes failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			secretKey: func() []byte {
+				// 32 bytes hex encoded, e.g. openssl rand -hex 32. Losing it loses
+				// every enrolled authenticator, it is kept like a database backup.
+				if envMap["TOTP_SECRET_KEY"] == "" {
+					log.Printf("TOTP_SECRET_KEY is not set, two-factor authentication cannot be set up")
+					return nil
+				}
+				key, err := hex.DecodeString(envMap["TOTP_SECRET_KEY"])
+				if err != nil || len(key) != 32 {
+					log.Fatalf("load totp secret key failed: it must be 32 bytes hex encoded")
+				}
+				return key
+			}(),
+		},
+		login: &login{
+			backoffAfter: func() int {
+				if envMap["LOGIN_BACKOFF_AFTER"] == "" {
+					return 3
+				}
+				n, err := strconv.Atoi(envMap["LOGIN_BACKOFF_AFTER"])
+				if err != nil {
+					log.Fatalf("load login backoff after failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load login backoff after failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			backoffBase: func() time.Duration {
+				if envMap["LOGIN_BACKOFF_BASE"] == "" {
+					return time.Second
+				}
+				t, err := strconv.Atoi(envMap["LOGIN_BACKOFF_BASE"])
+				if err != nil {
+					log.Fatalf("load login backoff base failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			lockoutThreshold: func() int {
+				if envMap["LOGIN_LOCKOUT_THRESHOLD"] == "" {
+					return 10
+				}
+				n, err := strconv.Atoi(envMap["LOGIN_LOCKOUT_THRESHOLD"])
+				if err != nil {
+					log.Fatalf("load login lockout threshold failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load login lockout threshold failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			lockoutDuration: func() time.Duration {
+				if envMap["LOGIN_LOCKOUT_DURATION"] == "" {
+					return 15 * time.Minute
+				}
+				t, err := strconv.Atoi(envMap["LOGIN_LOCKOUT_DURATION"])
+				if err != nil {
+					log.Fatalf("load login lockout duration failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+			ipThreshold: func() int {
+				// Off by default, behind a proxy without APP_PROXY_HEADER every
+				// client shares an ip and would be blocked together
+				if envMap["LOGIN_IP_THRESHOLD"] == "" {
+					return 0
+				}
+				n, err := strconv.Atoi(envMap["LOGIN_IP_THRESHOLD"])
+				if err != nil {
+					log.Fatalf("load login ip threshold failed: %v", err)
+				}
+				if n < 0 {
+					log.Fatalf("load login ip threshold failed: %d is negative", n)
+				}
+				return n
+			}(),
+			failureWindow: func() time.Duration {
+				if envMap["LOGIN_FAILURE_WINDOW"] == "" {
+					return time.Hour
+				}
+				t, err := strconv.Atoi(envMap["LOGIN_FAILURE_WINDOW"])
+				if err != nil {
+					log.Fatalf("load login failure window failed: %v", err)
+				}
+				return time.Duration(int64(t) * int64(math.Pow10(9)))
+			}(),
+		},
+		password: &password{
+			hasher: func() string {
+				// Existing bcrypt hashes are still verified and upgraded at sign in
+				switch envMap["PASSWORD_HASHER"] {
+				case "":
+					return "argon2id"
+				case "argon2id", "bcrypt":
+					return envMap["PASSWORD_HASHER"]
+				default:
+					log.Fatalf("load password hasher failed: %q is not supported", envMap["PASSWORD_HASHER"])
+					return ""
+				}
+			}(),
+			argon2Memory: func() int {
+				if envMap["PASSWORD_ARGON2_MEMORY"] == "" {
+					return 64 * 1024
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_ARGON2_MEMORY"])
+				if err != nil {
+					log.Fatalf("load argon2 memory failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load argon2 memory failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			argon2Iterations: func() int {
+				if envMap["PASSWORD_ARGON2_ITERATIONS"] == "" {
+					return 3
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_ARGON2_ITERATIONS"])
+				if err != nil {
+					log.Fatalf("load argon2 iterations failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load argon2 iterations failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			argon2Parallelism: func() int {
+				if envMap["PASSWORD_ARGON2_PARALLELISM"] == "" {
+					return 2
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_ARGON2_PARALLELISM"])
+				if err != nil {
+					log.Fatalf("load argon2 parallelism failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load argon2 parallelism failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			argon2Concurrency: func() int {
+				if envMap["PASSWORD_ARGON2_CONCURRENCY"] == "" {
+					return 4
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_ARGON2_CONCURRENCY"])
+				if err != nil {
+					log.Fatalf("load argon2 concurrency failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load argon2 concurrency failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			bcryptCost: func() int {
+				if envMap["PASSWORD_BCRYPT_COST"] == "" {
+					return 10
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_BCRYPT_COST"])
+				if err != nil {
+					log.Fatalf("load bcrypt cost failed: %v", err)
+				}
+				if n < 4 || n > 31 {
+					log.Fatalf("load bcrypt cost failed: %d is not within 4 and 31", n)
+				}
+				return n
+			}(),
+			minLength: func() int {
+				if envMap["PASSWORD_MIN_LENGTH"] == "" {
+					return 8
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_MIN_LENGTH"])
+				if err != nil {
+					log.Fatalf("load password min length failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load password min length failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			maxLength: func() int {
+				if envMap["PASSWORD_MAX_LENGTH"] == "" {
+					return 128
+				}
+				n, err := strconv.Atoi(envMap["PASSWORD_MAX_LENGTH"])
+				if err != nil {
+					log.Fatalf("load password max length failed: %v", err)
+				}
+				if n < 1 {
+					log.Fatalf("load password max length failed: %d is not positive", n)
+				}
+				return n
+			}(),
+			breachedList: envMap["PASSWORD_BREACHED_LIST"],
 		},
 	}
 }
@@ -106,12 +670,22 @@ type IConfig interface {
 	App() IAppConfig
 	Db() IDbConfig
 	Jwt() IJwtConfig
+	Storage() IStorageConfig
+	Mail() IMailConfig
+	Totp() ITotpConfig
+	Login() ILoginConfig
+	Password() IPasswordConfig
 }
 
 type config struct {
-	app *app
-	db  *db
-	jwt *jwt
+	app      *app
+	db       *db
+	jwt      *jwt
+	storage  *storage
+	mail     *mail
+	totp     *totp
+	login    *login
+	password *password
 }
 
 type IAppConfig interface {
@@ -123,35 +697,56 @@ type IAppConfig interface {
 	BodyLimit() int
 	FileLimit() int
 	GCPBucket() string
+	ImageMaxWidth() int
+	ImageMaxHeight() int
+	ImageDerivatives() map[string]int
+	ImageDerivativeFormat() string // jpeg | webp
+	RequireVerifiedEmail() bool    // unverified customers may browse but not order
+	ProxyHeader() string           // the header holding the client ip, empty without proxies
+	TrustedProxies() []string      // ips or cidrs of the proxies allowed to set it
 	Host() string
 	Port() int
 }
 
 type app struct {
-	host         string
-	port         int
-	name         string
-	version      string
-	readTimeout  time.Duration
-	writeTimeout time.Duration
-	bodyLimit    int //bytes
-	fileLimit    int //bytes
-	gcpbucket    string
+	host                  string
+	port                  int
+	name                  string
+	version               string
+	readTimeout           time.Duration
+	writeTimeout          time.Duration
+	bodyLimit             int //bytes
+	fileLimit             int //bytes
+	gcpbucket             string
+	imageMaxWidth         int //pixels
+	imageMaxHeight        int //pixels
+	imageDerivatives      map[string]int
+	imageDerivativeFormat string
+	requireVerifiedEmail  bool
+	proxyHeader           string
+	trustedProxies        []string
 }
 
 func (c *config) App() IAppConfig {
 	return c.app
 }
-func (a *app) Url() string                 { return fmt.Sprintf("%s:%d", a.host, a.port) } // host:port
-func (a *app) Name() string                { return a.name }
-func (a *app) Version() string             { return a.version }
-func (a *app) ReadTimeout() time.Duration  { return a.readTimeout }
-func (a *app) WriteTimeout() time.Duration { return a.writeTimeout }
-func (a *app) BodyLimit() int              { return a.bodyLimit }
-func (a *app) FileLimit() int              { return a.fileLimit }
-func (a *app) GCPBucket() string           { return a.gcpbucket }
-func (a *app) Host() string                { return a.host }
-func (a *app) Port() int                   { return a.port }
+func (a *app) Url() string                      { return fmt.Sprintf("%s:%d", a.host, a.port) } // host:port
+func (a *app) Name() string                     { return a.name }
+func (a *app) Version() string                  { return a.version }
+func (a *app) ReadTimeout() time.Duration       { return a.readTimeout }
+func (a *app) WriteTimeout() time.Duration      { return a.writeTimeout }
+func (a *app) BodyLimit() int                   { return a.bodyLimit }
+func (a *app) FileLimit() int                   { return a.fileLimit }
+func (a *app) GCPBucket() string                { return a.gcpbucket }
+func (a *app) ImageMaxWidth() int               { return a.imageMaxWidth }
+func (a *app) ImageMaxHeight() int              { return a.imageMaxHeight }
+func (a *app) ImageDerivatives() map[string]int { return a.imageDerivatives }
+func (a *app) ImageDerivativeFormat() string    { return a.imageDerivativeFormat }
+func (a *app) RequireVerifiedEmail() bool       { return a.requireVerifiedEmail }
+func (a *app) ProxyHeader() string              { return a.proxyHeader }
+func (a *app) TrustedProxies() []string         { return a.trustedProxies }
+func (a *app) Host() string                     { return a.host }
+func (a *app) Port() int                        { return a.port }
 
 type IDbConfig interface {
 	Url() string
@@ -189,18 +784,28 @@ type IJwtConfig interface {
 	SecretKey() []byte
 	AdminKey() []byte
 	ApiKey() []byte
+	KeysetFile() string  // empty signs with the secrets above
+	LegacyApiKeys() bool // still accept the jwt api keys issued before the database keys
+	// BootstrapApiKey is registered as a database key at start, so a fresh
+	// deployment can sign in before any key was created. Empty for none.
+	BootstrapApiKey() string
 	AccessExpiresAt() int
 	RefreshExpiresAt() int
+	ChallengeExpiresAt() int // a password checked waiting for its second factor
 	SetJwtAccessExpires(t int)
 	SetJwtRefreshExpires(t int)
 }
 
 type jwt struct {
-	secertKey        string
-	adminKey         string
-	apiKey           string
-	accessExpiresAt  int //sec
-	refreshExpiresAt int //sec
+	secertKey          string
+	adminKey           string
+	apiKey             string
+	keysetFile         string //json file of the signing keys, see pkg/auth
+	legacyApiKeys      bool
+	bootstrapApiKey    string
+	accessExpiresAt    int //sec
+	refreshExpiresAt   int //sec
+	challengeExpiresAt int //sec
 }
 
 func (c *config) Jwt() IJwtConfig {
@@ -209,7 +814,207 @@ func (c *config) Jwt() IJwtConfig {
 func (j *jwt) SecretKey() []byte          { return []byte(j.secertKey) }
 func (j *jwt) AdminKey() []byte           { return []byte(j.adminKey) }
 func (j *jwt) ApiKey() []byte             { return []byte(j.apiKey) }
+func (j *jwt) KeysetFile() string         { return j.keysetFile }
+func (j *jwt) LegacyApiKeys() bool        { return j.legacyApiKeys }
+func (j *jwt) BootstrapApiKey() string    { return j.bootstrapApiKey }
 func (j *jwt) AccessExpiresAt() int       { return j.accessExpiresAt }
 func (j *jwt) RefreshExpiresAt() int      { return j.refreshExpiresAt }
+func (j *jwt) ChallengeExpiresAt() int    { return j.challengeExpiresAt }
 func (j *jwt) SetJwtAccessExpires(t int)  { j.accessExpiresAt = t }
 func (j *jwt) SetJwtRefreshExpires(t int) { j.refreshExpiresAt = t }
+
+type IStorageConfig interface {
+	Driver() string // local | gcs | s3 | memory
+	LocalRoot() string
+	UploadDir() string
+	S3Bucket() string
+	S3Region() string
+	S3Endpoint() string
+	S3AccessKey() string
+	S3SecretKey() string
+	S3PathStyle() bool
+	Workers() int                 // uploads and deletes of a batch running at once
+	Timeout() time.Duration       // for a whole batch
+	SweepInterval() time.Duration // 0 disables the orphan sweeper
+	OrphanGrace() time.Duration
+	UrlSecret() []byte // signs the download urls of private files
+	UrlExpires() time.Duration
+	PublicUrl() string               // base of the links to files served by the app, empty for relative links
+	CacheControl() map[string]string // policy by key prefix
+}
+
+type storage struct {
+	driver        string
+	localRoot     string
+	uploadDir     string //resumable uploads are staged here
+	s3Bucket      string
+	s3Region      string
+	s3Endpoint    string //empty for AWS
+	s3AccessKey   string
+	s3SecretKey   string
+	s3PathStyle   bool
+	workers       int
+	timeout       time.Duration
+	sweepInterval time.Duration
+	orphanGrace   time.Duration //unreferenced files are kept this long before the sweeper removes them
+	urlSecret     string
+	urlExpires    time.Duration
+	publicUrl     string
+	cacheControl  map[string]string
+}
+
+func (c *config) Storage() IStorageConfig {
+	return c.storage
+}
+func (s *storage) Driver() string                  { return s.driver }
+func (s *storage) LocalRoot() string               { return s.localRoot }
+func (s *storage) UploadDir() string               { return s.uploadDir }
+func (s *storage) S3Bucket() string                { return s.s3Bucket }
+func (s *storage) S3Region() string                { return s.s3Region }
+func (s *storage) S3Endpoint() string              { return s.s3Endpoint }
+func (s *storage) S3AccessKey() string             { return s.s3AccessKey }
+func (s *storage) S3SecretKey() string             { return s.s3SecretKey }
+func (s *storage) S3PathStyle() bool               { return s.s3PathStyle }
+func (s *storage) Workers() int                    { return s.workers }
+func (s *storage) Timeout() time.Duration          { return s.timeout }
+func (s *storage) SweepInterval() time.Duration    { return s.sweepInterval }
+func (s *storage) OrphanGrace() time.Duration      { return s.orphanGrace }
+func (s *storage) UrlSecret() []byte               { return []byte(s.urlSecret) }
+func (s *storage) UrlExpires() time.Duration       { return s.urlExpires }
+func (s *storage) PublicUrl() string               { return s.publicUrl }
+func (s *storage) CacheControl() map[string]string { return s.cacheControl }
+
+type IMailConfig interface {
+	Driver() string // smtp | file | memory
+	From() string
+	SmtpHost() string
+	SmtpPort() int
+	SmtpUsername() string
+	SmtpPassword() string
+	FileDir() string
+	AppUrl() string // base of the links in mails
+	PasswordResetExpires() time.Duration
+	PasswordResetInterval() time.Duration // least time between two reset mails
+	VerificationExpires() time.Duration
+	VerificationResendInterval() time.Duration // least time between two verification mails
+}
+
+type mail struct {
+	driver                     string
+	from                       string
+	smtpHost                   string
+	smtpPort                   int
+	smtpUsername               string
+	smtpPassword               string
+	fileDir                    string //the file driver writes each mail here
+	appUrl                     string
+	passwordResetExpires       time.Duration
+	passwordResetInterval      time.Duration
+	verificationExpires        time.Duration
+	verificationResendInterval time.Duration
+}
+
+func (c *config) Mail() IMailConfig {
+	return c.mail
+}
+func (m *mail) Driver() string                            { return m.driver }
+func (m *mail) From() string                              { return m.from }
+func (m *mail) SmtpHost() string                          { return m.smtpHost }
+func (m *mail) SmtpPort() int                             { return m.smtpPort }
+func (m *mail) SmtpUsername() string                      { return m.smtpUsername }
+func (m *mail) SmtpPassword() string                      { return m.smtpPassword }
+func (m *mail) FileDir() string                           { return m.fileDir }
+func (m *mail) AppUrl() string                            { return m.appUrl }
+func (m *mail) PasswordResetExpires() time.Duration       { return m.passwordResetExpires }
+func (m *mail) PasswordResetInterval() time.Duration      { return m.passwordResetInterval }
+func (m *mail) VerificationExpires() time.Duration        { return m.verificationExpires }
+func (m *mail) VerificationResendInterval() time.Duration { return m.verificationResendInterval }
+
+type ITotpConfig interface {
+	Issuer() string
+	AdminRequired() bool // users whose role grants permissions cannot use them until they enable 2fa
+	RecoveryCodes() int
+	SecretKey() []byte // encrypts the secrets at rest, nil when not configured
+}
+
+type totp struct {
+	issuer        string
+	adminRequired bool
+	recoveryCodes int
+	secretKey     []byte
+}
+
+func (c *config) Totp() ITotpConfig {
+	return c.totp
+}
+func (t *totp) Issuer() string      { return t.issuer }
+func (t *totp) AdminRequired() bool { return t.adminRequired }
+func (t *totp) RecoveryCodes() int  { return t.recoveryCodes }
+func (t *totp) SecretKey() []byte   { return t.secretKey }
+
+// ILoginConfig slows down password guessing, every failed sign in counts
+// against the account and the ip it came from.
+type ILoginConfig interface {
+	BackoffAfter() int          // failures of an account before each one makes it wait
+	BackoffBase() time.Duration // the first wait, doubled by every failure after
+	LockoutThreshold() int      // failures of an account locking it
+	LockoutDuration() time.Duration
+	IpThreshold() int             // failures of an ip blocking it, whatever the accounts, 0 is off
+	FailureWindow() time.Duration // failures older than this are forgotten
+}
+
+type login struct {
+	backoffAfter     int
+	backoffBase      time.Duration
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+	ipThreshold      int
+	failureWindow    time.Duration
+}
+
+func (c *config) Login() ILoginConfig {
+	return c.login
+}
+func (l *login) BackoffAfter() int              { return l.backoffAfter }
+func (l *login) BackoffBase() time.Duration     { return l.backoffBase }
+func (l *login) LockoutThreshold() int          { return l.lockoutThreshold }
+func (l *login) LockoutDuration() time.Duration { return l.lockoutDuration }
+func (l *login) IpThreshold() int               { return l.ipThreshold }
+func (l *login) FailureWindow() time.Duration   { return l.failureWindow }
+
+type IPasswordConfig interface {
+	Hasher() string    // argon2id | bcrypt, what new hashes are made with
+	Argon2Memory() int // KiB
+	Argon2Iterations() int
+	Argon2Parallelism() int
+	Argon2Concurrency() int // hashes computed at once, each takes Argon2Memory
+	BcryptCost() int
+	MinLength() int // characters
+	MaxLength() int
+	BreachedList() string // file of known breached passwords, one a line, empty for none
+}
+
+type password struct {
+	hasher            string
+	argon2Memory      int
+	argon2Iterations  int
+	argon2Parallelism int
+	argon2Concurrency int
+	bcryptCost        int
+	minLength         int
+	maxLength         int
+	breachedList      string
+}
+
+func (c *config) Password() IPasswordConfig {
+	return c.password
+}
+func (p *password) Hasher() string         { return p.hasher }
+func (p *password) Argon2Memory() int      { return p.argon2Memory }
+func (p *password) Argon2Iterations() int  { return p.argon2Iterations }
+func (p *password) Argon2Parallelism() int { return p.argon2Parallelism }
+func (p *password) Argon2Concurrency() int { return p.argon2Concurrency }
+func (p *password) BcryptCost() int        { return p.bcryptCost }
+func (p *password) MinLength() int         { return p.minLength }
+func (p *password) MaxLength() int         { return p.maxLength }
+func (p *password) BreachedList() string   { return p.breachedList }
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"log"
 	"os"
 
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/servers"
+	"github.com/jetsadawwts/go-restapi/pkg/auth"
 	"github.com/jetsadawwts/go-restapi/pkg/databases"
+	"github.com/jetsadawwts/go-restapi/pkg/mailer"
+	"github.com/jetsadawwts/go-restapi/pkg/password"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
 )
 
 func envPath() string {
@@ -23,5 +28,29 @@ func main() {
 	db := databases.DbConnect(cfg.Db())
 	defer db.Close()
 
-	servers.NewServer(cfg, db).Start()
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		log.Fatalf("init storage failed: %v", err)
+	}
+
+	keyset, err := auth.NewKeyset(cfg.Jwt())
+	if err != nil {
+		log.Fatalf("init keyset failed: %v", err)
+	}
+
+	mail, err := mailer.NewMailer(cfg.Mail())
+	if err != nil {
+		log.Fatalf("init mailer failed: %v", err)
+	}
+
+	hasher, err := password.NewHasher(cfg.Password())
+	if err != nil {
+		log.Fatalf("init password hasher failed: %v", err)
+	}
+	policy, err := password.NewPolicy(cfg.Password())
+	if err != nil {
+		log.Fatalf("init password policy failed: %v", err)
+	}
+
+	servers.NewServer(cfg, db, store, storage.NewSigner(cfg), keyset, mail, hasher, policy).Start()
 }
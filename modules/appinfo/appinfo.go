@@ -1,5 +1,11 @@
 package appinfo
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type CategoryFilter struct {
 	Title string `query:"title"`
 }
@@ -8,4 +14,79 @@ type CategoryFilter struct {
 type Category struct {
 	Id int `db:"id"  json:"id"`
 	Title string `db:"title"  json:"title"`
-}
\ No newline at end of file
+}
+
+// Scopes an api key may be granted, a route asks for the ones it needs
+const (
+	ScopeProductsRead   = "products:read"
+	ScopeCategoriesRead = "categories:read"
+)
+
+var ApiKeyScopes = []string{
+	ScopeProductsRead,
+	ScopeCategoriesRead,
+}
+
+func IsApiKeyScope(scope string) bool {
+	for _, s := range ApiKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrApiKeyNotFound = errors.New("api key not found")
+
+type ApiKey struct {
+	Id         string   `db:"id" json:"id"`
+	Name       string   `db:"name" json:"name"`
+	Prefix     string   `db:"prefix" json:"prefix"`
+	OwnerId    string   `db:"owner_id" json:"owner_id"`
+	Scopes     []string `db:"scopes" json:"scopes"`
+	ExpiresAt  *string  `db:"expires_at" json:"expires_at"`
+	LastUsedAt *string  `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  *string  `db:"revoked_at" json:"revoked_at"`
+	CreatedAt  string   `db:"created_at" json:"created_at"`
+	// Key is only filled in once, when the key is created
+	Key string `db:"-" json:"key,omitempty"`
+	// KeyHash is what the key is looked up by, never sent
+	KeyHash string `db:"key_hash" json:"-"`
+}
+
+type ApiKeyReq struct {
+	Name      string   `json:"name" form:"name"`
+	OwnerId   string   `json:"owner_id" form:"owner_id"` // the caller when empty
+	Scopes    []string `json:"scopes" form:"scopes"`
+	ExpiresAt string   `json:"expires_at" form:"expires_at"` // RFC 3339, never expires when empty
+}
+
+// Validate checks the name, that every scope is known and the expiry is ahead
+func (obj *ApiKeyReq) Validate() error {
+	if obj.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(obj.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range obj.Scopes {
+		if !IsApiKeyScope(scope) {
+			return fmt.Errorf("scope %q is unknown", scope)
+		}
+	}
+	if obj.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, obj.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("expires_at is not an RFC 3339 time")
+		}
+		if !expiresAt.After(time.Now()) {
+			return fmt.Errorf("expires_at has already passed")
+		}
+	}
+	return nil
+}
+
+type ApiKeyFilter struct {
+	OwnerId string `query:"owner_id"`
+	Revoked bool   `query:"revoked"` // also list the revoked keys
+}
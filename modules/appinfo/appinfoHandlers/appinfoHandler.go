@@ -1,6 +1,7 @@
 package appinfohandlers
 
 import (
+	"errors"
 	"strconv"
 	"strings"
 
@@ -9,21 +10,25 @@ import (
 	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo/appinfoUsecases"
 
+	"github.com/google/uuid"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
-	"github.com/jetsadawwts/go-restapi/pkg/auth"
 )
 
 type appinfoHandlersErrCode string
 
 const (
-	generateApiKeyErr appinfoHandlersErrCode = "appinfo-001"
+	createApiKeyErr   appinfoHandlersErrCode = "appinfo-001"
 	findCategoryErr   appinfoHandlersErrCode = "appinfo-002"
 	addCategoryErr    appinfoHandlersErrCode = "appinfo-003"
 	deleteCategoryErr appinfoHandlersErrCode = "appinfo-004"
+	findApiKeysErr    appinfoHandlersErrCode = "appinfo-005"
+	revokeApiKeyErr   appinfoHandlersErrCode = "appinfo-006"
 )
 
 type IAppinfoHandler interface {
-	GenerateApiKey(c *fiber.Ctx) error
+	CreateApiKey(c *fiber.Ctx) error
+	FindApiKeys(c *fiber.Ctx) error
+	RevokeApiKey(c *fiber.Ctx) error
 	FindCategory(c *fiber.Ctx) error
 	AddCategory(c *fiber.Ctx) error
 	RemoveCategory(c *fiber.Ctx) error
@@ -38,28 +43,84 @@ func AppinfoHandler(cfg config.IConfig, appinfoUsecase appinfoUsecases.IAppinfoU
 	return &appinfoHandler{cfg: cfg, appinfoUsecase: appinfoUsecase}
 }
 
-func (h *appinfoHandler) GenerateApiKey(c *fiber.Ctx) error {
-	apiKey, err := auth.NewAuth(
-		auth.ApiKey,
-		h.cfg.Jwt(),
-		nil,
-	)
+func (h *appinfoHandler) CreateApiKey(c *fiber.Ctx) error {
+	req := new(appinfo.ApiKeyReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createApiKeyErr),
+			err.Error(),
+		).Res()
+	}
+	if err := req.Validate(); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createApiKeyErr),
+			err.Error(),
+		).Res()
+	}
+	if req.OwnerId == "" {
+		req.OwnerId = c.Locals("userId").(string)
+	}
 
+	apiKey, err := h.appinfoUsecase.InsertApiKey(req)
 	if err != nil {
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
-			string(generateApiKeyErr),
+			string(createApiKeyErr),
 			err.Error(),
 		).Res()
 	}
-	return entities.NewResponse(c).Success(
-		fiber.StatusOK,
-		&struct {
-			Key string `json:"key"`
-		}{
-			Key: apiKey.SignToken(),
-		},
-	).Res()
+	return entities.NewResponse(c).Success(fiber.StatusCreated, apiKey).Res()
+}
+
+func (h *appinfoHandler) FindApiKeys(c *fiber.Ctx) error {
+	req := new(appinfo.ApiKeyFilter)
+	if err := c.QueryParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(findApiKeysErr),
+			err.Error(),
+		).Res()
+	}
+
+	apiKeys, err := h.appinfoUsecase.FindApiKeys(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(findApiKeysErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, apiKeys).Res()
+}
+
+func (h *appinfoHandler) RevokeApiKey(c *fiber.Ctx) error {
+	apiKeyId := strings.Trim(c.Params("apikey_id"), " ")
+	if _, err := uuid.Parse(apiKeyId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(revokeApiKeyErr),
+			"api key id is invalid",
+		).Res()
+	}
+
+	apiKey, err := h.appinfoUsecase.RevokeApiKey(apiKeyId)
+	if err != nil {
+		if errors.Is(err, appinfo.ErrApiKeyNotFound) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(revokeApiKeyErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(revokeApiKeyErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, apiKey).Res()
 }
 
 func (h *appinfoHandler) FindCategory(c *fiber.Ctx) error {
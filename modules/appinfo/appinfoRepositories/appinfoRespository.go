@@ -2,9 +2,13 @@ package appinfoRepositories
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	"github.com/jmoiron/sqlx"
 )
@@ -13,6 +17,11 @@ type IAppinfoRepository interface {
 	FindCategory(req *appinfo.CategoryFilter) ([]*appinfo.Category, error)
 	InsertCategory(req []*appinfo.Category) error
 	DeleteCategory(categoryId int) error
+	InsertApiKey(req *appinfo.ApiKey, expiresAt string) error
+	FindApiKeys(req *appinfo.ApiKeyFilter) ([]*appinfo.ApiKey, error)
+	FindOneApiKey(apiKeyId string) (*appinfo.ApiKey, error)
+	SeedApiKey(req *appinfo.ApiKey, permission string) (bool, error)
+	RevokeApiKey(apiKeyId string) error
 }
 
 type appinfoRepository struct {
@@ -116,3 +125,169 @@ func (r *appinfoRepository) DeleteCategory(categoryId int) error {
 
 	return nil
 }
+
+// apiKeyColumns are the columns of a key as they are sent, never its hash
+const apiKeyColumns = `
+			"k"."id",
+			"k"."name",
+			"k"."prefix",
+			"k"."owner_id",
+			"k"."scopes",
+			"k"."expires_at",
+			"k"."last_used_at",
+			"k"."revoked_at",
+			"k"."created_at"`
+
+func (r *appinfoRepository) InsertApiKey(req *appinfo.ApiKey, expiresAt string) error {
+	query := `
+	INSERT INTO "api_keys" (
+		"name",
+		"prefix",
+		"key_hash",
+		"owner_id",
+		"scopes",
+		"expires_at"
+	)
+	VALUES ($1, $2, $3, $4, $5::varchar[], NULLIF($6, '')::timestamptz)
+	RETURNING "id";`
+
+	if err := r.db.QueryRowxContext(
+		context.Background(),
+		query,
+		req.Name,
+		req.Prefix,
+		req.KeyHash,
+		req.OwnerId,
+		req.Scopes,
+		expiresAt,
+	).Scan(&req.Id); err != nil {
+		return fmt.Errorf("insert api key failed: %v", err)
+	}
+	return nil
+}
+
+func (r *appinfoRepository) FindApiKeys(req *appinfo.ApiKeyFilter) ([]*appinfo.ApiKey, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t")), '[]'::json)
+	FROM (
+		SELECT` + apiKeyColumns + `
+		FROM "api_keys" "k"
+		WHERE 1 = 1`
+
+	filterValues := make([]any, 0)
+	if req.OwnerId != "" {
+		filterValues = append(filterValues, req.OwnerId)
+		query += fmt.Sprintf(`
+		AND "k"."owner_id" = $%d`, len(filterValues))
+	}
+	if !req.Revoked {
+		query += `
+		AND "k"."revoked_at" IS NULL`
+	}
+	query += `
+		ORDER BY "k"."created_at" DESC
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, filterValues...); err != nil {
+		return nil, fmt.Errorf("get api keys failed: %v", err)
+	}
+
+	apiKeys := make([]*appinfo.ApiKey, 0)
+	if err := json.Unmarshal(raw, &apiKeys); err != nil {
+		return nil, fmt.Errorf("unmarshal api keys failed: %v", err)
+	}
+	return apiKeys, nil
+}
+
+func (r *appinfoRepository) FindOneApiKey(apiKeyId string) (*appinfo.ApiKey, error) {
+	// Ids are uuids, anything else could never match
+	if _, err := uuid.Parse(apiKeyId); err != nil {
+		return nil, appinfo.ErrApiKeyNotFound
+	}
+
+	query := `
+	SELECT
+		to_jsonb("t")
+	FROM (
+		SELECT` + apiKeyColumns + `
+		FROM "api_keys" "k"
+		WHERE "k"."id" = $1
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, apiKeyId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, appinfo.ErrApiKeyNotFound
+		}
+		return nil, fmt.Errorf("get api key failed: %v", err)
+	}
+
+	apiKey := new(appinfo.ApiKey)
+	if err := json.Unmarshal(raw, apiKey); err != nil {
+		return nil, fmt.Errorf("unmarshal api key failed: %v", err)
+	}
+	return apiKey, nil
+}
+
+// SeedApiKey registers a key for the first user granted the permission unless
+// its hash is known, a revoked key stays revoked. It tells whether the key was
+// added.
+func (r *appinfoRepository) SeedApiKey(req *appinfo.ApiKey, permission string) (bool, error) {
+	query := `
+	INSERT INTO "api_keys" (
+		"name",
+		"prefix",
+		"key_hash",
+		"owner_id",
+		"scopes"
+	)
+	SELECT
+		$1,
+		$2,
+		$3,
+		"u"."id",
+		$4::varchar[]
+	FROM "users" "u"
+	JOIN "roles" "r" ON "r"."id" = "u"."role_id"
+	WHERE $5 = ANY("r"."permissions")
+	ORDER BY "u"."created_at" ASC
+	LIMIT 1
+	ON CONFLICT ("key_hash") DO NOTHING
+	RETURNING "id";`
+
+	if err := r.db.QueryRowxContext(
+		context.Background(),
+		query,
+		req.Name,
+		req.Prefix,
+		req.KeyHash,
+		req.Scopes,
+		permission,
+	).Scan(&req.Id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("seed api key failed: %v", err)
+	}
+	return true, nil
+}
+
+// RevokeApiKey stops a key from working at once, it is kept for the records
+func (r *appinfoRepository) RevokeApiKey(apiKeyId string) error {
+	query := `
+	UPDATE "api_keys" SET
+		"revoked_at" = now()
+	WHERE "id" = $1
+	AND "revoked_at" IS NULL;`
+
+	result, err := r.db.ExecContext(context.Background(), query, apiKeyId)
+	if err != nil {
+		return fmt.Errorf("revoke api key failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return appinfo.ErrApiKeyNotFound
+	}
+	return nil
+}
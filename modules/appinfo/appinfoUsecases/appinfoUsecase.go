@@ -1,14 +1,22 @@
 package appinfoUsecases
 
 import (
+	"log"
+
 	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo/appinfoRepositories"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/pkg/auth"
 )
 
 type IAppinfoUsecase interface {
 	FindCategory(req *appinfo.CategoryFilter) ([]*appinfo.Category, error)
 	InsertCategory(req []*appinfo.Category) error
 	DeleteCategory(categoryId int) error
+	InsertApiKey(req *appinfo.ApiKeyReq) (*appinfo.ApiKey, error)
+	FindApiKeys(req *appinfo.ApiKeyFilter) ([]*appinfo.ApiKey, error)
+	RevokeApiKey(apiKeyId string) (*appinfo.ApiKey, error)
+	SeedApiKey(key string) error
 }
 
 type appinfoUsecase struct {
@@ -40,3 +48,71 @@ func (u *appinfoUsecase) DeleteCategory(categoryId int) error {
 	}
 	return nil
 }
+
+// InsertApiKey creates a key, the key itself is only in the result of this call
+func (u *appinfoUsecase) InsertApiKey(req *appinfo.ApiKeyReq) (*appinfo.ApiKey, error) {
+	key, prefix, err := auth.NewApiKeySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := &appinfo.ApiKey{
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: auth.HashApiKey(key),
+		OwnerId: req.OwnerId,
+		Scopes:  req.Scopes,
+	}
+	if err := u.appinfoRepository.InsertApiKey(apiKey, req.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	result, err := u.appinfoRepository.FindOneApiKey(apiKey.Id)
+	if err != nil {
+		return nil, err
+	}
+	result.Key = key
+	return result, nil
+}
+
+func (u *appinfoUsecase) FindApiKeys(req *appinfo.ApiKeyFilter) ([]*appinfo.ApiKey, error) {
+	apiKeys, err := u.appinfoRepository.FindApiKeys(req)
+	if err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (u *appinfoUsecase) RevokeApiKey(apiKeyId string) (*appinfo.ApiKey, error) {
+	if err := u.appinfoRepository.RevokeApiKey(apiKeyId); err != nil {
+		return nil, err
+	}
+	apiKey, err := u.appinfoRepository.FindOneApiKey(apiKeyId)
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// SeedApiKey registers the bootstrap key of the config with every scope, it is
+// owned by the first user managing api keys and revoked like any other key.
+func (u *appinfoUsecase) SeedApiKey(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	apiKey := &appinfo.ApiKey{
+		Name:    "bootstrap",
+		Prefix:  key[:auth.ApiKeyPrefixLen],
+		KeyHash: auth.HashApiKey(key),
+		Scopes:  appinfo.ApiKeyScopes,
+	}
+	added, err := u.appinfoRepository.SeedApiKey(apiKey, roles.PermApiKeysManage)
+	if err != nil {
+		return err
+	}
+	if added {
+		log.Printf("bootstrap api key %s registered, create the keys of the clients and revoke it\n", apiKey.Prefix)
+	}
+	return nil
+}
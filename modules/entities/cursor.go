@@ -0,0 +1,84 @@
+package entities
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor is the keyset position a page starts after, it is handed to clients as an opaque string.
+type Cursor struct {
+	OrderBy string `json:"o"`
+	Sort    string `json:"s"`
+	Value   any    `json:"v"` //value of the sort key
+	Id      string `json:"i"`
+	Prev    bool   `json:"p"` //read backwards from the position
+}
+
+func EncodeCursor(c *Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(cursor string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	c := new(Cursor)
+	if err := json.Unmarshal(b, c); err != nil || c.Id == "" {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	return c, nil
+}
+
+// ParseCursor switches the request to cursor mode and checks the cursor was issued
+// for the same sort, keyset positions are meaningless under another order.
+func (p *PaginationReq) ParseCursor(orderBy, sort string) error {
+	p.CursorMode = true
+	if p.Cursor == "" {
+		return nil
+	}
+
+	c, err := DecodeCursor(p.Cursor)
+	if err != nil {
+		return err
+	}
+	if c.OrderBy != orderBy || c.Sort != sort {
+		return fmt.Errorf("cursor does not match the order")
+	}
+	p.Keyset = c
+	return nil
+}
+
+// KeysetPage trims the extra row a cursor query reads to know whether another
+// page follows, puts a page read backwards back in display order and returns
+// the cursors to the pages around it. cursors holds the position of each row.
+func KeysetPage[T any](p *PaginationReq, rows []T, cursors []*Cursor) ([]T, string, string) {
+	hasMore := len(rows) > p.Limit
+	if hasMore {
+		rows, cursors = rows[:p.Limit], cursors[:p.Limit]
+	}
+
+	backward := p.Keyset != nil && p.Keyset.Prev
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+
+	var next, prev string
+	if hasMore || backward {
+		next = EncodeCursor(cursors[len(cursors)-1])
+	}
+	if (hasMore && backward) || (!backward && p.Keyset != nil) {
+		first := *cursors[0]
+		first.Prev = true
+		prev = EncodeCursor(&first)
+	}
+	return rows, next, prev
+}
@@ -0,0 +1,141 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		want   *Cursor
+		ok     bool
+	}{
+		{
+			"string value",
+			EncodeCursor(&Cursor{OrderBy: "title", Sort: "ASC", Value: "Coffee", Id: "P000001"}),
+			&Cursor{OrderBy: "title", Sort: "ASC", Value: "Coffee", Id: "P000001"},
+			true,
+		},
+		{
+			// Numbers come back as float64 like any json number
+			"number value read backwards",
+			EncodeCursor(&Cursor{OrderBy: "price", Sort: "DESC", Value: 120.5, Id: "P000002", Prev: true}),
+			&Cursor{OrderBy: "price", Sort: "DESC", Value: 120.5, Id: "P000002", Prev: true},
+			true,
+		},
+		{"not base64", "not a cursor!", nil, false},
+		{"not json", "bm90IGpzb24", nil, false},
+		{"without id", EncodeCursor(&Cursor{OrderBy: "title", Sort: "ASC", Value: "Coffee"}), nil, false},
+		{"empty", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.cursor)
+			if (err == nil) != tt.ok {
+				t.Fatalf("DecodeCursor error = %v, want ok %v", err, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	cursor := EncodeCursor(&Cursor{OrderBy: "title", Sort: "ASC", Value: "Coffee", Id: "P000001"})
+
+	tests := []struct {
+		name    string
+		cursor  string
+		orderBy string
+		sort    string
+		keyset  bool
+		ok      bool
+	}{
+		{"first page", "", "title", "ASC", false, true},
+		{"same order", cursor, "title", "ASC", true, true},
+		{"another column", cursor, "price", "ASC", false, false},
+		{"another direction", cursor, "title", "DESC", false, false},
+		{"malformed", "%%%", "title", "ASC", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PaginationReq{Cursor: tt.cursor}
+			err := p.ParseCursor(tt.orderBy, tt.sort)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseCursor error = %v, want ok %v", err, tt.ok)
+			}
+			if !p.CursorMode {
+				t.Errorf("ParseCursor did not switch to cursor mode")
+			}
+			if (p.Keyset != nil) != tt.keyset {
+				t.Errorf("ParseCursor keyset = %+v, want set %v", p.Keyset, tt.keyset)
+			}
+		})
+	}
+}
+
+func TestKeysetPage(t *testing.T) {
+	cursorsOf := func(ids ...string) []*Cursor {
+		cursors := make([]*Cursor, len(ids))
+		for i, id := range ids {
+			cursors[i] = &Cursor{OrderBy: "id", Sort: "ASC", Value: id, Id: id}
+		}
+		return cursors
+	}
+	after := &Cursor{OrderBy: "id", Sort: "ASC", Value: "a", Id: "a"}
+	before := &Cursor{OrderBy: "id", Sort: "ASC", Value: "z", Id: "z", Prev: true}
+
+	tests := []struct {
+		name   string
+		keyset *Cursor
+		rows   []string // as read, one more than the limit when another page follows
+		want   []string
+		next   string // id of the next cursor, empty for none
+		prev   string // id of the prev cursor, empty for none
+	}{
+		{"first page with more", nil, []string{"b", "c", "d"}, []string{"b", "c"}, "c", ""},
+		{"only page", nil, []string{"b", "c"}, []string{"b", "c"}, "", ""},
+		{"middle page", after, []string{"b", "c", "d"}, []string{"b", "c"}, "c", "b"},
+		{"last page", after, []string{"b"}, []string{"b"}, "", "b"},
+		{"backwards with more", before, []string{"y", "x", "w"}, []string{"x", "y"}, "y", "x"},
+		{"backwards to the first page", before, []string{"y"}, []string{"y"}, "y", ""},
+		{"empty", after, []string{}, []string{}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PaginationReq{Limit: 2, CursorMode: true, Keyset: tt.keyset}
+			rows := append([]string(nil), tt.rows...)
+			got, next, prev := KeysetPage(p, rows, cursorsOf(rows...))
+
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+			checkCursor(t, "next", next, tt.next, false)
+			checkCursor(t, "prev", prev, tt.prev, true)
+		})
+	}
+}
+
+func checkCursor(t *testing.T, name, cursor, wantId string, wantPrev bool) {
+	t.Helper()
+	if wantId == "" {
+		if cursor != "" {
+			t.Errorf("%s cursor = %q, want none", name, cursor)
+		}
+		return
+	}
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		t.Errorf("%s cursor = %q, want the position of %s", name, cursor, wantId)
+		return
+	}
+	if c.Id != wantId || c.Prev != wantPrev {
+		t.Errorf("%s cursor = %+v, want id %s prev %v", name, c, wantId, wantPrev)
+	}
+}
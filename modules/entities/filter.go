@@ -1,10 +1,13 @@
 package entities
 
 type PaginationReq struct {
-	Page      int `query:"page"`
-	Limit     int `query:"limit"`
-	TotalPage int `query:"total_page" json:"total_page"`
-	TotalItem int `query:"total_item" json:"total_item"`
+	Page       int     `query:"page"`
+	Limit      int     `query:"limit"`
+	TotalPage  int     `query:"total_page" json:"total_page"`
+	TotalItem  int     `query:"total_item" json:"total_item"`
+	Cursor     string  `query:"cursor"` //present, even empty, for keyset pagination
+	CursorMode bool    `query:"-"`
+	Keyset     *Cursor `query:"-"`
 }
 
 type SortReq struct {
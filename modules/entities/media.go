@@ -1,8 +1,19 @@
 package entities
 
+import "encoding/json"
+
 type Image struct {
-	Id       string `db:"id"`
-	FileName string `db:"filename" json:"filename"`
-	Url      string `db:"url" json:"url"`
-	
+	Id       string            `db:"id"`
+	FileName string            `db:"filename" json:"filename"`
+	Url      string            `db:"url" json:"url"`
+	Variants map[string]string `db:"-" json:"variants,omitempty"` //derivative name to url
+}
+
+// VariantsJson is the value stored in the images.variants column
+func (i *Image) VariantsJson() string {
+	if len(i.Variants) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(i.Variants)
+	return string(b)
 }
@@ -8,6 +8,7 @@ import (
 type IResponse interface {
 	Success(code int, data any) IResponse
 	Error(code int, tractId, msg string) IResponse
+	ErrorDetails(code int, tractId, msg string, details any) IResponse
 	Res() error
 }
 
@@ -22,6 +23,7 @@ type Response struct {
 type ErrorResponse struct {
 	TraceId string `json:"trace_id"`
 	Msg     string `json:"message"`
+	Details any    `json:"details,omitempty"`
 }
 
 func NewResponse(c *fiber.Ctx) IResponse {
@@ -48,6 +50,17 @@ func (r *Response) Error(code int, tractId, msg string) IResponse {
 	//logger.InitLogger(r.Context, &r.ErrorRes).Print()
 	return r
 }
+func (r *Response) ErrorDetails(code int, tractId, msg string, details any) IResponse {
+	r.StatusCode = code
+	r.ErrorRes = &ErrorResponse{
+		TraceId: tractId,
+		Msg:     msg,
+		Details: details,
+	}
+	r.IsError = true
+	logger.InitLogger(r.Context, &r.ErrorRes).Print().Save()
+	return r
+}
 func (r *Response) Res() error {
 	return r.Context.Status(r.StatusCode).JSON(func() any {
 		if r.IsError {
@@ -63,4 +76,13 @@ type PaginateRes struct {
 	Limit     int `json:"limit"`
 	TotalPage int `json:"total_page"`
 	TotalItem int `json:"total_item"`
+	Facets    any `json:"facets,omitempty"`
+}
+
+type CursorPaginateRes struct {
+	Data       any    `json:"data"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor"`
+	PrevCursor string `json:"prev_cursor"`
+	Facets     any    `json:"facets,omitempty"`
 }
@@ -1,19 +1,175 @@
 package files
 
-import "mime/multipart"
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+	"time"
+)
+
+// TusVersion is the resumable upload protocol version spoken by the uploads routes
+const TusVersion = "1.0.0"
+
+// SlipDestination is where customers may upload to, admins may pick any destination.
+// Slips are private, they are downloaded through signed urls only.
+const SlipDestination = "private/slips"
+
+// Entities a file can be referenced by
+const (
+	EntityProductImage = "product_image"
+	EntityTransferSlip = "transfer_slip"
+	// A resized copy of an image, the entity is the file of the original
+	EntityDerivative = "derivative"
+)
+
+var (
+	ErrFileNotFound         = errors.New("file not found")
+	ErrFileInUse            = errors.New("file is still in use")
+	ErrFileExists           = errors.New("a file already exists at this destination")
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadTooLarge       = errors.New("upload exceeds its length")
+	ErrUploadCompleted      = errors.New("upload is already completed")
+)
+
+// File is a stored object recorded in the files registry
+type File struct {
+	Id          string  `db:"id" json:"id"`
+	StorageKey  string  `db:"storage_key" json:"storage_key"`
+	Url         string  `db:"url" json:"url"`
+	OwnerId     *string `db:"owner_id" json:"owner_id"`
+	Size        int64   `db:"size" json:"size"`
+	ContentType string  `db:"content_type" json:"content_type"`
+	Checksum    string  `db:"checksum" json:"checksum"`
+	EntityType  *string `db:"entity_type" json:"entity_type"`
+	EntityId    *string `db:"entity_id" json:"entity_id"`
+}
 
 type FileReq struct {
 	File        *multipart.FileHeader `form:"file"`
 	Destination string                `json:"destination"`
 	Extension   string
 	FileName    string
+	ContentType string
+	UserId      string
 }
 
-type FileRes struct {
+// FileRejection is why one file of an upload was refused
+type FileRejection struct {
 	FileName string `json:"filename"`
-	Url      string `json:"url"`
+	Reason   string `json:"reason"`
+}
+
+type RejectedFilesError struct {
+	Rejections []*FileRejection
+}
+
+func (e *RejectedFilesError) Error() string {
+	return "files are not acceptable"
+}
+
+// Outcomes of one file of a batch
+const (
+	FileStatusDone       = "done"
+	FileStatusFailed     = "failed"
+	FileStatusSkipped    = "skipped"
+	FileStatusRolledBack = "rolled_back"
+)
+
+// FileResult is what happened to one file of a batch that failed
+type FileResult struct {
+	FileName    string `json:"filename,omitempty"`
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// BatchError reports a batch undone because some of its files failed
+type BatchError struct {
+	Err     error
+	Results []*FileResult
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("the batch was rolled back: %v", e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+type FileRes struct {
+	FileName  string            `json:"filename"`
+	Url       string            `json:"url"`
+	SignedUrl string            `json:"signed_url,omitempty"`
+	Variants  map[string]string `json:"variants,omitempty"`
 }
 
 type DeleteFileReq struct {
 	Destination string `json:"destination"`
 }
+
+type DownloadFileReq struct {
+	Key       string
+	Expires   string `query:"expires"`
+	Signature string `query:"signature"`
+	UserId    string
+	IsAdmin   bool
+}
+
+// Upload is a resumable upload staged on disk until all of its bytes arrive
+type Upload struct {
+	Id          string            `json:"upload_id"`
+	UserId      string            `json:"user_id"`
+	FileName    string            `json:"filename"`
+	Destination string            `json:"destination"`
+	Extension   string            `json:"extension"`
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Url         string            `json:"url,omitempty"`
+	SignedUrl   string            `json:"signed_url,omitempty"`
+	Variants    map[string]string `json:"variants,omitempty"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+func (u *Upload) IsCompleted() bool {
+	return u.Url != ""
+}
+
+type UploadChunkReq struct {
+	Id     string
+	UserId string
+	Offset int64
+	Size   int64
+	Body   io.Reader
+}
+
+// ParseUploadMetadata decodes an Upload-Metadata header, comma separated
+// pairs of a key and a base64 value.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 || len(kv) > 2 {
+			return nil, fmt.Errorf("upload metadata is invalid")
+		}
+		if len(kv) == 1 {
+			metadata[kv[0]] = ""
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("upload metadata %s is invalid", kv[0])
+		}
+		metadata[kv[0]] = string(value)
+	}
+	return metadata, nil
+}
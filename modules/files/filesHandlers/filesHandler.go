@@ -1,9 +1,13 @@
 package filesHandlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,19 +15,40 @@ import (
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/files"
 	"github.com/jetsadawwts/go-restapi/modules/files/filesUsecases"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
 	"github.com/jetsadawwts/go-restapi/pkg/utils"
 )
 
 type filesHandlersErrCode string
 
 const (
-	uploadErr filesHandlersErrCode = "files-001"
-	deleteErr filesHandlersErrCode = "files-002"
+	uploadErr       filesHandlersErrCode = "files-001"
+	deleteErr       filesHandlersErrCode = "files-002"
+	createUploadErr filesHandlersErrCode = "files-003"
+	uploadChunkErr  filesHandlersErrCode = "files-004"
+	findUploadErr   filesHandlersErrCode = "files-005"
+	deleteUploadErr filesHandlersErrCode = "files-006"
+	downloadErr     filesHandlersErrCode = "files-007"
 )
 
+// Files ext validation
+var extMap = map[string]string{
+	"png":  "png",
+	"jpg":  "jpg",
+	"jpeg": "jpeg",
+}
+
 type IFilesHandler interface {
 	UploadFiles(c *fiber.Ctx) error
 	DeleteFiles(c *fiber.Ctx) error
+	UploadOptions(c *fiber.Ctx) error
+	CreateUpload(c *fiber.Ctx) error
+	UploadStatus(c *fiber.Ctx) error
+	FindUpload(c *fiber.Ctx) error
+	UploadChunk(c *fiber.Ctx) error
+	DeleteUpload(c *fiber.Ctx) error
+	DownloadFile(c *fiber.Ctx) error
 }
 
 type filesHandler struct {
@@ -53,29 +78,24 @@ func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
 	filesReq := form.File["files"]
 	destination := c.FormValue("destination")
 
-	//Files ext validation
-	extMap := map[string]string{
-		"png":  "png",
-		"jpg":  "jpg",
-		"jpeg": "jpeg",
-	}
-
+	// Every file is checked so all the reasons are reported at once
+	rejections := make([]*files.FileRejection, 0)
 	for _, file := range filesReq {
-		ext := strings.TrimPrefix(filepath.Ext(file.Filename), ".")
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Filename), "."))
 		if extMap[ext] != ext || extMap[ext] == "" {
-			return entities.NewResponse(c).Error(
-				fiber.ErrBadGateway.Code,
-				string(uploadErr),
-				"extension is not acceptable.",
-			).Res()
+			rejections = append(rejections, &files.FileRejection{
+				FileName: file.Filename,
+				Reason:   "extension is not acceptable.",
+			})
+			continue
 		}
 
 		if file.Size > int64(h.cfg.App().FileLimit()) {
-			return entities.NewResponse(c).Error(
-				fiber.ErrBadGateway.Code,
-				string(uploadErr),
-				fmt.Sprintf("file size must less than %d mib", int(math.Ceil(float64(h.cfg.App().FileLimit())/math.Pow(1024, 2)))),
-			).Res()
+			rejections = append(rejections, &files.FileRejection{
+				FileName: file.Filename,
+				Reason:   fmt.Sprintf("file size must less than %d mib", int(math.Ceil(float64(h.cfg.App().FileLimit())/math.Pow(1024, 2)))),
+			})
+			continue
 		}
 
 		filename := utils.RandFileName(ext)
@@ -85,12 +105,28 @@ func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
 			Destination: destination + "/" + filename,
 			FileName:    filename,
 			Extension:   ext,
+			UserId:      c.Locals("userId").(string),
 		})
 	}
 
-	// If you want to upload files to your computer please use this function below instead
-	res, err := h.filesUsecase.UploadToGCP(req)
+	if len(rejections) > 0 {
+		return h.rejectedRes(c, uploadErr, &files.RejectedFilesError{Rejections: rejections})
+	}
+
+	res, err := h.filesUsecase.UploadFiles(req)
 	if err != nil {
+		var rejected *files.RejectedFilesError
+		if errors.As(err, &rejected) {
+			return h.rejectedRes(c, uploadErr, rejected)
+		}
+		var batch *files.BatchError
+		if errors.As(err, &batch) {
+			status := fiber.ErrInternalServerError.Code
+			if errors.Is(batch, files.ErrFileExists) {
+				status = fiber.ErrConflict.Code
+			}
+			return h.batchRes(c, uploadErr, status, batch)
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(uploadErr),
@@ -98,18 +134,18 @@ func (h *filesHandler) UploadFiles(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	// res, err := h.filesUsecase.UploadToStorage(req)
-	// if err != nil {
-	// 	return entities.NewResponse(c).Error(
-	// 		fiber.ErrInternalServerError.Code,
-	// 		string(uploadErr),
-	// 		err.Error(),
-	// 	).Res()
-	// }
-
 	return entities.NewResponse(c).Success(fiber.StatusCreated, res).Res()
 }
 
+func (h *filesHandler) rejectedRes(c *fiber.Ctx, code filesHandlersErrCode, err *files.RejectedFilesError) error {
+	return entities.NewResponse(c).ErrorDetails(
+		fiber.ErrBadRequest.Code,
+		string(code),
+		err.Error(),
+		err.Rejections,
+	).Res()
+}
+
 func (h *filesHandler) DeleteFiles(c *fiber.Ctx) error {
 	req := make([]*files.DeleteFileReq, 0)
 	if err := c.BodyParser(&req); err != nil {
@@ -120,22 +156,308 @@ func (h *filesHandler) DeleteFiles(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	// If you want to upload files to your computer please use this function below instead
-	if err := h.filesUsecase.DeleteFileOnGCP(req); err != nil {
+	userId := c.Locals("userId").(string)
+	isAdmin := c.Locals("userPermissions").(roles.Permissions).Has(roles.PermFilesDelete)
+
+	if err := h.filesUsecase.DeleteFiles(req, userId, isAdmin); err != nil {
+		status := fiber.ErrInternalServerError.Code
+		switch {
+		case errors.Is(err, files.ErrFileNotFound):
+			status = fiber.ErrNotFound.Code
+		case errors.Is(err, files.ErrFileInUse):
+			status = fiber.ErrConflict.Code
+		}
+
+		var batch *files.BatchError
+		if errors.As(err, &batch) {
+			return h.batchRes(c, deleteErr, status, batch)
+		}
 		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
+			status,
 			string(deleteErr),
 			err.Error(),
 		).Res()
 	}
 
-	// if err := h.filesUsecase.DeleteFileOnStorage(req); err != nil {
-	// 	return entities.NewResponse(c).Error(
-	// 		fiber.ErrInternalServerError.Code,
-	// 		string(deleteErr),
-	// 		err.Error(),
-	// 	).Res()
-	// }
-
 	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
 }
+
+// batchRes reports every file of a batch that was rolled back
+func (h *filesHandler) batchRes(c *fiber.Ctx, code filesHandlersErrCode, status int, err *files.BatchError) error {
+	return entities.NewResponse(c).ErrorDetails(
+		status,
+		string(code),
+		err.Error(),
+		err.Results,
+	).Res()
+}
+
+func (h *filesHandler) DownloadFile(c *fiber.Ctx) error {
+	req := new(files.DownloadFileReq)
+	if err := c.QueryParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(downloadErr),
+			err.Error(),
+		).Res()
+	}
+	req.Key = c.Params("*")
+	req.UserId = c.Locals("userId").(string)
+	req.IsAdmin = c.Locals("userPermissions").(roles.Permissions).Has(roles.PermFilesReadAll)
+
+	file, info, err := h.filesUsecase.DownloadFile(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSignatureInvalid), errors.Is(err, storage.ErrSignatureExpired):
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(downloadErr),
+				err.Error(),
+			).Res()
+		case errors.Is(err, files.ErrFileNotFound):
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(downloadErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(downloadErr),
+			err.Error(),
+		).Res()
+	}
+
+	if info.ContentType != "" {
+		c.Set(fiber.HeaderContentType, info.ContentType)
+	}
+	// Neither browsers nor proxies may keep a private file
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	return c.SendStream(file, int(info.Size))
+}
+
+// tusCheck answers the protocol headers and rejects clients speaking another version
+func (h *filesHandler) tusCheck(c *fiber.Ctx) bool {
+	c.Set("Tus-Resumable", files.TusVersion)
+	if c.Get("Tus-Resumable") != files.TusVersion {
+		c.Set("Tus-Version", files.TusVersion)
+		return false
+	}
+	return true
+}
+
+func (h *filesHandler) uploadHeaders(c *fiber.Ctx, upload *files.Upload) {
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+}
+
+func (h *filesHandler) uploadErrStatus(err error) int {
+	switch {
+	case errors.Is(err, files.ErrUploadNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, files.ErrUploadOffsetMismatch), errors.Is(err, files.ErrUploadCompleted), errors.Is(err, files.ErrFileExists):
+		return fiber.StatusConflict
+	case errors.Is(err, files.ErrUploadTooLarge):
+		return fiber.StatusRequestEntityTooLarge
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func (h *filesHandler) UploadOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", files.TusVersion)
+	c.Set("Tus-Version", files.TusVersion)
+	c.Set("Tus-Extension", "creation,termination,expiration")
+	c.Set("Tus-Max-Size", strconv.Itoa(h.cfg.App().FileLimit()))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *filesHandler) CreateUpload(c *fiber.Ctx) error {
+	if !h.tusCheck(c) {
+		return entities.NewResponse(c).Error(
+			fiber.StatusPreconditionFailed,
+			string(createUploadErr),
+			"tus version is not supported",
+		).Res()
+	}
+
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createUploadErr),
+			"upload length is invalid",
+		).Res()
+	}
+	if length > int64(h.cfg.App().FileLimit()) {
+		return entities.NewResponse(c).Error(
+			fiber.StatusRequestEntityTooLarge,
+			string(createUploadErr),
+			fmt.Sprintf("file size must less than %d mib", int(math.Ceil(float64(h.cfg.App().FileLimit())/math.Pow(1024, 2)))),
+		).Res()
+	}
+
+	metadata, err := files.ParseUploadMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(metadata["filename"]), "."))
+	if extMap[ext] == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(createUploadErr),
+			"extension is not acceptable.",
+		).Res()
+	}
+
+	// Users without the permission can only upload transfer slips
+	destination := strings.Trim(metadata["destination"], "/")
+	if destination == "" || !c.Locals("userPermissions").(roles.Permissions).Has(roles.PermFilesUpload) {
+		destination = files.SlipDestination
+	}
+
+	upload, err := h.filesUsecase.CreateUpload(&files.Upload{
+		UserId:      c.Locals("userId").(string),
+		FileName:    utils.RandFileName(ext),
+		Destination: destination,
+		Extension:   ext,
+		Length:      length,
+	})
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(createUploadErr),
+			err.Error(),
+		).Res()
+	}
+
+	h.uploadHeaders(c, upload)
+	c.Location(strings.TrimSuffix(c.Path(), "/") + "/" + upload.Id)
+	return entities.NewResponse(c).Success(fiber.StatusCreated, upload).Res()
+}
+
+func (h *filesHandler) UploadStatus(c *fiber.Ctx) error {
+	if !h.tusCheck(c) {
+		return c.SendStatus(fiber.StatusPreconditionFailed)
+	}
+
+	upload, err := h.filesUsecase.FindUpload(
+		strings.Trim(c.Params("upload_id"), " "),
+		c.Locals("userId").(string),
+	)
+	if err != nil {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.SendStatus(h.uploadErrStatus(err))
+	}
+
+	h.uploadHeaders(c, upload)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (h *filesHandler) FindUpload(c *fiber.Ctx) error {
+	upload, err := h.filesUsecase.FindUpload(
+		strings.Trim(c.Params("upload_id"), " "),
+		c.Locals("userId").(string),
+	)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			h.uploadErrStatus(err),
+			string(findUploadErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, upload).Res()
+}
+
+func (h *filesHandler) UploadChunk(c *fiber.Ctx) error {
+	if !h.tusCheck(c) {
+		return entities.NewResponse(c).Error(
+			fiber.StatusPreconditionFailed,
+			string(uploadChunkErr),
+			"tus version is not supported",
+		).Res()
+	}
+
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return entities.NewResponse(c).Error(
+			fiber.StatusUnsupportedMediaType,
+			string(uploadChunkErr),
+			"content type must be application/offset+octet-stream",
+		).Res()
+	}
+
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(uploadChunkErr),
+			"upload offset is invalid",
+		).Res()
+	}
+
+	// The chunk is copied from the connection to the staged file, never held in memory
+	size := c.Request().Header.ContentLength()
+	if size < 0 {
+		return entities.NewResponse(c).Error(
+			fiber.StatusLengthRequired,
+			string(uploadChunkErr),
+			"content length is required",
+		).Res()
+	}
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	upload, err := h.filesUsecase.WriteUploadChunk(&files.UploadChunkReq{
+		Id:     strings.Trim(c.Params("upload_id"), " "),
+		UserId: c.Locals("userId").(string),
+		Offset: offset,
+		Size:   int64(size),
+		Body:   body,
+	})
+	if upload != nil {
+		h.uploadHeaders(c, upload)
+	}
+	if err != nil {
+		var rejected *files.RejectedFilesError
+		if errors.As(err, &rejected) {
+			return h.rejectedRes(c, uploadChunkErr, rejected)
+		}
+		return entities.NewResponse(c).Error(
+			h.uploadErrStatus(err),
+			string(uploadChunkErr),
+			err.Error(),
+		).Res()
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *filesHandler) DeleteUpload(c *fiber.Ctx) error {
+	if !h.tusCheck(c) {
+		return entities.NewResponse(c).Error(
+			fiber.StatusPreconditionFailed,
+			string(deleteUploadErr),
+			"tus version is not supported",
+		).Res()
+	}
+
+	if err := h.filesUsecase.DeleteUpload(
+		strings.Trim(c.Params("upload_id"), " "),
+		c.Locals("userId").(string),
+	); err != nil {
+		return entities.NewResponse(c).Error(
+			h.uploadErrStatus(err),
+			string(deleteUploadErr),
+			err.Error(),
+		).Res()
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
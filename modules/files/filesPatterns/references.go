@@ -0,0 +1,88 @@
+package filesPatterns
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jmoiron/sqlx"
+)
+
+// AttachProductFiles points the registry at the images a product uses and
+// releases the ones it no longer does, it runs in the transaction that
+// changed the images.
+func AttachProductFiles(ctx context.Context, tx *sqlx.Tx, productId string) error {
+	queryDetach := `
+	UPDATE "files" SET
+		"entity_type" = NULL,
+		"entity_id" = NULL
+	WHERE "entity_type" = $1
+	AND "entity_id" = $2
+	AND "url" NOT IN (
+		SELECT "url" FROM "images" WHERE "product_id" = $2
+	);`
+
+	if _, err := tx.ExecContext(ctx, queryDetach, files.EntityProductImage, productId); err != nil {
+		return fmt.Errorf("detach product files failed: %v", err)
+	}
+
+	queryAttach := `
+	UPDATE "files" SET
+		"entity_type" = $1,
+		"entity_id" = $2
+	WHERE "url" IN (
+		SELECT "url" FROM "images" WHERE "product_id" = $2
+	);`
+
+	if _, err := tx.ExecContext(ctx, queryAttach, files.EntityProductImage, productId); err != nil {
+		return fmt.Errorf("attach product files failed: %v", err)
+	}
+	return nil
+}
+
+// DetachProductFiles releases every image of a product about to be deleted
+func DetachProductFiles(ctx context.Context, tx *sqlx.Tx, productId string) error {
+	query := `
+	UPDATE "files" SET
+		"entity_type" = NULL,
+		"entity_id" = NULL
+	WHERE "entity_type" = $1
+	AND "entity_id" = $2;`
+
+	if _, err := tx.ExecContext(ctx, query, files.EntityProductImage, productId); err != nil {
+		return fmt.Errorf("detach product files failed: %v", err)
+	}
+	return nil
+}
+
+// AttachOrderFiles points the registry at the transfer slip of an order, only
+// a slip uploaded by the customer of the order is attached.
+func AttachOrderFiles(ctx context.Context, tx *sqlx.Tx, orderId string) error {
+	queryDetach := `
+	UPDATE "files" SET
+		"entity_type" = NULL,
+		"entity_id" = NULL
+	WHERE "entity_type" = $1
+	AND "entity_id" = $2
+	AND "url" IS DISTINCT FROM (
+		SELECT "transfer_slip"->>'url' FROM "orders" WHERE "id" = $2
+	);`
+
+	if _, err := tx.ExecContext(ctx, queryDetach, files.EntityTransferSlip, orderId); err != nil {
+		return fmt.Errorf("detach order files failed: %v", err)
+	}
+
+	queryAttach := `
+	UPDATE "files" "f" SET
+		"entity_type" = $1,
+		"entity_id" = $2
+	FROM "orders" "o"
+	WHERE "o"."id" = $2
+	AND "f"."url" = "o"."transfer_slip"->>'url'
+	AND "f"."owner_id" = "o"."user_id";`
+
+	if _, err := tx.ExecContext(ctx, queryAttach, files.EntityTransferSlip, orderId); err != nil {
+		return fmt.Errorf("attach order files failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package filesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jmoiron/sqlx"
+)
+
+type IFilesRepository interface {
+	InsertFile(req *files.File) error
+	UpdateFileObject(req *files.File) error
+	FindFileByKey(storageKey string) (*files.File, error)
+	AttachDerivative(fileId, originalId string) error
+	FindDerivatives(originalId string) ([]*files.File, error)
+	DeleteDerivative(fileId string) error
+	DeleteFile(fileId string) error
+	DeleteOrphanFile(fileId string, remove func() error) error
+	DeleteFiles(fileIds []string) error
+	IsFileOwner(fileId, userId string) (bool, error)
+	FindOrphanFiles(grace time.Duration, limit int) ([]*files.File, error)
+	FindFilesByPrefix(prefix string, limit int) ([]*files.File, error)
+	MoveFile(file *files.File, key, url string) error
+	TryLockSweeper(ctx context.Context) (func(), bool, error)
+}
+
+type filesRepository struct {
+	db *sqlx.DB
+}
+
+func FilesRepository(db *sqlx.DB) IFilesRepository {
+	return &filesRepository{db: db}
+}
+
+func (r *filesRepository) InsertFile(req *files.File) error {
+	query := `
+	INSERT INTO "files" (
+		"storage_key",
+		"url",
+		"owner_id",
+		"size",
+		"content_type",
+		"checksum"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING "id";`
+
+	if err := r.db.QueryRowxContext(
+		context.Background(),
+		query,
+		req.StorageKey,
+		req.Url,
+		req.OwnerId,
+		req.Size,
+		req.ContentType,
+		req.Checksum,
+	).Scan(&req.Id); err != nil {
+		if strings.Contains(err.Error(), `"files_storage_key_key"`) {
+			return files.ErrFileExists
+		}
+		return fmt.Errorf("insert file failed: %v", err)
+	}
+	return nil
+}
+
+// UpdateFileObject records what was stored under a claimed key
+func (r *filesRepository) UpdateFileObject(req *files.File) error {
+	query := `
+	UPDATE "files" SET
+		"url" = $2,
+		"size" = $3,
+		"checksum" = $4
+	WHERE "id" = $1;`
+
+	if _, err := r.db.ExecContext(
+		context.Background(),
+		query,
+		req.Id,
+		req.Url,
+		req.Size,
+		req.Checksum,
+	); err != nil {
+		return fmt.Errorf("update file failed: %v", err)
+	}
+	return nil
+}
+
+func (r *filesRepository) FindFileByKey(storageKey string) (*files.File, error) {
+	query := `
+	SELECT
+		"id",
+		"storage_key",
+		"url",
+		"owner_id",
+		"size",
+		"content_type",
+		"checksum",
+		"entity_type",
+		"entity_id"
+	FROM "files"
+	WHERE "storage_key" = $1;`
+
+	file := new(files.File)
+	if err := r.db.Get(file, query, storageKey); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, files.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("get file failed: %v", err)
+	}
+	return file, nil
+}
+
+// AttachDerivative records a file as a derivative of another, it goes with the original
+func (r *filesRepository) AttachDerivative(fileId, originalId string) error {
+	query := `
+	UPDATE "files" SET
+		"entity_type" = $2,
+		"entity_id" = $3
+	WHERE "id" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, fileId, files.EntityDerivative, originalId); err != nil {
+		return fmt.Errorf("attach derivative failed: %v", err)
+	}
+	return nil
+}
+
+// FindDerivatives lists the derivatives stored for a file, whatever the
+// config was when they were made.
+func (r *filesRepository) FindDerivatives(originalId string) ([]*files.File, error) {
+	query := `
+	SELECT
+		"id",
+		"storage_key",
+		"url",
+		"owner_id",
+		"size",
+		"content_type",
+		"checksum",
+		"entity_type",
+		"entity_id"
+	FROM "files"
+	WHERE "entity_type" = $1
+	AND "entity_id" = $2;`
+
+	derivatives := make([]*files.File, 0)
+	if err := r.db.Select(&derivatives, query, files.EntityDerivative, originalId); err != nil {
+		return nil, fmt.Errorf("find derivatives failed: %v", err)
+	}
+	return derivatives, nil
+}
+
+func (r *filesRepository) DeleteDerivative(fileId string) error {
+	query := `
+	DELETE FROM "files"
+	WHERE "id" = $1
+	AND "entity_type" = $2;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, fileId, files.EntityDerivative); err != nil {
+		return fmt.Errorf("delete derivative failed: %v", err)
+	}
+	return nil
+}
+
+// IsFileOwner tells whether the user uploaded the file or owns the order it is the slip of
+func (r *filesRepository) IsFileOwner(fileId, userId string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM "files" "f"
+		LEFT JOIN "orders" "o" ON "f"."entity_type" = $3 AND "o"."id" = "f"."entity_id"
+		WHERE "f"."id" = $1
+		AND ("f"."owner_id" = $2 OR "o"."user_id" = $2)
+	);`
+
+	var owner bool
+	if err := r.db.Get(&owner, query, fileId, userId, files.EntityTransferSlip); err != nil {
+		return false, fmt.Errorf("check file owner failed: %v", err)
+	}
+	return owner, nil
+}
+
+// DeleteFile removes the record of an unreferenced file, a file attached in
+// the meantime is left alone.
+func (r *filesRepository) DeleteFile(fileId string) error {
+	query := `
+	DELETE FROM "files"
+	WHERE "id" = $1
+	AND "entity_type" IS NULL;`
+
+	result, err := r.db.ExecContext(context.Background(), query, fileId)
+	if err != nil {
+		return fmt.Errorf("delete file failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return files.ErrFileInUse
+	}
+	return nil
+}
+
+// DeleteOrphanFile removes the record of an unreferenced file once remove has
+// deleted its object, the record stays when remove fails so the next sweep
+// retries. The row is locked meanwhile, attaching the file waits and then
+// finds nothing.
+func (r *filesRepository) DeleteOrphanFile(fileId string, remove func() error) error {
+	ctx := context.Background()
+
+	query := `
+	DELETE FROM "files"
+	WHERE "id" = $1
+	AND "entity_type" IS NULL;`
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, fileId)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete file failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return files.ErrFileInUse
+	}
+	if err := remove(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteFiles removes the records of a batch of unreferenced files, none is
+// removed when any of them has been attached in the meantime.
+func (r *filesRepository) DeleteFiles(fileIds []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	query := `
+	DELETE FROM "files"
+	WHERE "id" = ANY($1::uuid[])
+	AND "entity_type" IS NULL;`
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, fileIds)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete files failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected != int64(len(fileIds)) {
+		tx.Rollback()
+		return files.ErrFileInUse
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindOrphanFiles lists the files nothing has referenced for longer than the
+// grace period, urls still in use by an image or a slip are never orphans.
+func (r *filesRepository) FindOrphanFiles(grace time.Duration, limit int) ([]*files.File, error) {
+	query := `
+	SELECT
+		"f"."id",
+		"f"."storage_key",
+		"f"."url",
+		"f"."owner_id",
+		"f"."size",
+		"f"."content_type",
+		"f"."checksum",
+		"f"."entity_type",
+		"f"."entity_id"
+	FROM "files" "f"
+	WHERE "f"."entity_type" IS NULL
+	AND "f"."updated_at" < now() - make_interval(secs => $1)
+	AND NOT EXISTS (
+		SELECT 1 FROM "images" "i" WHERE "i"."url" = "f"."url"
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM "orders" "o" WHERE "o"."transfer_slip"->>'url' = "f"."url"
+	)
+	ORDER BY "f"."updated_at" ASC
+	LIMIT $2;`
+
+	orphans := make([]*files.File, 0)
+	if err := r.db.Select(&orphans, query, grace.Seconds(), limit); err != nil {
+		return nil, fmt.Errorf("get orphan files failed: %v", err)
+	}
+	return orphans, nil
+}
+
+// FindFilesByPrefix lists the files stored under a key prefix
+func (r *filesRepository) FindFilesByPrefix(prefix string, limit int) ([]*files.File, error) {
+	query := `
+	SELECT
+		"id",
+		"storage_key",
+		"url",
+		"owner_id",
+		"size",
+		"content_type",
+		"checksum",
+		"entity_type",
+		"entity_id"
+	FROM "files"
+	WHERE starts_with("storage_key", $1)
+	ORDER BY "storage_key" ASC
+	LIMIT $2;`
+
+	result := make([]*files.File, 0)
+	if err := r.db.Select(&result, query, prefix, limit); err != nil {
+		return nil, fmt.Errorf("get files failed: %v", err)
+	}
+	return result, nil
+}
+
+// MoveFile points a file, and the slip referencing it, at the key its object
+// was copied to.
+func (r *filesRepository) MoveFile(file *files.File, key, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	queryFile := `
+	UPDATE "files" SET
+		"storage_key" = $2,
+		"url" = $3
+	WHERE "id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryFile, file.Id, key, url); err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), `"files_storage_key_key"`) {
+			return files.ErrFileExists
+		}
+		return fmt.Errorf("move file failed: %v", err)
+	}
+
+	querySlip := `
+	UPDATE "orders" SET
+		"transfer_slip" = jsonb_set("transfer_slip", '{url}', to_jsonb($2::text))
+	WHERE "transfer_slip"->>'url' = $1;`
+
+	if _, err := tx.ExecContext(ctx, querySlip, file.Url, url); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("move transfer slip failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sweeperLockKey is the advisory lock only one replica runs the sweeper under
+const sweeperLockKey = 0x66696c6573 // "files"
+
+// TryLockSweeper takes the sweeper advisory lock on a connection of its own,
+// false when another replica holds it. The returned func releases it.
+func (r *filesRepository) TryLockSweeper(ctx context.Context) (func(), bool, error) {
+	conn, err := r.db.Connx(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1);`, sweeperLockKey); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("lock sweeper failed: %v", err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		// A closed session releases the lock too, the unlock keeps the connection reusable
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, sweeperLockKey); err != nil {
+			log.Printf("unlock sweeper failed: %v\n", err)
+		}
+		conn.Close()
+	}, true, nil
+}
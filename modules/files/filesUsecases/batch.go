@@ -0,0 +1,399 @@
+package filesUsecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
+)
+
+// A batch is all or nothing, once a file fails the files still queued are
+// skipped and the ones already done are undone.
+
+type uploadJob struct {
+	index int
+	req   *files.FileReq
+}
+
+type uploadResult struct {
+	index   int
+	file    *files.File // stored, even when a later step failed
+	res     *files.FileRes
+	err     error
+	skipped bool
+}
+
+type deleteJob struct {
+	index int
+	file  *files.File
+}
+
+type deleteResult struct {
+	index   int
+	backup  string // copy of the deleted object, put back if the batch fails
+	err     error
+	skipped bool
+}
+
+// skippedErr tells why a file of a failing batch was not attempted
+func skippedErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the batch timed out")
+	}
+	return fmt.Errorf("another file of the batch failed")
+}
+
+type batchResult interface {
+	failure() (err error, skipped bool)
+}
+
+func (r *uploadResult) failure() (error, bool) { return r.err, r.skipped }
+
+func (r *deleteResult) failure() (error, bool) { return r.err, r.skipped }
+
+// batchErr is the error a batch failed with, the one of a file that failed
+// rather than of the files skipped because of it, nil when none failed.
+func batchErr[T batchResult](results []T) error {
+	var timedOut error
+	for _, r := range results {
+		err, skipped := r.failure()
+		if err == nil {
+			continue
+		}
+		if !skipped {
+			return err
+		}
+		if timedOut == nil {
+			timedOut = err
+		}
+	}
+	// Only skipped files, the batch ran out of time
+	return timedOut
+}
+
+// fileResult is the outcome of an upload of a failed batch before it is
+// rolled back
+func (r *uploadResult) fileResult(req *files.FileReq) *files.FileResult {
+	result := &files.FileResult{
+		FileName:    req.File.Filename,
+		Destination: req.Destination,
+		Status:      files.FileStatusDone,
+	}
+	switch {
+	case r.skipped:
+		result.Status = files.FileStatusSkipped
+		result.Error = r.err.Error()
+	case r.err != nil:
+		result.Status = files.FileStatusFailed
+		result.Error = r.err.Error()
+	}
+	return result
+}
+
+// fileResult is the outcome of a delete of a failed batch, recordsErr is set
+// when every object was deleted but not their records.
+func (r *deleteResult) fileResult(file *files.File, recordsErr error) *files.FileResult {
+	result := &files.FileResult{
+		Destination: file.StorageKey,
+		Status:      files.FileStatusRolledBack,
+	}
+	switch {
+	case r.skipped:
+		result.Status = files.FileStatusSkipped
+		result.Error = r.err.Error()
+	case r.err != nil:
+		result.Status = files.FileStatusFailed
+		result.Error = r.err.Error()
+	case recordsErr != nil:
+		result.Error = recordsErr.Error()
+	}
+	return result
+}
+
+// removeFile undoes a stored file, its record, object and derivatives
+func (u *filesUsecase) removeFile(file *files.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Storage().Timeout())
+	defer cancel()
+
+	if err := u.deleteObject(ctx, file); err != nil {
+		return err
+	}
+	if err := u.filesRepository.DeleteFile(file.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *filesUsecase) uploadFile(ctx context.Context, req *files.FileReq) *uploadResult {
+	container, err := req.File.Open()
+	if err != nil {
+		return &uploadResult{err: err}
+	}
+
+	stripped := stripMetadata(container, req.ContentType)
+	file, err := u.storeFile(ctx, req.Destination, stripped, req.ContentType, req.UserId)
+	stripped.Close()
+	container.Close()
+	if err != nil {
+		return &uploadResult{err: err}
+	}
+
+	container, err = req.File.Open()
+	if err != nil {
+		return &uploadResult{file: file, err: err}
+	}
+	variants, err := u.uploadDerivatives(ctx, container, file)
+	container.Close()
+	if err != nil {
+		return &uploadResult{file: file, err: err}
+	}
+
+	fmt.Printf("%v uploaded to %v.\n", req.FileName, req.Destination)
+
+	return &uploadResult{
+		file: file,
+		res: &files.FileRes{
+			FileName:  req.FileName,
+			Url:       file.Url,
+			SignedUrl: u.signedUrl(file.StorageKey, file.Url),
+			Variants:  variants,
+		},
+	}
+}
+
+func (u *filesUsecase) uploadWorkers(ctx context.Context, cancel context.CancelFunc, jobs <-chan *uploadJob, results chan<- *uploadResult) {
+	for job := range jobs {
+		if ctx.Err() != nil {
+			results <- &uploadResult{index: job.index, err: skippedErr(ctx), skipped: true}
+			continue
+		}
+
+		result := u.uploadFile(ctx, job.req)
+		result.index = job.index
+		if result.err != nil {
+			cancel()
+		}
+		results <- result
+	}
+}
+
+func (u *filesUsecase) UploadFiles(req []*files.FileReq) ([]*files.FileRes, error) {
+	if err := u.validateFiles(req); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Storage().Timeout())
+	defer cancel()
+
+	jobsCh := make(chan *uploadJob, len(req))
+	resultsCh := make(chan *uploadResult, len(req))
+
+	for i, r := range req {
+		jobsCh <- &uploadJob{index: i, req: r}
+	}
+	close(jobsCh)
+
+	for i := 0; i < u.cfg.Storage().Workers(); i++ {
+		go u.uploadWorkers(ctx, cancel, jobsCh, resultsCh)
+	}
+
+	results := make([]*uploadResult, len(req))
+	for a := 0; a < len(req); a++ {
+		result := <-resultsCh
+		results[result.index] = result
+	}
+
+	failed := batchErr(results)
+	if failed == nil {
+		res := make([]*files.FileRes, 0, len(results))
+		for _, r := range results {
+			res = append(res, r.res)
+		}
+		return res, nil
+	}
+
+	// Take back what was stored
+	rollback := &files.BatchError{
+		Err:     failed,
+		Results: make([]*files.FileResult, 0, len(results)),
+	}
+	for i, r := range results {
+		result := r.fileResult(req[i])
+		if r.file != nil {
+			if err := u.removeFile(r.file); err != nil {
+				log.Printf("roll back %s failed: %v\n", r.file.StorageKey, err)
+				result.Error = fmt.Sprintf("roll back failed: %v", err)
+			} else if result.Status == files.FileStatusDone {
+				result.Status = files.FileStatusRolledBack
+			}
+		}
+		rollback.Results = append(rollback.Results, result)
+	}
+	return nil, rollback
+}
+
+// backupObject copies an object to the upload dir before it is deleted
+func (u *filesUsecase) backupObject(ctx context.Context, key string) (string, error) {
+	r, _, err := u.storage.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(u.cfg.Storage().UploadDir(), 0777); err != nil {
+		return "", fmt.Errorf("mkdir %q failed: %v", u.cfg.Storage().UploadDir(), err)
+	}
+	backup, err := os.CreateTemp(u.cfg.Storage().UploadDir(), "backup-*")
+	if err != nil {
+		return "", fmt.Errorf("create backup failed: %v", err)
+	}
+	defer backup.Close()
+
+	if _, err := io.Copy(backup, r); err != nil {
+		os.Remove(backup.Name())
+		return "", fmt.Errorf("backup %s failed: %v", key, err)
+	}
+	return backup.Name(), nil
+}
+
+// restoreObject puts a deleted object back from its backup
+func (u *filesUsecase) restoreObject(file *files.File, backup string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Storage().Timeout())
+	defer cancel()
+
+	r, err := os.Open(backup)
+	if err != nil {
+		return fmt.Errorf("open backup failed: %v", err)
+	}
+	defer r.Close()
+
+	if _, err := u.storage.Upload(ctx, file.StorageKey, r, file.ContentType); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *filesUsecase) deleteFile(ctx context.Context, file *files.File) *deleteResult {
+	backup, err := u.backupObject(ctx, file.StorageKey)
+	if err != nil {
+		// Nothing to delete, only the record is left
+		if errors.Is(err, storage.ErrNotFound) {
+			return &deleteResult{}
+		}
+		return &deleteResult{err: err}
+	}
+
+	if err := u.storage.Delete(ctx, file.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		os.Remove(backup)
+		return &deleteResult{err: err}
+	}
+	return &deleteResult{backup: backup}
+}
+
+func (u *filesUsecase) deleteFileWorkers(ctx context.Context, cancel context.CancelFunc, jobs <-chan *deleteJob, results chan<- *deleteResult) {
+	for job := range jobs {
+		if ctx.Err() != nil {
+			results <- &deleteResult{index: job.index, err: skippedErr(ctx), skipped: true}
+			continue
+		}
+
+		result := u.deleteFile(ctx, job.file)
+		result.index = job.index
+		if result.err != nil {
+			cancel()
+		}
+		results <- result
+	}
+}
+
+// DeleteFiles removes files of the registry, users may only delete their own
+// files and no one may delete a file still referenced.
+func (u *filesUsecase) DeleteFiles(req []*files.DeleteFileReq, userId string, isAdmin bool) error {
+	targets := make([]*files.File, 0)
+	for _, r := range req {
+		file, err := u.filesRepository.FindFileByKey(r.Destination)
+		if err != nil {
+			return err
+		}
+		// Another user's file is reported as missing
+		if !isAdmin && (file.OwnerId == nil || *file.OwnerId != userId) {
+			return files.ErrFileNotFound
+		}
+		if file.EntityType != nil {
+			return fmt.Errorf("%w: %s by %s %s", files.ErrFileInUse, file.StorageKey, *file.EntityType, *file.EntityId)
+		}
+		targets = append(targets, file)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Storage().Timeout())
+	defer cancel()
+
+	jobsCh := make(chan *deleteJob, len(targets))
+	resultsCh := make(chan *deleteResult, len(targets))
+
+	for i, t := range targets {
+		jobsCh <- &deleteJob{index: i, file: t}
+	}
+	close(jobsCh)
+
+	for i := 0; i < u.cfg.Storage().Workers(); i++ {
+		go u.deleteFileWorkers(ctx, cancel, jobsCh, resultsCh)
+	}
+
+	results := make([]*deleteResult, len(targets))
+	for a := 0; a < len(targets); a++ {
+		result := <-resultsCh
+		results[result.index] = result
+	}
+
+	// The records go only once every object is gone
+	var recordsErr error
+	failed := batchErr(results)
+	if failed == nil {
+		fileIds := make([]string, 0, len(targets))
+		for _, t := range targets {
+			fileIds = append(fileIds, t.Id)
+		}
+		recordsErr = u.filesRepository.DeleteFiles(fileIds)
+		failed = recordsErr
+	}
+
+	defer func() {
+		for _, r := range results {
+			if r.backup != "" {
+				os.Remove(r.backup)
+			}
+		}
+	}()
+
+	if failed == nil {
+		for _, t := range targets {
+			u.deleteDerivatives(ctx, t)
+			fmt.Printf("%v deleted.\n", t.StorageKey)
+		}
+		return nil
+	}
+
+	// Put back what was deleted
+	rollback := &files.BatchError{
+		Err:     failed,
+		Results: make([]*files.FileResult, 0, len(results)),
+	}
+	for i, r := range results {
+		result := r.fileResult(targets[i], recordsErr)
+		if r.backup != "" {
+			if err := u.restoreObject(targets[i], r.backup); err != nil {
+				log.Printf("restore %s failed: %v\n", targets[i].StorageKey, err)
+				result.Status = files.FileStatusFailed
+				result.Error = fmt.Sprintf("restore failed: %v", err)
+			}
+		}
+		rollback.Results = append(rollback.Results, result)
+	}
+	return rollback
+}
@@ -0,0 +1,102 @@
+package filesUsecases
+
+import (
+	"errors"
+	"mime/multipart"
+	"testing"
+
+	"github.com/jetsadawwts/go-restapi/modules/files"
+)
+
+func TestBatchErr(t *testing.T) {
+	failed := errors.New("upload a.png failed")
+	skipped := errors.New("another file of the batch failed")
+	timedOut := errors.New("the batch timed out")
+
+	tests := []struct {
+		name    string
+		results []*uploadResult
+		want    error
+	}{
+		{"all done", []*uploadResult{{}, {}}, nil},
+		{"empty", []*uploadResult{}, nil},
+		{"one failed", []*uploadResult{{}, {err: failed}}, failed},
+		// The files skipped because of a failure may come before it
+		{"skipped before the failure", []*uploadResult{{err: skipped, skipped: true}, {err: failed}}, failed},
+		{"skipped after the failure", []*uploadResult{{err: failed}, {err: skipped, skipped: true}}, failed},
+		{"only skipped", []*uploadResult{{}, {err: timedOut, skipped: true}, {err: timedOut, skipped: true}}, timedOut},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchErr(tt.results); got != tt.want {
+				t.Errorf("batchErr = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Deletes are aggregated alike
+	deletes := []*deleteResult{{backup: "backup-1"}, {err: skipped, skipped: true}, {err: failed}}
+	if got := batchErr(deletes); got != failed {
+		t.Errorf("batchErr of deletes = %v, want %v", got, failed)
+	}
+}
+
+func TestUploadFileResult(t *testing.T) {
+	req := &files.FileReq{
+		File:        &multipart.FileHeader{Filename: "a.png"},
+		Destination: "images/products/a.png",
+	}
+
+	tests := []struct {
+		name   string
+		result *uploadResult
+		status string
+		err    string
+	}{
+		{"done", &uploadResult{file: &files.File{}}, files.FileStatusDone, ""},
+		{"failed", &uploadResult{err: errors.New("file type is not allowed")}, files.FileStatusFailed, "file type is not allowed"},
+		{"failed after it was stored", &uploadResult{file: &files.File{}, err: errors.New("resize failed")}, files.FileStatusFailed, "resize failed"},
+		{"skipped", &uploadResult{err: errors.New("the batch timed out"), skipped: true}, files.FileStatusSkipped, "the batch timed out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.result.fileResult(req)
+			want := files.FileResult{FileName: "a.png", Destination: "images/products/a.png", Status: tt.status, Error: tt.err}
+			if *got != want {
+				t.Errorf("fileResult = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDeleteFileResult(t *testing.T) {
+	file := &files.File{StorageKey: "images/products/a.png"}
+	recordsErr := errors.New("delete files failed")
+
+	tests := []struct {
+		name       string
+		result     *deleteResult
+		recordsErr error
+		status     string
+		err        string
+	}{
+		{"deleted", &deleteResult{backup: "backup-1"}, nil, files.FileStatusRolledBack, ""},
+		{"object already gone", &deleteResult{}, nil, files.FileStatusRolledBack, ""},
+		{"failed", &deleteResult{err: errors.New("access denied")}, nil, files.FileStatusFailed, "access denied"},
+		{"skipped", &deleteResult{err: errors.New("another file of the batch failed"), skipped: true}, nil, files.FileStatusSkipped, "another file of the batch failed"},
+		// Every object was deleted, the records were not
+		{"records not deleted", &deleteResult{backup: "backup-1"}, recordsErr, files.FileStatusRolledBack, "delete files failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.result.fileResult(file, tt.recordsErr)
+			want := files.FileResult{Destination: "images/products/a.png", Status: tt.status, Error: tt.err}
+			if *got != want {
+				t.Errorf("fileResult = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
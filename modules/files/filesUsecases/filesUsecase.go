@@ -3,282 +3,316 @@ package filesUsecases
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
+	"log"
+	"path"
 	"strings"
-	"time"
-
-	"cloud.google.com/go/storage"
+	"sync"
 
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jetsadawwts/go-restapi/modules/files/filesRepositories"
+	"github.com/jetsadawwts/go-restapi/pkg/imaging"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
+	"github.com/jetsadawwts/go-restapi/pkg/utils"
 )
 
 type IFilesUsecase interface {
-	UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error)
-	DeleteFileOnGCP(req []*files.DeleteFileReq) error
-	UploadToStorage(req []*files.FileReq) ([]*files.FileRes, error)
-	DeleteFileOnStorage(req []*files.DeleteFileReq) error
+	UploadFiles(req []*files.FileReq) ([]*files.FileRes, error)
+	DeleteFiles(req []*files.DeleteFileReq, userId string, isAdmin bool) error
+	CreateUpload(req *files.Upload) (*files.Upload, error)
+	FindUpload(uploadId, userId string) (*files.Upload, error)
+	WriteUploadChunk(req *files.UploadChunkReq) (*files.Upload, error)
+	DeleteUpload(uploadId, userId string) error
+	SweepOrphanFiles(ctx context.Context) (int, error)
+	RunSweeper(ctx context.Context)
+	DownloadFile(req *files.DownloadFileReq) (io.ReadCloser, *storage.ObjectInfo, error)
 }
 
 type filesUsecase struct {
-	cfg config.IConfig
+	cfg             config.IConfig
+	storage         storage.IStorage
+	signer          storage.ISigner
+	filesRepository filesRepositories.IFilesRepository
+	uploadLocks     sync.Map
 }
 
-func FilesUsecase(cfg config.IConfig) IFilesUsecase {
+func FilesUsecase(cfg config.IConfig, storage storage.IStorage, signer storage.ISigner, filesRepository filesRepositories.IFilesRepository) IFilesUsecase {
 	return &filesUsecase{
-		cfg: cfg,
+		cfg:             cfg,
+		storage:         storage,
+		signer:          signer,
+		filesRepository: filesRepository,
 	}
 }
 
-type filesPub struct {
-	bucket      string
-	destination string
-	file        *files.FileRes
-}
+// byteCounter counts what is written through it
+type byteCounter int64
 
-func (f *filesPub) makePublic(ctx context.Context, client *storage.Client) error {
-	acl := client.Bucket(f.bucket).Object(f.destination).ACL()
-	if err := acl.Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
-		return fmt.Errorf("ACLHandle.Set: %v", err)
-	}
-	fmt.Printf("Blob %v is now publicly accessible.\n", f.destination)
-	return nil
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
 }
 
-func (u *filesUsecase) uploadWorkers(ctx context.Context, client *storage.Client, jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
-	for job := range jobs {
-		container, err := job.File.Open()
-		if err != nil {
-			errs <- err
-			return
-		}
-
-		b, err := ioutil.ReadAll(container)
-		if err != nil {
-			errs <- err
-			return
-		}
+// storeFile uploads an object and records it in the files registry, the size
+// and checksum are of the bytes actually stored. The key is claimed in the
+// registry before anything is written, an upload never replaces the object of
+// another file.
+func (u *filesUsecase) storeFile(ctx context.Context, key string, r io.Reader, contentType, ownerId string) (*files.File, error) {
+	file := &files.File{
+		StorageKey:  key,
+		ContentType: contentType,
+	}
+	if ownerId != "" {
+		file.OwnerId = &ownerId
+	}
+	if err := u.filesRepository.InsertFile(file); err != nil {
+		return nil, err
+	}
 
-		buf := bytes.NewBuffer(b)
+	// Objects stored before the registry are not claimed by any record
+	exists, err := u.storage.Exists(ctx, key)
+	if err != nil {
+		u.releaseKey(file)
+		return nil, err
+	}
+	if exists {
+		u.releaseKey(file)
+		return nil, files.ErrFileExists
+	}
 
-		wc := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination).NewWriter(ctx)
+	hash := sha256.New()
+	var size byteCounter
 
-		if _, err = io.Copy(wc, buf); err != nil {
-			errs <- fmt.Errorf("io.Copy: %v", err)
-			return
-		}
+	url, err := u.storage.Upload(ctx, key, io.TeeReader(r, io.MultiWriter(hash, &size)), contentType)
+	if err != nil {
+		u.releaseKey(file)
+		return nil, err
+	}
+	// The provider url of a private object is useless to clients
+	if storage.IsPrivate(key) {
+		url = u.signer.Url(key)
+	}
 
-		if err := wc.Close(); err != nil {
-			errs <- fmt.Errorf("Writer.Close: %v", err)
-			return
+	file.Url = url
+	file.Size = int64(size)
+	file.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if err := u.filesRepository.UpdateFileObject(file); err != nil {
+		// The key is ours, an object missing from the registry could never be cleaned up
+		if err := u.storage.Delete(ctx, key); err != nil {
+			log.Printf("delete unregistered %s failed: %v\n", key, err)
 		}
+		u.releaseKey(file)
+		return nil, err
+	}
+	return file, nil
+}
 
-		fmt.Printf("%v uploaded to %v.\n", job.FileName, job.Destination)
-
-		newFiles := &filesPub{
-			file: &files.FileRes{
-				FileName: job.FileName,
-				Url:      fmt.Sprintf("https://storage.googleapis.com/%s/%s", u.cfg.App().GCPBucket(), job.Destination),
-			},
-			bucket:      u.cfg.App().GCPBucket(),
-			destination: job.Destination,
-		}
+// releaseKey drops the claim of a file nothing was stored for
+func (u *filesUsecase) releaseKey(file *files.File) {
+	if err := u.filesRepository.DeleteFile(file.Id); err != nil {
+		log.Printf("release %s failed: %v\n", file.StorageKey, err)
+	}
+}
 
-		if err := newFiles.makePublic(ctx, client); err != nil {
-			errs <- err
-		}
+// signedUrl is the url a private file can be downloaded with for now, empty for public files
+func (u *filesUsecase) signedUrl(key, url string) string {
+	if !storage.IsPrivate(key) {
+		return ""
+	}
+	return u.signer.Sign(url)
+}
 
-		errs <- nil
-		results <- newFiles.file
+// deleteObject removes an object and its derivatives from storage
+func (u *filesUsecase) deleteObject(ctx context.Context, file *files.File) error {
+	if err := u.storage.Delete(ctx, file.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
 	}
+	u.deleteDerivatives(ctx, file)
+	return nil
 }
 
-func (u *filesUsecase) uploadToStorageWorker(ctx context.Context, jobs <-chan *files.FileReq, results chan<- *files.FileRes, errs chan<- error) {
-	for job := range jobs {
-		cotainer, err := job.File.Open()
-		if err != nil {
-			errs <- err
-			return
+// deleteDerivatives removes the derivatives registered for a file, failures
+// are only logged as the original is already gone.
+func (u *filesUsecase) deleteDerivatives(ctx context.Context, file *files.File) {
+	derivatives, err := u.filesRepository.FindDerivatives(file.Id)
+	if err != nil {
+		log.Printf("find derivatives of %s failed: %v\n", file.StorageKey, err)
+		return
+	}
+	for _, d := range derivatives {
+		if err := u.storage.Delete(ctx, d.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("delete derivative %s failed: %v\n", d.StorageKey, err)
+			continue
 		}
-		b, err := ioutil.ReadAll(cotainer)
-		if err != nil {
-			errs <- err
-			return
+		if err := u.filesRepository.DeleteDerivative(d.Id); err != nil {
+			log.Printf("delete derivative %s failed: %v\n", d.StorageKey, err)
 		}
-		// Upload an object to storage
-		dest := fmt.Sprintf("./assets/images/%s", job.Destination)
-		if err := os.WriteFile(dest, b, 0777); err != nil {
-			if err := os.MkdirAll("./assets/images/"+strings.Replace(job.Destination, job.FileName, "", 1), 0777); err != nil {
-				errs <- fmt.Errorf("mkdir \"./assets/images/%s\" failed: %v", err, job.Destination)
-				return
+	}
+
+	// Derivatives made before they were registered are where the config puts
+	// them, unless that key is another file's
+	for format := range imaging.Formats {
+		for _, derivativeKey := range u.derivativeKeys(file.StorageKey, imaging.Formats[format][0]) {
+			if _, err := u.filesRepository.FindFileByKey(derivativeKey); !errors.Is(err, files.ErrFileNotFound) {
+				continue
 			}
-			if err := os.WriteFile(dest, b, 0777); err != nil {
-				errs <- fmt.Errorf("write file failed: %v", err)
-				return
+			if err := u.storage.Delete(ctx, derivativeKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("delete derivative %s failed: %v\n", derivativeKey, err)
 			}
 		}
-		newFile := &filesPub{
-			file: &files.FileRes{
-				FileName: job.FileName,
-				Url:      fmt.Sprintf("http://%s:%d/%s", u.cfg.App().Host(), u.cfg.App().Port(), job.Destination),
-			},
-			destination: job.Destination,
-		}
-		errs <- nil
-		results <- newFile.file
 	}
 }
 
-func (u *filesUsecase) UploadToGCP(req []*files.FileReq) ([]*files.FileRes, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
-	defer cancel()
-
-	client, err := storage.NewClient(ctx)
+// inspectImage checks the content is an acceptable image within the configured dimensions
+func (u *filesUsecase) inspectImage(r io.Reader) (*imaging.Info, error) {
+	info, err := imaging.Inspect(r)
 	if err != nil {
-		return nil, fmt.Errorf("storage.NewClient: %w", err)
+		return nil, err
+	}
+	if info.Width > u.cfg.App().ImageMaxWidth() || info.Height > u.cfg.App().ImageMaxHeight() {
+		return nil, fmt.Errorf(
+			"image is %dx%d pixels, the maximum is %dx%d",
+			info.Width,
+			info.Height,
+			u.cfg.App().ImageMaxWidth(),
+			u.cfg.App().ImageMaxHeight(),
+		)
 	}
-	defer client.Close()
+	return info, nil
+}
 
-	jobsCh := make(chan *files.FileReq, len(req))
-	resultsCh := make(chan *files.FileRes, len(req))
-	errsCh := make(chan error, len(req))
+// stripMetadata streams the image without its metadata, closing the returned
+// reader stops the copy when the upload gives up early.
+func stripMetadata(r io.Reader, contentType string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(imaging.StripMetadata(pw, r, contentType))
+	}()
+	return pr
+}
 
-	res := make([]*files.FileRes, 0)
+// derivativeKeys lists where the derivatives of an image are stored, next to the original
+func (u *filesUsecase) derivativeKeys(key, ext string) map[string]string {
+	base := strings.TrimSuffix(key, path.Ext(key))
 
-	for _, r := range req {
-		jobsCh <- r
+	keys := make(map[string]string)
+	for name := range u.cfg.App().ImageDerivatives() {
+		keys[name] = fmt.Sprintf("%s_%s.%s", base, name, ext)
 	}
-	close(jobsCh)
+	return keys
+}
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.uploadWorkers(ctx, client, jobsCh, resultsCh, errsCh)
+// uploadDerivatives stores the resized copies of an image and returns their
+// urls by name. Each is claimed in the registry like any file, then recorded
+// as a derivative of the original so it is deleted along with it.
+func (u *filesUsecase) uploadDerivatives(ctx context.Context, r io.Reader, original *files.File) (map[string]string, error) {
+	// Private files are never displayed in listings
+	if len(u.cfg.App().ImageDerivatives()) == 0 || storage.IsPrivate(original.StorageKey) {
+		return nil, nil
 	}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
-		if err != nil {
-			return nil, err
-		}
+	img, orientation, err := imaging.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode image failed: %v", err)
+	}
 
-		result := <-resultsCh
-		res = append(res, result)
+	format := u.cfg.App().ImageDerivativeFormat()
+	ownerId := ""
+	if original.OwnerId != nil {
+		ownerId = *original.OwnerId
 	}
 
-	return res, nil
-}
+	variants := make(map[string]string)
+	for name, derivativeKey := range u.derivativeKeys(original.StorageKey, imaging.Formats[format][0]) {
+		buf := new(bytes.Buffer)
+		if err := imaging.Encode(buf, imaging.Orient(imaging.Resize(img, u.cfg.App().ImageDerivatives()[name]), orientation), format); err != nil {
+			return nil, fmt.Errorf("encode %s failed: %v", name, err)
+		}
 
-func (u *filesUsecase) UploadToStorage(req []*files.FileReq) ([]*files.FileRes, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
-	defer cancel()
-	jobsCh := make(chan *files.FileReq, len(req))
-	resultsCh := make(chan *files.FileRes, len(req))
-	errsCh := make(chan error, len(req))
-	res := make([]*files.FileRes, 0)
-	for _, r := range req {
-		jobsCh <- r
-	}
-	close(jobsCh)
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.uploadToStorageWorker(ctx, jobsCh, resultsCh, errsCh)
-	}
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
+		derivative, err := u.storeFile(ctx, derivativeKey, buf, imaging.Formats[format][1], ownerId)
 		if err != nil {
+			return nil, fmt.Errorf("store %s failed: %w", name, err)
+		}
+		if err := u.filesRepository.AttachDerivative(derivative.Id, original.Id); err != nil {
+			u.removeFile(derivative)
 			return nil, err
 		}
-		result := <-resultsCh
-		res = append(res, result)
+		variants[name] = derivative.Url
 	}
-	return res, nil
+	return variants, nil
 }
 
-
-func (u *filesUsecase) DeleteFileOnGCP(req []*files.DeleteFileReq) error {
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
-	defer cancel()
-
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return fmt.Errorf("storage.NewClient: %v", err)
-	}
-	defer client.Close()
-
-	jobsCh := make(chan *files.DeleteFileReq, len(req))
-	errsCh := make(chan error, len(req))
-
+// validateFiles inspects every file before any is uploaded, the stored
+// extension follows the detected content rather than the file name.
+func (u *filesUsecase) validateFiles(req []*files.FileReq) error {
+	rejections := make([]*files.FileRejection, 0)
 	for _, r := range req {
-		jobsCh <- r
-	}
-	close(jobsCh)
+		container, err := r.File.Open()
+		if err != nil {
+			return err
+		}
+		info, err := u.inspectImage(container)
+		container.Close()
+		if err != nil {
+			rejections = append(rejections, &files.FileRejection{
+				FileName: r.File.Filename,
+				Reason:   err.Error(),
+			})
+			continue
+		}
 
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.DeleteFileWorkers(ctx, client, jobsCh, errsCh)
+		r.ContentType = info.ContentType
+		if r.Extension != info.Ext {
+			filename := utils.RandFileName(info.Ext)
+			r.Destination = strings.TrimSuffix(r.Destination, r.FileName) + filename
+			r.FileName = filename
+			r.Extension = info.Ext
+		}
 	}
 
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
-		return err
+	if len(rejections) > 0 {
+		return &files.RejectedFilesError{Rejections: rejections}
 	}
 	return nil
-
 }
 
-func (u *filesUsecase) DeleteFileOnStorage(req []*files.DeleteFileReq) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
-	defer cancel()
-	jobsCh := make(chan *files.DeleteFileReq, len(req))
-	errsCh := make(chan error, len(req))
-	for _, r := range req {
-		jobsCh <- r
+// DownloadFile opens a private file for a caller holding a valid signed url,
+// only its owner or an admin may download it.
+func (u *filesUsecase) DownloadFile(req *files.DownloadFileReq) (io.ReadCloser, *storage.ObjectInfo, error) {
+	if err := u.signer.Verify(req.Key, req.Expires, req.Signature); err != nil {
+		return nil, nil, err
 	}
-	close(jobsCh)
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
-		go u.DeleteFromStorageFileWorkers(ctx, jobsCh, errsCh)
+	if !storage.IsPrivate(req.Key) {
+		return nil, nil, files.ErrFileNotFound
 	}
-	for a := 0; a < len(req); a++ {
-		err := <-errsCh
-		return err
-	}
-	return nil
-}
-
-func (u *filesUsecase) DeleteFileWorkers(ctx context.Context, client *storage.Client, jobs <-chan *files.DeleteFileReq, errs chan<- error) {
-	for job := range jobs {
-		o := client.Bucket(u.cfg.App().GCPBucket()).Object(job.Destination)
 
-		attrs, err := o.Attrs(ctx)
+	file, err := u.filesRepository.FindFileByKey(req.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !req.IsAdmin {
+		owner, err := u.filesRepository.IsFileOwner(file.Id, req.UserId)
 		if err != nil {
-			errs <- fmt.Errorf("object.Attrs:  %v", err)
-			return
+			return nil, nil, err
 		}
-
-		o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
-
-		if err := o.Delete(ctx); err != nil {
-			errs <- fmt.Errorf("Object(%q).Delete: %v", job.Destination, err)
-			return
+		// Another user's file is reported as missing
+		if !owner {
+			return nil, nil, files.ErrFileNotFound
 		}
-
-		fmt.Printf("Blob %v deleteed.\n", job.Destination)
-		errs <- nil
 	}
 
-}
-
-func (u *filesUsecase) DeleteFromStorageFileWorkers(ctx context.Context, jobs <-chan *files.DeleteFileReq, errs chan<- error) {
-	for job := range jobs {
-		if err := os.Remove("./assets/images/" + job.Destination); err != nil {
-			errs <- fmt.Errorf("remove file: %s failed: %v", job.Destination, err)
-			return
+	// The reader outlives this call, it is closed once the response is sent
+	r, info, err := u.storage.Open(context.Background(), file.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, files.ErrFileNotFound
 		}
-		errs <- nil
+		return nil, nil, err
 	}
+	return r, info, nil
 }
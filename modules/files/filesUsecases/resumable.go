@@ -0,0 +1,274 @@
+package filesUsecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jetsadawwts/go-restapi/pkg/utils"
+)
+
+// uploadExpiry is how long an unfinished resumable upload is kept
+const uploadExpiry = 24 * time.Hour
+
+func (u *filesUsecase) uploadPath(uploadId, ext string) (string, error) {
+	if _, err := uuid.Parse(uploadId); err != nil {
+		return "", files.ErrUploadNotFound
+	}
+	return filepath.Join(u.cfg.Storage().UploadDir(), uploadId+ext), nil
+}
+
+// lockUpload serializes the requests of one upload, an id that is not one
+// never gets a lock. The lock of an upload that is gone once released is
+// dropped after unlocking.
+func (u *filesUsecase) lockUpload(uploadId string) (func(), error) {
+	infoPath, err := u.uploadPath(uploadId, ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	mu, _ := u.uploadLocks.LoadOrStore(uploadId, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return func() {
+		mu.(*sync.Mutex).Unlock()
+		if _, err := os.Stat(infoPath); errors.Is(err, os.ErrNotExist) {
+			u.uploadLocks.CompareAndDelete(uploadId, mu)
+		}
+	}, nil
+}
+
+func (u *filesUsecase) saveUpload(upload *files.Upload) error {
+	infoPath, err := u.uploadPath(upload.Id, ".json")
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("marshal upload failed: %v", err)
+	}
+	if err := os.WriteFile(infoPath, b, 0666); err != nil {
+		return fmt.Errorf("write upload failed: %v", err)
+	}
+	return nil
+}
+
+func (u *filesUsecase) removeUpload(uploadId string) {
+	for _, ext := range []string{".bin", ".json"} {
+		if p, err := u.uploadPath(uploadId, ext); err == nil {
+			os.Remove(p)
+		}
+	}
+}
+
+func (u *filesUsecase) loadUpload(uploadId, userId string) (*files.Upload, error) {
+	infoPath, err := u.uploadPath(uploadId, ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, files.ErrUploadNotFound
+	}
+	upload := new(files.Upload)
+	if err := json.Unmarshal(b, upload); err != nil {
+		return nil, fmt.Errorf("unmarshal upload failed: %v", err)
+	}
+
+	// Another user's upload is reported as missing
+	if upload.UserId != userId {
+		return nil, files.ErrUploadNotFound
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		u.removeUpload(uploadId)
+		return nil, files.ErrUploadNotFound
+	}
+
+	// A signed url handed out earlier may have expired
+	if upload.IsCompleted() {
+		upload.SignedUrl = u.signedUrl(upload.Destination+"/"+upload.FileName, upload.Url)
+	}
+
+	// The staged bytes are the offset, the info may lag behind an interrupted chunk
+	if !upload.IsCompleted() {
+		dataPath, _ := u.uploadPath(uploadId, ".bin")
+		stat, err := os.Stat(dataPath)
+		if err != nil {
+			return nil, files.ErrUploadNotFound
+		}
+		upload.Offset = stat.Size()
+	}
+	return upload, nil
+}
+
+func (u *filesUsecase) CreateUpload(req *files.Upload) (*files.Upload, error) {
+	req.Id = uuid.NewString()
+	req.Offset = 0
+	req.ExpiresAt = time.Now().Add(uploadExpiry)
+
+	if err := os.MkdirAll(u.cfg.Storage().UploadDir(), 0777); err != nil {
+		return nil, fmt.Errorf("mkdir %q failed: %v", u.cfg.Storage().UploadDir(), err)
+	}
+
+	dataPath, _ := u.uploadPath(req.Id, ".bin")
+	data, err := os.Create(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("create upload failed: %v", err)
+	}
+	data.Close()
+
+	if err := u.saveUpload(req); err != nil {
+		u.removeUpload(req.Id)
+		return nil, err
+	}
+	return req, nil
+}
+
+func (u *filesUsecase) FindUpload(uploadId, userId string) (*files.Upload, error) {
+	unlock, err := u.lockUpload(uploadId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return u.loadUpload(uploadId, userId)
+}
+
+func (u *filesUsecase) WriteUploadChunk(req *files.UploadChunkReq) (*files.Upload, error) {
+	unlock, err := u.lockUpload(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	upload, err := u.loadUpload(req.Id, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if upload.IsCompleted() {
+		return nil, files.ErrUploadCompleted
+	}
+	if upload.Offset != req.Offset {
+		return upload, files.ErrUploadOffsetMismatch
+	}
+	if req.Offset+req.Size > upload.Length {
+		return upload, files.ErrUploadTooLarge
+	}
+
+	dataPath, _ := u.uploadPath(upload.Id, ".bin")
+	data, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open upload failed: %v", err)
+	}
+
+	// Bytes of an interrupted chunk are kept, the client resumes from the new offset
+	n, err := io.CopyN(data, req.Body, req.Size)
+	data.Close()
+	upload.Offset += n
+	if err != nil {
+		return upload, fmt.Errorf("write upload chunk failed: %v", err)
+	}
+
+	if upload.Offset == upload.Length {
+		if err := u.completeUpload(upload); err != nil {
+			// A rejected file is dropped, it can never complete
+			var rejected *files.RejectedFilesError
+			if errors.As(err, &rejected) {
+				u.removeUpload(upload.Id)
+				return nil, err
+			}
+			return upload, err
+		}
+	}
+
+	if err := u.saveUpload(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// completeUpload streams the staged file to the storage backend
+func (u *filesUsecase) completeUpload(upload *files.Upload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), u.cfg.Storage().Timeout())
+	defer cancel()
+
+	dataPath, _ := u.uploadPath(upload.Id, ".bin")
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return fmt.Errorf("open upload failed: %v", err)
+	}
+	defer data.Close()
+
+	info, err := u.inspectImage(data)
+	if err != nil {
+		return &files.RejectedFilesError{
+			Rejections: []*files.FileRejection{
+				{
+					FileName: upload.FileName,
+					Reason:   err.Error(),
+				},
+			},
+		}
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek upload failed: %v", err)
+	}
+	if upload.Extension != info.Ext {
+		upload.FileName = utils.RandFileName(info.Ext)
+		upload.Extension = info.Ext
+	}
+
+	stripped := stripMetadata(data, info.ContentType)
+	file, err := u.storeFile(
+		ctx,
+		upload.Destination+"/"+upload.FileName,
+		stripped,
+		info.ContentType,
+		upload.UserId,
+	)
+	stripped.Close()
+	if err != nil {
+		return err
+	}
+
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		u.removeFile(file)
+		return fmt.Errorf("seek upload failed: %v", err)
+	}
+	variants, err := u.uploadDerivatives(ctx, data, file)
+	if err != nil {
+		// A failed upload leaves nothing behind
+		u.removeFile(file)
+		return err
+	}
+	upload.Url = file.Url
+	upload.Variants = variants
+	upload.SignedUrl = u.signedUrl(file.StorageKey, file.Url)
+
+	data.Close()
+	os.Remove(dataPath)
+	return nil
+}
+
+func (u *filesUsecase) DeleteUpload(uploadId, userId string) error {
+	unlock, err := u.lockUpload(uploadId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := u.loadUpload(uploadId, userId); err != nil {
+		return err
+	}
+	u.removeUpload(uploadId)
+	return nil
+}
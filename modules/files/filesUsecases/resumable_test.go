@@ -0,0 +1,152 @@
+package filesUsecases
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jetsadawwts/go-restapi/config"
+	"github.com/jetsadawwts/go-restapi/modules/files"
+)
+
+// uploadConfig stands in for the loaded config, only the upload dir is read
+// until an upload completes.
+type uploadConfig struct {
+	config.IConfig
+	config.IStorageConfig
+	uploadDir string
+}
+
+func (c *uploadConfig) Storage() config.IStorageConfig { return c }
+func (c *uploadConfig) UploadDir() string              { return c.uploadDir }
+
+func newUploadUsecase(t *testing.T) *filesUsecase {
+	return &filesUsecase{cfg: &uploadConfig{uploadDir: t.TempDir()}}
+}
+
+// errAny is any error that is not one of the upload errors
+var errAny = errors.New("any error")
+
+func TestWriteUploadChunk(t *testing.T) {
+	u := newUploadUsecase(t)
+	upload, err := u.CreateUpload(&files.Upload{UserId: "U000001", FileName: "a.png", Length: 10})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	// The steps run in order against the same upload
+	tests := []struct {
+		name   string
+		offset int64
+		body   string
+		size   int64
+		want   int64 // offset after the chunk
+		err    error // nil for a chunk written, errAny for any other error
+	}{
+		{"first chunk", 0, "abcd", 4, 4, nil},
+		{"replayed chunk", 0, "abcd", 4, 4, files.ErrUploadOffsetMismatch},
+		{"ahead of the offset", 6, "gh", 2, 4, files.ErrUploadOffsetMismatch},
+		{"past the length", 4, "efghijk", 7, 4, files.ErrUploadTooLarge},
+		// The connection dropped after 3 of the 4 bytes, they are kept
+		{"interrupted chunk", 4, "efg", 4, 7, errAny},
+		{"resumed from the staged bytes", 7, "hi", 2, 9, nil},
+		{"resumed twice", 7, "hi", 2, 9, files.ErrUploadOffsetMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := u.WriteUploadChunk(&files.UploadChunkReq{
+				Id:     upload.Id,
+				UserId: "U000001",
+				Offset: tt.offset,
+				Size:   tt.size,
+				Body:   strings.NewReader(tt.body),
+			})
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("WriteUploadChunk = %v, want nil", err)
+			case tt.err == errAny && err == nil:
+				t.Fatalf("WriteUploadChunk = nil, want an error")
+			case tt.err != nil && tt.err != errAny && !errors.Is(err, tt.err):
+				t.Fatalf("WriteUploadChunk = %v, want %v", err, tt.err)
+			}
+			if got == nil || got.Offset != tt.want {
+				t.Errorf("WriteUploadChunk offset = %+v, want %d", got, tt.want)
+			}
+
+			found, err := u.FindUpload(upload.Id, "U000001")
+			if err != nil {
+				t.Fatalf("FindUpload failed: %v", err)
+			}
+			if found.Offset != tt.want {
+				t.Errorf("FindUpload offset = %d, want %d", found.Offset, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindUpload(t *testing.T) {
+	u := newUploadUsecase(t)
+	upload, err := u.CreateUpload(&files.Upload{UserId: "U000001", FileName: "a.png", Length: 10})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	expired, err := u.CreateUpload(&files.Upload{UserId: "U000001", FileName: "b.png", Length: 10})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := u.saveUpload(expired); err != nil {
+		t.Fatalf("saveUpload failed: %v", err)
+	}
+	deleted, err := u.CreateUpload(&files.Upload{UserId: "U000001", FileName: "c.png", Length: 10})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	if err := u.DeleteUpload(deleted.Id, "U000001"); err != nil {
+		t.Fatalf("DeleteUpload failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		uploadId string
+		userId   string
+		ok       bool
+	}{
+		{"own upload", upload.Id, "U000001", true},
+		{"another user's upload", upload.Id, "U000002", false},
+		{"never created", uuid.NewString(), "U000001", false},
+		{"not an id", "../../config", "U000001", false},
+		{"expired", expired.Id, "U000001", false},
+		{"deleted", deleted.Id, "U000001", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := u.FindUpload(tt.uploadId, tt.userId)
+			if tt.ok && (err != nil || found.Offset != 0) {
+				t.Errorf("FindUpload = (%+v, %v), want the upload at offset 0", found, err)
+			}
+			if !tt.ok && !errors.Is(err, files.ErrUploadNotFound) {
+				t.Errorf("FindUpload = %v, want ErrUploadNotFound", err)
+			}
+		})
+	}
+
+	// An expired upload is removed once found
+	if p, _ := u.uploadPath(expired.Id, ".bin"); fileExists(p) {
+		t.Errorf("expired upload is still staged")
+	}
+	// Locks are only kept for uploads still staged
+	if _, ok := u.uploadLocks.Load(deleted.Id); ok {
+		t.Errorf("lock of a deleted upload is kept")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
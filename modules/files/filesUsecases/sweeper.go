@@ -0,0 +1,214 @@
+package filesUsecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/modules/files"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
+)
+
+// sweepBatch is how many orphans are looked up at a time
+const sweepBatch = 100
+
+// legacySlipPrefix is where transfer slips were stored, readable by anyone,
+// before they were kept private.
+const legacySlipPrefix = "images/slips/"
+
+// SweepOrphanFiles removes the files nothing has referenced for the grace
+// period, it returns how many files were removed.
+func (u *filesUsecase) SweepOrphanFiles(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	removed := 0
+	for {
+		orphans, err := u.filesRepository.FindOrphanFiles(u.cfg.Storage().OrphanGrace(), sweepBatch)
+		if err != nil {
+			return removed, err
+		}
+
+		swept := 0
+		for _, f := range orphans {
+			if ctx.Err() != nil {
+				return removed + swept, ctx.Err()
+			}
+			err := u.filesRepository.DeleteOrphanFile(f.Id, func() error {
+				return u.deleteObject(ctx, f)
+			})
+			if err != nil {
+				// Attached since it was listed
+				if errors.Is(err, files.ErrFileInUse) {
+					continue
+				}
+				log.Printf("sweep %s failed: %v\n", f.StorageKey, err)
+				continue
+			}
+			swept++
+		}
+		removed += swept
+
+		// A batch with nothing removed would be listed again
+		if len(orphans) < sweepBatch || swept == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// sweepUploads drops the staged resumable uploads past their expiry
+func (u *filesUsecase) sweepUploads() {
+	// Nothing staged yet when the dir is missing
+	entries, _ := os.ReadDir(u.cfg.Storage().UploadDir())
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		uploadId := strings.TrimSuffix(e.Name(), ".json")
+
+		b, err := os.ReadFile(filepath.Join(u.cfg.Storage().UploadDir(), e.Name()))
+		if err != nil {
+			continue
+		}
+		upload := new(files.Upload)
+		if err := json.Unmarshal(b, upload); err != nil {
+			continue
+		}
+		if time.Now().After(upload.ExpiresAt) {
+			unlock, err := u.lockUpload(uploadId)
+			if err != nil {
+				continue
+			}
+			u.removeUpload(uploadId)
+			unlock()
+		}
+	}
+}
+
+// moveLegacySlips copies the public transfer slips under the private prefix,
+// a public object is only deleted once its order points at the private copy.
+// It returns how many slips were moved.
+func (u *filesUsecase) moveLegacySlips(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	moved := 0
+	for {
+		slips, err := u.filesRepository.FindFilesByPrefix(legacySlipPrefix, sweepBatch)
+		if err != nil {
+			return moved, err
+		}
+
+		batch := 0
+		for _, f := range slips {
+			if ctx.Err() != nil {
+				return moved + batch, ctx.Err()
+			}
+			if err := u.moveSlip(ctx, f); err != nil {
+				log.Printf("move slip %s failed: %v\n", f.StorageKey, err)
+				continue
+			}
+			batch++
+		}
+		moved += batch
+
+		// A batch with nothing moved would be listed again
+		if len(slips) < sweepBatch || batch == 0 {
+			return moved, nil
+		}
+	}
+}
+
+func (u *filesUsecase) moveSlip(ctx context.Context, f *files.File) error {
+	key := files.SlipDestination + "/" + path.Base(f.StorageKey)
+	if _, err := u.filesRepository.FindFileByKey(key); !errors.Is(err, files.ErrFileNotFound) {
+		if err == nil {
+			return files.ErrFileExists
+		}
+		return err
+	}
+
+	r, info, err := u.storage.Open(ctx, f.StorageKey)
+	if err != nil {
+		return err
+	}
+	_, err = u.storage.Upload(ctx, key, r, info.ContentType)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := u.filesRepository.MoveFile(f, key, u.signer.Url(key)); err != nil {
+		// Claimed in the meantime, the object is not ours to delete
+		if !errors.Is(err, files.ErrFileExists) {
+			if err := u.storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("delete unregistered %s failed: %v\n", key, err)
+			}
+		}
+		return err
+	}
+	return u.deleteObject(ctx, f)
+}
+
+// RunSweeper makes the legacy transfer slips private, then sweeps the orphan
+// files at the configured interval until ctx is done. Every run is under an
+// advisory lock, only one replica sweeps at a time.
+func (u *filesUsecase) RunSweeper(ctx context.Context) {
+	u.runLocked(ctx, "move legacy slips", func() {
+		moved, err := u.moveLegacySlips(ctx)
+		if err != nil {
+			log.Printf("move legacy slips failed: %v\n", err)
+		}
+		if moved > 0 {
+			log.Printf("%d legacy slips made private\n", moved)
+		}
+	})
+
+	if u.cfg.Storage().SweepInterval() <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(u.cfg.Storage().SweepInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Staged uploads are local to each replica
+		u.sweepUploads()
+
+		u.runLocked(ctx, "sweep orphan files", func() {
+			removed, err := u.SweepOrphanFiles(ctx)
+			if err != nil {
+				log.Printf("sweep orphan files failed: %v\n", err)
+			}
+			if removed > 0 {
+				log.Printf("%d orphan files swept\n", removed)
+			}
+		})
+	}
+}
+
+// runLocked runs a sweep unless another replica holds the sweeper lock
+func (u *filesUsecase) runLocked(ctx context.Context, name string, run func()) {
+	unlock, ok, err := u.filesRepository.TryLockSweeper(ctx)
+	if err != nil {
+		log.Printf("%s failed: %v\n", name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+	run()
+}
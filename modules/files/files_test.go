@@ -0,0 +1,35 @@
+package files
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   map[string]string
+		ok     bool
+	}{
+		{"empty", "  ", map[string]string{}, true},
+		{"pairs", "filename YS5wbmc=,destination aW1hZ2Vz", map[string]string{"filename": "a.png", "destination": "images"}, true},
+		{"spaces around pairs", " filename YS5wbmc= , destination aW1hZ2Vz ", map[string]string{"filename": "a.png", "destination": "images"}, true},
+		{"key without a value", "filename YS5wbmc=,is_confidential", map[string]string{"filename": "a.png", "is_confidential": ""}, true},
+		{"value not base64", "filename a.png", nil, false},
+		{"too many fields", "filename YS5wbmc= extra", nil, false},
+		{"empty pair", "filename YS5wbmc=,,destination aW1hZ2Vz", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUploadMetadata(tt.header)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseUploadMetadata error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUploadMetadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
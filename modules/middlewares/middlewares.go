@@ -1,6 +1,24 @@
 package middlewares
 
-type Role struct {
-	Id    int    `db:"id"`
-	Title string `db:"title"`
+// ApiKey is a key still usable, neither revoked nor expired
+type ApiKey struct {
+	Id      string   `json:"id"`
+	OwnerId string   `json:"owner_id"`
+	Scopes  []string `json:"scopes"`
+}
+
+func (obj *ApiKey) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		granted := false
+		for _, s := range obj.Scopes {
+			if s == scope {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
 }
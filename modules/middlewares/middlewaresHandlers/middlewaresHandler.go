@@ -1,18 +1,22 @@
 package middlewaresHandlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/middlewares/middlewaresUsecases"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
 	"github.com/jetsadawwts/go-restapi/pkg/auth"
-	"github.com/jetsadawwts/go-restapi/pkg/utils"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
 )
 
 type middlewareHandlersErrCode string
@@ -21,8 +25,12 @@ const (
 	routerCheckErr middlewareHandlersErrCode = "middleware-001"
 	jwtAuthErr     middlewareHandlersErrCode = "middleware-002"
 	paramsCheckErr middlewareHandlersErrCode = "middleware-003"
-	authorizeErr   middlewareHandlersErrCode = "middleware-004"
+	permissionErr  middlewareHandlersErrCode = "middleware-004"
 	apiKeyErr      middlewareHandlersErrCode = "middleware-005"
+	apiKeyScopeErr middlewareHandlersErrCode = "middleware-006"
+	emailCheckErr  middlewareHandlersErrCode = "middleware-007"
+	twoFactorErr   middlewareHandlersErrCode = "middleware-008"
+	bodyLimitErr   middlewareHandlersErrCode = "middleware-009"
 )
 
 type IMiddlewaresHandler interface {
@@ -30,21 +38,55 @@ type IMiddlewaresHandler interface {
 	RouterCheck() fiber.Handler
 	Logger() fiber.Handler
 	JwtAuth() fiber.Handler
-	ParamsCheck() fiber.Handler
-	Authorize(expectRoleId ...int) fiber.Handler
-	ApiKeyAuth() fiber.Handler
+	ParamsCheck(permission string) fiber.Handler
+	RequirePermission(permissions ...string) fiber.Handler
+	ApiKeyAuth(scopes ...string) fiber.Handler
+	VerifiedEmail() fiber.Handler
 	StreamingFile() fiber.Handler
+	BodyLimit() fiber.Handler
 }
 
 type middlewaresHandler struct {
 	cfg                config.IConfig
 	middlewaresUsecase middlewaresUsecases.IMiddlewaresUsecase
+	storage            storage.IStorage
+	keyset             auth.IKeyset
 }
 
-func MiddlewaresHandler(cfg config.IConfig, middlewaresUsecase middlewaresUsecases.IMiddlewaresUsecase) IMiddlewaresHandler {
+func MiddlewaresHandler(cfg config.IConfig, middlewaresUsecase middlewaresUsecases.IMiddlewaresUsecase, storage storage.IStorage, keyset auth.IKeyset) IMiddlewaresHandler {
 	return &middlewaresHandler{
 		cfg:                cfg,
 		middlewaresUsecase: middlewaresUsecase,
+		storage:            storage,
+		keyset:             keyset,
+	}
+}
+
+// BodyLimit refuses a body over the app limit before anything reads it, the
+// server streams request bodies and stops enforcing the limit itself. The
+// chunks of resumable uploads are bounded by the length of their upload.
+func (h *middlewaresHandler) BodyLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodPatch && c.Get(fiber.HeaderContentType) == "application/offset+octet-stream" {
+			return c.Next()
+		}
+
+		switch length := c.Request().Header.ContentLength(); {
+		case length == -1:
+			// A chunked body has no length to check up front
+			return entities.NewResponse(c).Error(
+				fiber.StatusLengthRequired,
+				string(bodyLimitErr),
+				"content length is required",
+			).Res()
+		case length > h.cfg.App().BodyLimit():
+			return entities.NewResponse(c).Error(
+				fiber.StatusRequestEntityTooLarge,
+				string(bodyLimitErr),
+				fmt.Sprintf("body must be at most %d bytes", h.cfg.App().BodyLimit()),
+			).Res()
+		}
+		return c.Next()
 	}
 }
 
@@ -55,7 +97,7 @@ func (h *middlewaresHandler) Cors() fiber.Handler {
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH",
 		AllowHeaders:     "",
 		AllowCredentials: false,
-		ExposeHeaders:    "",
+		ExposeHeaders:    "Location,Upload-Offset,Upload-Length,Upload-Expires,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size",
 		MaxAge:           0,
 	})
 }
@@ -83,7 +125,7 @@ func (h *middlewaresHandler) Logger() fiber.Handler {
 func (h *middlewaresHandler) JwtAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
-		result, err := auth.ParseToken(h.cfg.Jwt(), token)
+		result, err := auth.ParseToken(h.keyset, token)
 		if err != nil {
 			return entities.NewResponse(c).Error(
 				fiber.ErrUnauthorized.Code,
@@ -93,94 +135,305 @@ func (h *middlewaresHandler) JwtAuth() fiber.Handler {
 		}
 
 		claims := result.Claims
-		if !h.middlewaresUsecase.FindAccessToken(claims.Id, token) {
+		roleId, ok := h.middlewaresUsecase.FindAccessToken(claims.Id, token)
+		if !ok {
 			return entities.NewResponse(c).Error(
 				fiber.ErrUnauthorized.Code,
 				string(jwtAuthErr),
 				"No permission to access",
 			).Res()
 		}
+		permissions, err := h.middlewaresUsecase.FindPermissions(roleId)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(jwtAuthErr),
+				err.Error(),
+			).Res()
+		}
+		withheld, err := h.withholdPermissions(claims.Id, permissions)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(twoFactorErr),
+				err.Error(),
+			).Res()
+		}
 		c.Locals("userId", claims.Id)
-		c.Locals("userRoleId", claims.RoleId)
+		c.Locals("userRoleId", roleId)
+		if withheld {
+			// Handlers checking a permission see it as not granted
+			c.Locals("userPermissions", roles.Permissions{})
+			c.Locals("withheldPermissions", permissions)
+		} else {
+			c.Locals("userPermissions", permissions)
+		}
 		return c.Next()
 	}
 }
 
-func (h *middlewaresHandler) ParamsCheck() fiber.Handler {
+// ParamsCheck lets users act on their own :user_id, the users granted the
+// permission act on the one of anybody.
+func (h *middlewaresHandler) ParamsCheck(permission string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userId := c.Locals("userId")
-		if c.Locals("userRoleId").(int) == 2 {
+		if c.Params("user_id") == c.Locals("userId") {
+			return c.Next()
+		}
+		if c.Locals("userPermissions").(roles.Permissions).Has(permission) {
 			return c.Next()
 		}
-		if c.Params("user_id") != userId {
+		if res := twoFactorPending(c, permission); res != nil {
+			return res.Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(paramsCheckErr),
+			"Never gonna give you up",
+		).Res()
+	}
+}
+
+// RequirePermission lets through the users whose role grants every
+// permission asked for, JwtAuth has to run first.
+func (h *middlewaresHandler) RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		granted, ok := c.Locals("userPermissions").(roles.Permissions)
+		if ok && granted.Has(permissions...) {
+			return c.Next()
+		}
+		if res := twoFactorPending(c, permissions...); res != nil {
+			return res.Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrForbidden.Code,
+			string(permissionErr),
+			fmt.Sprintf("Missing the permissions %s.", strings.Join(permissions, ", ")),
+		).Res()
+	}
+}
+
+// ApiKeyAuth lets through the requests with a usable api key granted every
+// scope asked for, no scopes accepts any usable key.
+func (h *middlewaresHandler) ApiKeyAuth(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-Api-key")
+		apiKey, err := h.middlewaresUsecase.FindApiKey(key)
+		if err != nil {
+			// The jwt keys predate scopes, they could do anything
+			if h.cfg.Jwt().LegacyApiKeys() {
+				if _, err := auth.ParseApiKey(h.keyset, key); err == nil {
+					return c.Next()
+				}
+			}
 			return entities.NewResponse(c).Error(
 				fiber.ErrUnauthorized.Code,
-				string(paramsCheckErr),
-				"Never gonna give you up",
+				string(apiKeyErr),
+				"Api key is invalid or required.",
+			).Res()
+		}
+
+		if !apiKey.HasScopes(scopes...) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(apiKeyScopeErr),
+				fmt.Sprintf("Api key is missing the scopes %s.", strings.Join(scopes, ", ")),
 			).Res()
 		}
+		c.Locals("apiKeyId", apiKey.Id)
 		return c.Next()
 	}
 }
 
-func (h *middlewaresHandler) Authorize(expectRoleId ...int) fiber.Handler {
+// VerifiedEmail stops customers whose email is not verified yet when the
+// config asks for it, users placing orders for anybody are never stopped.
+func (h *middlewaresHandler) VerifiedEmail() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userRoldId, ok := c.Locals("userRoleId").(int)
-		if !ok {
-			return entities.NewResponse(c).Error(
-				fiber.ErrUnauthorized.Code,
-				string(authorizeErr),
-				"User id is not int type",
-			).Res()
+		if !h.cfg.App().RequireVerifiedEmail() || c.Locals("userPermissions").(roles.Permissions).Has(roles.PermOrdersWriteAll) {
+			return c.Next()
 		}
 
-		roles, err := h.middlewaresUsecase.FindRole()
+		verified, err := h.middlewaresUsecase.FindEmailVerified(c.Locals("userId").(string))
 		if err != nil {
 			return entities.NewResponse(c).Error(
 				fiber.ErrInternalServerError.Code,
-				string(authorizeErr),
+				string(emailCheckErr),
 				err.Error(),
 			).Res()
 		}
-
-		sum := 0
-		for _, v := range expectRoleId {
-			sum += v
+		if !verified {
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(emailCheckErr),
+				"Email has to be verified first.",
+			).Res()
 		}
+		return c.Next()
+	}
+}
 
-		expectedValueBinary := utils.BinaryConverter(sum, len(roles))
-		userValueBinary := utils.BinaryConverter(userRoldId, len(roles))
+func (h *middlewaresHandler) StreamingFile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Remote providers serve their objects themselves
+		if !h.storage.SelfHosted() || (c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead) {
+			return c.Next()
+		}
+		// Private objects are only served by the signed download route
+		if storage.IsPrivate(c.Path()) {
+			return c.Next()
+		}
 
-		for i := range userValueBinary {
-			if userValueBinary[i]&expectedValueBinary[i] == 1 {
+		file, info, err := h.storage.Open(c.UserContext(), c.Path())
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
 				return c.Next()
 			}
+			return err
 		}
 
-		return entities.NewResponse(c).Error(
-			fiber.ErrUnauthorized.Code,
-			string(authorizeErr),
-			"No permission to access.",
-		).Res()
+		if info.ContentType != "" {
+			c.Set(fiber.HeaderContentType, info.ContentType)
+		}
+		c.Set(fiber.HeaderLastModified, info.ModTime.UTC().Format(http.TimeFormat))
+		c.Set(fiber.HeaderCacheControl, h.cachePolicy(c.Path()))
+		if info.ETag != "" {
+			c.Set(fiber.HeaderETag, info.ETag)
+		}
+
+		if notModified(c, info) {
+			file.Close()
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		// Ranges need to seek, other readers are always sent whole
+		seeker, ok := file.(io.ReadSeeker)
+		if !ok {
+			return c.SendStream(file, int(info.Size))
+		}
+		c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+		if c.Get(fiber.HeaderRange) == "" || !rangeApplies(c, info) {
+			return c.SendStream(file, int(info.Size))
+		}
+
+		rng, err := c.Range(int(info.Size))
+		if err != nil {
+			// A malformed range is ignored
+			if errors.Is(err, fiber.ErrRangeUnsatisfiable) {
+				file.Close()
+				c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+				return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+			}
+			return c.SendStream(file, int(info.Size))
+		}
+		// Several ranges would need a multipart body, the whole file is fine too
+		if rng.Type != "bytes" || len(rng.Ranges) != 1 {
+			return c.SendStream(file, int(info.Size))
+		}
+
+		start, end := rng.Ranges[0].Start, rng.Ranges[0].End
+		if _, err := seeker.Seek(int64(start), io.SeekStart); err != nil {
+			file.Close()
+			return err
+		}
+		c.Status(fiber.StatusPartialContent)
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size))
+		return c.SendStream(readCloser{io.LimitReader(seeker, int64(end-start+1)), file}, end-start+1)
 	}
 }
 
-func (h *middlewaresHandler) ApiKeyAuth() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		key := c.Get("X-Api-key")
-		if _, err := auth.ParseApiKey(h.cfg.Jwt(), key); err != nil {
-			return entities.NewResponse(c).Error(
-				fiber.ErrUnauthorized.Code,
-				string(apiKeyErr),
-				"Api key is invalid or required.",
-			).Res()
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// cachePolicy picks the Cache-Control of the longest configured prefix of a key
+func (h *middlewaresHandler) cachePolicy(key string) string {
+	key = strings.Trim(key, "/")
+
+	policy, longest := "no-cache", -1
+	for prefix, p := range h.cfg.Storage().CacheControl() {
+		if prefix != "" && key != prefix && !strings.HasPrefix(key, prefix+"/") {
+			continue
+		}
+		if len(prefix) > longest {
+			policy, longest = p, len(prefix)
 		}
-		return c.Next()
 	}
+	return policy
 }
 
-func (h *middlewaresHandler) StreamingFile() fiber.Handler {
-	return filesystem.New(filesystem.Config{
-		Root: http.Dir("./assets/images"),
-	})
+// etagMatches tells whether an If-None-Match or If-Range list holds the etag
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates the conditional GET headers, If-None-Match wins over If-Modified-Since
+func notModified(c *fiber.Ctx, info *storage.ObjectInfo) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		return info.ETag != "" && etagMatches(noneMatch, info.ETag, true)
+	}
+	if modifiedSince := c.Get(fiber.HeaderIfModifiedSince); modifiedSince != "" {
+		since, err := http.ParseTime(modifiedSince)
+		if err != nil {
+			return false
+		}
+		return !info.ModTime.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// rangeApplies checks If-Range, a range of a changed file is not worth sending
+func rangeApplies(c *fiber.Ctx, info *storage.ObjectInfo) bool {
+	ifRange := c.Get(fiber.HeaderIfRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, "\"") {
+		return info.ETag != "" && ifRange == info.ETag
+	}
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return info.ModTime.Truncate(time.Second).Equal(since)
+}
+
+// withholdPermissions tells whether the permissions of a user are held back
+// until the user enabled 2fa, when the config makes it mandatory. The 2fa
+// routes themselves only need a signed in user so admins can still enroll.
+func (h *middlewaresHandler) withholdPermissions(userId string, permissions roles.Permissions) (bool, error) {
+	if len(permissions) == 0 || !h.cfg.Totp().AdminRequired() {
+		return false, nil
+	}
+	enabled, err := h.middlewaresUsecase.FindTotpEnabled(userId)
+	if err != nil {
+		return false, err
+	}
+	return !enabled, nil
+}
+
+// twoFactorPending is the refusal of a request the user's role would allow
+// once 2fa is enabled, nil when the role does not grant the permissions.
+func twoFactorPending(c *fiber.Ctx, permissions ...string) entities.IResponse {
+	withheld, ok := c.Locals("withheldPermissions").(roles.Permissions)
+	if !ok || !withheld.Has(permissions...) {
+		return nil
+	}
+	return entities.NewResponse(c).Error(
+		fiber.ErrForbidden.Code,
+		string(twoFactorErr),
+		"Two-factor authentication has to be enabled for admin access.",
+	)
 }
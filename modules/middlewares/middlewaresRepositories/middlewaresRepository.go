@@ -1,6 +1,8 @@
 package middlewaresRepositories
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/jetsadawwts/go-restapi/modules/middlewares"
@@ -8,8 +10,13 @@ import (
 )
 
 type IMiddlewaresRepository interface {
-	FindAccessToken(userId, accessToken string) bool
-	FindRole() ([]*middlewares.Role, error)
+	FindAccessToken(userId, accessToken string) (int, bool)
+	TouchOauth(userId, accessToken string) error
+	FindRolePermissions(roleId int) ([]string, error)
+	FindApiKey(keyHash string) (*middlewares.ApiKey, error)
+	TouchApiKey(apiKeyId string) error
+	FindEmailVerified(userId string) (bool, error)
+	FindTotpEnabled(userId string) (bool, error)
 }
 
 type middlewaresRepository struct {
@@ -22,36 +29,127 @@ func MiddlewaresRepository(db *sqlx.DB) IMiddlewaresRepository {
 	}
 }
 
-func (r *middlewaresRepository) FindAccessToken(userId, accessToken string) bool {
+// FindAccessToken checks the token is still live and returns the role the
+// user holds now, it may have changed since the token was signed.
+func (r *middlewaresRepository) FindAccessToken(userId, accessToken string) (int, bool) {
 	query := `
 	SELECT
-		(CASE WHEN COUNT(*) = 1 THEN TRUE ELSE FALSE END)
-	FROM "oauth"
-	WHERE "user_id" = $1
-	AND "access_token" = $2;`
+		"u"."role_id"
+	FROM "oauth" "o"
+	JOIN "users" "u" ON "u"."id" = "o"."user_id"
+	WHERE "o"."user_id" = $1
+	AND "o"."access_token" = $2
+	AND "o"."revoked_at" IS NULL
+	LIMIT 1;`
 
-	var check bool
-	if err := r.db.Get(&check, query, userId, accessToken); err != nil {
-		return false
+	var roleId int
+	if err := r.db.Get(&roleId, query, userId, accessToken); err != nil {
+		return 0, false
 	}
 
-	return true
+	return roleId, true
 }
 
-func (r *middlewaresRepository) FindRole() ([]*middlewares.Role, error) {
+func (r *middlewaresRepository) FindRolePermissions(roleId int) ([]string, error) {
 	query := `
 	SELECT
-		"id",
-		"title"
+		COALESCE(array_to_json("permissions"), '[]'::json)
 	FROM "roles"
-	ORDER BY "id" DESC;
-	`
+	WHERE "id" = $1;`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, roleId); err != nil {
+		return nil, fmt.Errorf("get role permissions failed: %v", err)
+	}
+
+	permissions := make([]string, 0)
+	if err := json.Unmarshal(raw, &permissions); err != nil {
+		return nil, fmt.Errorf("unmarshal role permissions failed: %v", err)
+	}
+	return permissions, nil
+}
+
+func (r *middlewaresRepository) FindApiKey(keyHash string) (*middlewares.ApiKey, error) {
+	query := `
+	SELECT
+		to_jsonb("t")
+	FROM (
+		SELECT
+			"k"."id",
+			"k"."owner_id",
+			"k"."scopes"
+		FROM "api_keys" "k"
+		WHERE "k"."key_hash" = $1
+		AND "k"."revoked_at" IS NULL
+		AND ("k"."expires_at" IS NULL OR "k"."expires_at" > now())
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, keyHash); err != nil {
+		return nil, fmt.Errorf("api key not found")
+	}
+
+	apiKey := new(middlewares.ApiKey)
+	if err := json.Unmarshal(raw, apiKey); err != nil {
+		return nil, fmt.Errorf("unmarshal api key failed: %v", err)
+	}
+	return apiKey, nil
+}
+
+// TouchApiKey records the use of a key, at most once a minute to spare the
+// writes of busy clients.
+func (r *middlewaresRepository) TouchApiKey(apiKeyId string) error {
+	query := `
+	UPDATE "api_keys" SET
+		"last_used_at" = now()
+	WHERE "id" = $1
+	AND ("last_used_at" IS NULL OR "last_used_at" < now() - interval '1 minute');`
 
-	roles := make([]*middlewares.Role, 0)
-	if err := r.db.Select(&roles, query); err != nil {
-		return nil, fmt.Errorf("Roles are empty.")
+	if _, err := r.db.ExecContext(context.Background(), query, apiKeyId); err != nil {
+		return fmt.Errorf("update api key failed: %v", err)
 	}
+	return nil
+}
+
+// TouchOauth records the use of a session, at most once a minute like the api keys
+func (r *middlewaresRepository) TouchOauth(userId, accessToken string) error {
+	query := `
+	UPDATE "oauth" SET
+		"last_used_at" = now()
+	WHERE "user_id" = $1
+	AND "access_token" = $2
+	AND "last_used_at" < now() - interval '1 minute';`
+
+	if _, err := r.db.ExecContext(context.Background(), query, userId, accessToken); err != nil {
+		return fmt.Errorf("update oauth failed: %v", err)
+	}
+	return nil
+}
+
+func (r *middlewaresRepository) FindEmailVerified(userId string) (bool, error) {
+	query := `
+	SELECT
+		"email_verified"
+	FROM "users"
+	WHERE "id" = $1;`
+
+	var verified bool
+	if err := r.db.Get(&verified, query, userId); err != nil {
+		return false, fmt.Errorf("get user failed: %v", err)
+	}
+	return verified, nil
+}
 
-	return roles, nil
+func (r *middlewaresRepository) FindTotpEnabled(userId string) (bool, error) {
+	query := `
+	SELECT
+		"totp_enabled"
+	FROM "users"
+	WHERE "id" = $1;`
 
+	var enabled bool
+	if err := r.db.Get(&enabled, query, userId); err != nil {
+		return false, fmt.Errorf("get user failed: %v", err)
+	}
+	return enabled, nil
 }
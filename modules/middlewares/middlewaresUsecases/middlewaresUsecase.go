@@ -1,33 +1,105 @@
 package middlewaresUsecases
 
 import (
+	"log"
+	"sync"
+	"time"
+
 	"github.com/jetsadawwts/go-restapi/modules/middlewares"
 	"github.com/jetsadawwts/go-restapi/modules/middlewares/middlewaresRepositories"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/pkg/auth"
 )
 
 type IMiddlewaresUsecase interface {
-	FindAccessToken(userId, accessToken string) bool
-	FindRole() ([]*middlewares.Role, error)
+	FindAccessToken(userId, accessToken string) (int, bool)
+	FindPermissions(roleId int) (roles.Permissions, error)
+	FindApiKey(key string) (*middlewares.ApiKey, error)
+	FindEmailVerified(userId string) (bool, error)
+	FindTotpEnabled(userId string) (bool, error)
+}
+
+// permissionsTTL is how long the permissions of a role are cached, a change
+// of a role reaches every request after at most this long.
+const permissionsTTL = 30 * time.Second
+
+type cachedPermissions struct {
+	permissions roles.Permissions
+	expires     time.Time
 }
 
 type middlewaresUsecase struct {
 	middlewaresRepository middlewaresRepositories.IMiddlewaresRepository
+	mu                    sync.Mutex
+	permissions           map[int]*cachedPermissions
 }
 
 func MiddlewaresUsecase(middlewaresRepository middlewaresRepositories.IMiddlewaresRepository) IMiddlewaresUsecase {
 	return &middlewaresUsecase{
 		middlewaresRepository: middlewaresRepository,
+		permissions:           make(map[int]*cachedPermissions),
+	}
+}
+
+func (u *middlewaresUsecase) FindAccessToken(userId, accessToken string) (int, bool) {
+	roleId, ok := u.middlewaresRepository.FindAccessToken(userId, accessToken)
+	if !ok {
+		return 0, false
+	}
+	if err := u.middlewaresRepository.TouchOauth(userId, accessToken); err != nil {
+		log.Printf("touch oauth of %s failed: %v\n", userId, err)
 	}
+	return roleId, true
 }
 
-func (u *middlewaresUsecase) FindAccessToken(userId, accessToken string) bool {
-	return u.middlewaresRepository.FindAccessToken(userId, accessToken)
+// FindPermissions are the permissions of a role, cached for permissionsTTL
+func (u *middlewaresUsecase) FindPermissions(roleId int) (roles.Permissions, error) {
+	u.mu.Lock()
+	cached, ok := u.permissions[roleId]
+	u.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.permissions, nil
+	}
+
+	names, err := u.middlewaresRepository.FindRolePermissions(roleId)
+	if err != nil {
+		return nil, err
+	}
+	permissions := roles.NewPermissions(names)
+
+	u.mu.Lock()
+	u.permissions[roleId] = &cachedPermissions{
+		permissions: permissions,
+		expires:     time.Now().Add(permissionsTTL),
+	}
+	u.mu.Unlock()
+	return permissions, nil
 }
 
-func (u *middlewaresUsecase) FindRole() ([]*middlewares.Role, error) {
-	roles, err := u.middlewaresRepository.FindRole()
+// FindApiKey looks a key up by its hash and records that it was used
+func (u *middlewaresUsecase) FindApiKey(key string) (*middlewares.ApiKey, error) {
+	apiKey, err := u.middlewaresRepository.FindApiKey(auth.HashApiKey(key))
 	if err != nil {
 		return nil, err
 	}
-	return roles, nil
+	if err := u.middlewaresRepository.TouchApiKey(apiKey.Id); err != nil {
+		log.Printf("touch api key %s failed: %v\n", apiKey.Id, err)
+	}
+	return apiKey, nil
+}
+
+func (u *middlewaresUsecase) FindEmailVerified(userId string) (bool, error) {
+	verified, err := u.middlewaresRepository.FindEmailVerified(userId)
+	if err != nil {
+		return false, err
+	}
+	return verified, nil
+}
+
+func (u *middlewaresUsecase) FindTotpEnabled(userId string) (bool, error) {
+	enabled, err := u.middlewaresRepository.FindTotpEnabled(userId)
+	if err != nil {
+		return false, err
+	}
+	return enabled, nil
 }
@@ -5,19 +5,23 @@ import (
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/monitors"
+	"github.com/jetsadawwts/go-restapi/pkg/auth"
 )
 
 type IMonitorHandler interface {
 	HealthCheck(c *fiber.Ctx) error
+	Jwks(c *fiber.Ctx) error
 }
 
 type monitorHandler struct {
-	cfg config.IConfig
+	cfg    config.IConfig
+	keyset auth.IKeyset
 }
 
-func MonitorHandler(cfg config.IConfig) IMonitorHandler {
+func MonitorHandler(cfg config.IConfig, keyset auth.IKeyset) IMonitorHandler {
 	return &monitorHandler{
-		cfg: cfg,
+		cfg:    cfg,
+		keyset: keyset,
 	}
 }
 
@@ -27,4 +31,11 @@ func (h *monitorHandler) HealthCheck(c *fiber.Ctx) error {
 		Version: h.cfg.App().Version(),
 	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, res).Res()
-}
\ No newline at end of file
+}
+
+// Jwks is a plain JSON Web Key Set, the format verifiers expect, rather than
+// the response envelope of the api.
+func (h *monitorHandler) Jwks(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(h.keyset.Jwks())
+}
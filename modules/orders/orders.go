@@ -1,10 +1,53 @@
 package orders
 
 import (
+	"errors"
+
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 )
 
+const (
+	StatusWaiting   = "waiting"
+	StatusPaid      = "paid"
+	StatusShipping  = "shipping"
+	StatusCompleted = "completed"
+	StatusCanceled  = "canceled"
+)
+
+var ErrInvalidStatusTransition = errors.New("order status transition is not allowed")
+
+// statusTransitions is the order lifecycle: every status maps to the statuses an admin may move it to.
+var statusTransitions = map[string][]string{
+	StatusWaiting:   {StatusPaid, StatusCanceled},
+	StatusPaid:      {StatusShipping, StatusCanceled},
+	StatusShipping:  {StatusCompleted},
+	StatusCompleted: {},
+	StatusCanceled:  {},
+}
+
+// customerTransitions is the subset of the lifecycle a customer may trigger on their own order.
+var customerTransitions = map[string][]string{
+	StatusWaiting: {StatusCanceled},
+}
+
+func IsStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+func CanTransition(from, to string, isAdmin bool) bool {
+	transitions := customerTransitions
+	if isAdmin {
+		transitions = statusTransitions
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
 
 type OrderFilter struct {
 	Search string `query:"search"`
@@ -32,11 +75,24 @@ type TransferSlip struct {
 	Id        string `json:"id"`
 	FileName  string `json:"filename"`
 	Url       string `json:"url"`
+	SignedUrl string `json:"signed_url,omitempty"` //set on read, a private slip is only downloadable with it
 	CreatedAt string `json:"created_at"`
 }
 
 type ProductOrder struct {
-	Id      string            `db:"id" json:"id"`
-	Qty     int               `db:"qty" json:"qty"`
-	Product *products.Product `db:"product" json:"product"`
+	Id        string            `db:"id" json:"id"`
+	VariantId string            `db:"variant_id" json:"variant_id"`
+	Qty       int               `db:"qty" json:"qty"`
+	UnitPrice float64           `db:"unit_price" json:"unit_price"`
+	LineTotal float64           `db:"line_total" json:"line_total"`
+	Product   *products.Product `db:"product" json:"product"`
+}
+
+type OrderStatusHistory struct {
+	Id         string  `db:"id" json:"id"`
+	OrderId    string  `db:"order_id" json:"order_id"`
+	FromStatus *string `db:"from_status" json:"from_status"`
+	ToStatus   string  `db:"to_status" json:"to_status"`
+	ChangedBy  string  `db:"changed_by" json:"changed_by"`
+	CreatedAt  string  `db:"created_at" json:"created_at"`
 }
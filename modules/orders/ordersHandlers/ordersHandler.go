@@ -1,6 +1,7 @@
 package ordersHandlers
 
 import (
+	"errors"
 	"strings"
 	"time"
 
@@ -10,6 +11,8 @@ import (
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/orders"
 	"github.com/jetsadawwts/go-restapi/modules/orders/ordersUsecases"
+	"github.com/jetsadawwts/go-restapi/modules/products"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
 )
 
 type ordersHandlersErrCode string
@@ -19,6 +22,8 @@ const (
 	findOrderErr    ordersHandlersErrCode = "orders-002"
 	insertOrderErr  ordersHandlersErrCode = "orders-003"
 	updateOrderErr  ordersHandlersErrCode = "orders-004"
+	statusChangeErr ordersHandlersErrCode = "orders-005"
+	findHistoryErr  ordersHandlersErrCode = "orders-006"
 )
 
 type IOrdersHandler interface {
@@ -26,6 +31,7 @@ type IOrdersHandler interface {
 	FindOrder(c *fiber.Ctx) error
 	InsertOrder(c *fiber.Ctx) error
 	UpdateOrder(c *fiber.Ctx) error
+	FindOrderStatusHistory(c *fiber.Ctx) error
 }
 
 type ordersHandler struct {
@@ -82,7 +88,7 @@ func (h *ordersHandler) FindOrder(c *fiber.Ctx) error {
 		"created_at": `"o"."created_at"`,
 	}
 	if orderByMap[req.OrderBy] == "" {
-		req.OrderBy = orderByMap["id"]
+		req.OrderBy = "id"
 	}
 
 	req.Sort = strings.ToUpper(req.Sort)
@@ -118,6 +124,26 @@ func (h *ordersHandler) FindOrder(c *fiber.Ctx) error {
 		req.EndDate = end.Format("2006-01-02")
 	}
 
+	// Keyset pagination is opted into with the cursor parameter, empty for the first page
+	if c.Context().QueryArgs().Has("cursor") {
+		if err := req.ParseCursor(req.OrderBy, req.Sort); err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findOrderErr),
+				err.Error(),
+			).Res()
+		}
+		orders, err := h.ordersUseCase.FindOrderByCursor(req)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findOrderErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Success(fiber.StatusOK, orders).Res()
+	}
+
 	return entities.NewResponse(c).Success(
 		fiber.StatusOK,
 		h.ordersUseCase.FindOrder(req),
@@ -146,15 +172,23 @@ func (h *ordersHandler) InsertOrder(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	if c.Locals("userRoleId").(int) != 2 {
+	// Orders for somebody else need the permission
+	if !c.Locals("userPermissions").(roles.Permissions).Has(roles.PermOrdersWriteAll) {
 		req.UserId = userId
 	}
 
-	req.Status = "waiting"
+	req.Status = orders.StatusWaiting
 	req.TotalPaid = 0
 
 	order, err := h.ordersUseCase.InsertOrder(req)
 	if err != nil {
+		if errors.Is(err, products.ErrOutOfStock) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrConflict.Code,
+				string(insertOrderErr),
+				err.Error(),
+			).Res()
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(insertOrderErr),
@@ -182,17 +216,13 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 
 	req.Id = orderId
 
-	statusMap := map[string]string{
-		"waiting":   "waiting",
-		"shipping":  "shipping",
-		"completed": "completed",
-		"canceled":  "canceled",
-	}
-
-	if c.Locals("userRoleId").(int) == 2 {
-		req.Status = statusMap[strings.ToLower(req.Status)]
-	} else if strings.ToLower(req.Status) == statusMap["canceled"] {
-		req.Status = statusMap["canceled"]
+	req.Status = strings.ToLower(strings.Trim(req.Status, " "))
+	if req.Status != "" && !orders.IsStatus(req.Status) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(statusChangeErr),
+			"status is invalid",
+		).Res()
 	}
 
 	if req.TransferSlip != nil {
@@ -215,8 +245,19 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 		}
 	}
 
-	order, err := h.ordersUseCase.UpdateOrder(req)
+	order, err := h.ordersUseCase.UpdateOrder(
+		req,
+		c.Locals("userId").(string),
+		c.Locals("userPermissions").(roles.Permissions).Has(roles.PermOrdersWriteAll),
+	)
 	if err != nil {
+		if errors.Is(err, orders.ErrInvalidStatusTransition) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrConflict.Code,
+				string(statusChangeErr),
+				err.Error(),
+			).Res()
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(updateOrderErr),
@@ -229,3 +270,35 @@ func (h *ordersHandler) UpdateOrder(c *fiber.Ctx) error {
 		order,
 	).Res()
 }
+
+func (h *ordersHandler) FindOrderStatusHistory(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+	orderId := strings.Trim(c.Params("order_id"), " ")
+
+	order, err := h.ordersUseCase.FindOneOrder(orderId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(findHistoryErr),
+			err.Error(),
+		).Res()
+	}
+	if order.UserId != userId {
+		return entities.NewResponse(c).Error(
+			fiber.ErrNotFound.Code,
+			string(findHistoryErr),
+			"order not found",
+		).Res()
+	}
+
+	history, err := h.ordersUseCase.FindOrderStatusHistory(orderId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(findHistoryErr),
+			err.Error(),
+		).Res()
+	}
+
+	return entities.NewResponse(c).Success(fiber.StatusOK, history).Res()
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/orders"
 	"github.com/jmoiron/sqlx"
 )
@@ -18,6 +19,7 @@ type IFindOrderBuilder interface {
 	buildWhereSearch()
 	buildWhereStatus()
 	buildWhereDate()
+	buildCursor()
 	buildSort()
 	buildPaginate()
 	closeQuery()
@@ -27,6 +29,7 @@ type IFindOrderBuilder interface {
 	setValues(data []any)
 	setLastIndex(n int)
 	getDb() *sqlx.DB
+	cursor(order *orders.Order) *entities.Cursor
 	reset()
 }
 
@@ -46,6 +49,35 @@ func FindOrderBuilder(db *sqlx.DB, req *orders.OrderFilter) IFindOrderBuilder {
 	}
 }
 
+// orderByMap holds the sortable columns and the type a cursor value is compared as
+var orderByMap = map[string][2]string{
+	"id":         {`"o"."id"`, "VARCHAR"},
+	"created_at": {`"o"."created_at"`, "TIMESTAMP"},
+}
+
+// cursorTimeLayout is how a TIMESTAMP sort key reads in the json of a row
+const cursorTimeLayout = "2006-01-02T15:04:05.999999999"
+
+// CheckCursor refuses a cursor whose value is not of the type its order is
+// compared as, a tampered cursor would otherwise fail the cast in the query.
+func CheckCursor(req *orders.OrderFilter) error {
+	if req.PaginationReq == nil || req.Keyset == nil {
+		return nil
+	}
+
+	if value, ok := req.Keyset.Value.(string); ok {
+		switch orderByMap[req.OrderBy][1] {
+		case "VARCHAR":
+			return nil
+		case "TIMESTAMP":
+			if _, err := time.Parse(cursorTimeLayout, value); err == nil {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("cursor is invalid")
+}
+
 type findOrderEngineer struct {
 	builder IFindOrderBuilder
 }
@@ -70,7 +102,10 @@ func (b *findOrderBuilder) initQuery() {
 				FROM (
 					SELECT
 						"spo"."id",
+						"spo"."variant_id",
 						"spo"."qty",
+						"spo"."unit_price",
+						"spo"."line_total",
 						"spo"."product"
 					FROM "products_orders" "spo"
 					WHERE "spo"."order_id" = "o"."id"
@@ -78,12 +113,7 @@ func (b *findOrderBuilder) initQuery() {
 			) AS "products",
 			"o"."address",
 			"o"."contact",
-			(
-				SELECT
-					SUM(COALESCE(("po"."product"->>'price')::FLOAT*("po"."qty")::FLOAT, 0))
-				FROM "products_orders" "po"
-				WHERE "po"."order_id" = "o"."id"
-			) AS "total_paid",
+			"o"."total_paid",
 			"o"."created_at",
 			"o"."updated_at"
 		FROM "orders" "o"
@@ -165,24 +195,68 @@ func (b *findOrderBuilder) buildWhereDate() {
 	}
 }
 
-func (b *findOrderBuilder) buildSort() {
-	b.values = append(b.values, b.req.OrderBy)
+func (b *findOrderBuilder) buildCursor() {
+	if b.req.Keyset == nil {
+		return
+	}
 
-	// Fix
-	b.query += fmt.Sprintf(`
-		ORDER BY $%d %s`, b.lastIndex+1, b.req.Sort)
+	// Rows after the cursor in the reading direction, the id breaks ties
+	operator := ">"
+	if (b.req.Sort == "DESC") != b.req.Keyset.Prev {
+		operator = "<"
+	}
+
+	b.values = append(b.values, b.req.Keyset.Value, b.req.Keyset.Id)
+
+	query := fmt.Sprintf(`
+		AND (%s, "o"."id") %s (($%d)::%s, $%d)`,
+		orderByMap[b.req.OrderBy][0],
+		operator,
+		b.lastIndex+1,
+		orderByMap[b.req.OrderBy][1],
+		b.lastIndex+2,
+	)
+	temp := b.getQuery()
+	temp += query
+	b.setQuery(temp)
 
 	b.lastIndex = len(b.values)
 }
 
+func (b *findOrderBuilder) buildSort() {
+	sort := b.req.Sort
+
+	// Reading backwards from a cursor, the page is put back in order afterwards
+	if b.req.Keyset != nil && b.req.Keyset.Prev {
+		if sort == "ASC" {
+			sort = "DESC"
+		} else {
+			sort = "ASC"
+		}
+	}
+
+	b.query += fmt.Sprintf(`
+		ORDER BY %s %s, "o"."id" %s`, orderByMap[b.req.OrderBy][0], sort, sort)
+}
+
 func (b *findOrderBuilder) buildPaginate() {
+	// One extra row tells whether another page follows
+	if b.req.CursorMode {
+		b.values = append(b.values, b.req.Limit+1)
+
+		b.query += fmt.Sprintf(`
+		LIMIT $%d`, b.lastIndex+1)
+
+		b.lastIndex = len(b.values)
+		return
+	}
+
 	b.values = append(
 		b.values,
 		(b.req.Page-1)*b.req.Limit,
 		b.req.Limit,
 	)
 
-	// Fix
 	b.query += fmt.Sprintf(`
 		OFFSET $%d LIMIT $%d`, b.lastIndex+1, b.lastIndex+2)
 
@@ -206,6 +280,19 @@ func (b *findOrderBuilder) setLastIndex(n int) { b.lastIndex = n }
 
 func (b *findOrderBuilder) getDb() *sqlx.DB { return b.db }
 
+func (b *findOrderBuilder) cursor(order *orders.Order) *entities.Cursor {
+	var value any = order.Id
+	if b.req.OrderBy == "created_at" {
+		value = order.CreatedAt
+	}
+	return &entities.Cursor{
+		OrderBy: b.req.OrderBy,
+		Sort:    b.req.Sort,
+		Value:   value,
+		Id:      order.Id,
+	}
+}
+
 func (b *findOrderBuilder) reset() {
 	b.query = ""
 	b.values = make([]any, 0)
@@ -226,6 +313,10 @@ func (en *findOrderEngineer) FindOrder() []*orders.Order {
 
 	fmt.Println(en.builder.getQuery())
 
+	return en.result()
+}
+
+func (en *findOrderEngineer) result() []*orders.Order {
 	raw := make([]byte, 0)
 	if err := en.builder.getDb().Get(&raw, en.builder.getQuery(), en.builder.getValues()...); err != nil {
 		log.Printf("get orders failed: %v\n", err)
@@ -241,6 +332,28 @@ func (en *findOrderEngineer) FindOrder() []*orders.Order {
 	return ordersData
 }
 
+func (en *findOrderEngineer) FindOrderByCursor() ([]*orders.Order, []*entities.Cursor) {
+	_, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	en.builder.initQuery()
+	en.builder.buildWhereSearch()
+	en.builder.buildWhereStatus()
+	en.builder.buildWhereDate()
+	en.builder.buildCursor()
+	en.builder.buildSort()
+	en.builder.buildPaginate()
+	en.builder.closeQuery()
+
+	ordersData := en.result()
+
+	cursors := make([]*entities.Cursor, len(ordersData))
+	for i, order := range ordersData {
+		cursors[i] = en.builder.cursor(order)
+	}
+	return ordersData, cursors
+}
+
 func (en *findOrderEngineer) CountOrder() int {
 	_, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
@@ -0,0 +1,49 @@
+package ordersPatterns
+
+import (
+	"testing"
+
+	"github.com/jetsadawwts/go-restapi/modules/entities"
+	"github.com/jetsadawwts/go-restapi/modules/orders"
+)
+
+func TestCheckCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+		value   any
+		ok      bool
+	}{
+		{"id", "id", "O000001", true},
+		{"created_at", "created_at", "2024-01-02T03:04:05.123456", true},
+		{"created_at without fraction", "created_at", "2024-01-02T03:04:05", true},
+		{"created_at not a time", "created_at", "O000001", false},
+		{"created_at with a zone", "created_at", "2024-01-02T03:04:05Z", false},
+		// Numbers decode as float64 and no order column takes them
+		{"number", "id", float64(1), false},
+		{"null", "created_at", nil, false},
+		{"unknown column", "status", "paid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &orders.OrderFilter{
+				PaginationReq: &entities.PaginationReq{
+					Keyset: &entities.Cursor{OrderBy: tt.orderBy, Sort: "DESC", Value: tt.value, Id: "O000001"},
+				},
+				SortReq: &entities.SortReq{OrderBy: tt.orderBy, Sort: "DESC"},
+			}
+			if err := CheckCursor(req); (err == nil) != tt.ok {
+				t.Errorf("CheckCursor error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	first := &orders.OrderFilter{
+		PaginationReq: &entities.PaginationReq{},
+		SortReq:       &entities.SortReq{OrderBy: "id", Sort: "DESC"},
+	}
+	if err := CheckCursor(first); err != nil {
+		t.Errorf("CheckCursor without a cursor = %v, want nil", err)
+	}
+}
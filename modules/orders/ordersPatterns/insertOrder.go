@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/modules/files/filesPatterns"
 	"github.com/jetsadawwts/go-restapi/modules/orders"
+	"github.com/jetsadawwts/go-restapi/modules/products"
+	"github.com/jetsadawwts/go-restapi/modules/products/productsPatterns"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -13,6 +16,9 @@ type IInsertOrderBuilder interface {
 	initTransaction() error
 	insertOrder() error
 	insertProductsOrder() error
+	reserveStock() error
+	insertStatusHistory() error
+	attachFiles() error
 	getOrderId() string
 	commit() error
 }
@@ -59,10 +65,11 @@ func (b *insertOrderBuilder) insertOrder() error {
 			"contact",
 			"address",
 			"transfer_slip",
-			"status"
+			"status",
+			"total_paid"
 		)
 		VALUES
-		($1, $2, $3, $4, $5)
+		($1, $2, $3, $4, $5, $6)
 			RETURNING "id";
 	`
 	if err := b.tx.QueryRowxContext(
@@ -73,6 +80,7 @@ func (b *insertOrderBuilder) insertOrder() error {
 		b.req.Address,
 		b.req.TransferSlip,
 		b.req.Status,
+		b.req.TotalPaid,
 	).Scan(&b.req.Id); err != nil {
 		b.tx.Rollback()
 		return fmt.Errorf("insert order failed: %v", err)
@@ -87,7 +95,11 @@ func (b *insertOrderBuilder) insertProductsOrder() error {
 	query := `
 		INSERT INTO "products_orders" (
 			"order_id",
+			"product_id",
+			"variant_id",
 			"qty",
+			"unit_price",
+			"line_total",
 			"product"
 		)
 		VALUES	
@@ -96,22 +108,31 @@ func (b *insertOrderBuilder) insertProductsOrder() error {
 	values := make([]any, 0)
 	lastIndex := 0
 	for i := range b.req.Products {
+		var variantId any
+		if b.req.Products[i].VariantId != "" {
+			variantId = b.req.Products[i].VariantId
+		}
+
 		values = append(
 			values,
 			b.req.Id,
+			b.req.Products[i].Product.Id,
+			variantId,
 			b.req.Products[i].Qty,
+			b.req.Products[i].UnitPrice,
+			b.req.Products[i].LineTotal,
 			b.req.Products[i].Product,
 		)
 
 		if i != len(b.req.Products)-1 {
 			query += fmt.Sprintf(`
-				($%d, $%d, $%d),`, lastIndex+1, lastIndex+2, lastIndex+3)
+				($%d, $%d, $%d, $%d, $%d, $%d, $%d),`, lastIndex+1, lastIndex+2, lastIndex+3, lastIndex+4, lastIndex+5, lastIndex+6, lastIndex+7)
 		} else {
 			query += fmt.Sprintf(`
-				($%d, $%d, $%d);`, lastIndex+1, lastIndex+2, lastIndex+3)
+				($%d, $%d, $%d, $%d, $%d, $%d, $%d);`, lastIndex+1, lastIndex+2, lastIndex+3, lastIndex+4, lastIndex+5, lastIndex+6, lastIndex+7)
 		}
 
-		lastIndex += 3
+		lastIndex += 7
 	}
 
 	if _, err := b.tx.ExecContext(
@@ -125,6 +146,125 @@ func (b *insertOrderBuilder) insertProductsOrder() error {
 	return nil
 }
 
+func (b *insertOrderBuilder) reserveStock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// Sum the quantity per product or variant, the same item may be ordered on several lines
+	productQty := make(map[string]int)
+	productIds := make([]string, 0)
+	variantQty := make(map[string]int)
+	variantIds := make([]string, 0)
+	variantProduct := make(map[string]string)
+	for _, p := range b.req.Products {
+		if p.VariantId != "" {
+			if _, ok := variantQty[p.VariantId]; !ok {
+				variantIds = append(variantIds, p.VariantId)
+			}
+			variantQty[p.VariantId] += p.Qty
+			variantProduct[p.VariantId] = p.Product.Id
+			continue
+		}
+		if _, ok := productQty[p.Product.Id]; !ok {
+			productIds = append(productIds, p.Product.Id)
+		}
+		productQty[p.Product.Id] += p.Qty
+	}
+
+	productStock, err := productsPatterns.LockProductsStock(ctx, b.tx, productIds)
+	if err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	variantStock, err := productsPatterns.LockVariantsStock(ctx, b.tx, variantIds)
+	if err != nil {
+		b.tx.Rollback()
+		return err
+	}
+
+	for _, id := range productIds {
+		if productStock[id] < productQty[id] {
+			b.tx.Rollback()
+			return fmt.Errorf("%w: %s", products.ErrOutOfStock, id)
+		}
+
+		if err := productsPatterns.ChangeStock(ctx, b.tx, &products.StockMovement{
+			ProductId: id,
+			QtyChange: -productQty[id],
+			Reason:    productsPatterns.StockReasonOrderReserved,
+			OrderId:   &b.req.Id,
+			CreatedBy: &b.req.UserId,
+		}); err != nil {
+			b.tx.Rollback()
+			return err
+		}
+	}
+
+	for _, id := range variantIds {
+		variantId := id
+		if variantStock[id] < variantQty[id] {
+			b.tx.Rollback()
+			return fmt.Errorf("%w: %s (%s)", products.ErrOutOfStock, variantProduct[id], id)
+		}
+
+		if err := productsPatterns.ChangeStock(ctx, b.tx, &products.StockMovement{
+			ProductId: variantProduct[id],
+			VariantId: &variantId,
+			QtyChange: -variantQty[id],
+			Reason:    productsPatterns.StockReasonOrderReserved,
+			OrderId:   &b.req.Id,
+			CreatedBy: &b.req.UserId,
+		}); err != nil {
+			b.tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *insertOrderBuilder) insertStatusHistory() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	query := `
+		INSERT INTO "order_status_history" (
+			"order_id",
+			"from_status",
+			"to_status",
+			"changed_by"
+		)
+		VALUES
+		($1, NULL, $2, $3);
+	`
+
+	if _, err := b.tx.ExecContext(
+		ctx,
+		query,
+		b.req.Id,
+		b.req.Status,
+		b.req.UserId,
+	); err != nil {
+		b.tx.Rollback()
+		return fmt.Errorf("insert order_status_history failed: %v", err)
+	}
+	return nil
+}
+
+func (b *insertOrderBuilder) attachFiles() error {
+	if b.req.TransferSlip == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if err := filesPatterns.AttachOrderFiles(ctx, b.tx, b.req.Id); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return nil
+}
+
 func (b *insertOrderBuilder) getOrderId() string {
 	return b.req.Id
 }
@@ -146,6 +286,15 @@ func (en *insertOrderEngineer) InsertOrder() (string, error) {
 	if err := en.builder.insertProductsOrder(); err != nil {
 		return "", err
 	}
+	if err := en.builder.reserveStock(); err != nil {
+		return "", err
+	}
+	if err := en.builder.insertStatusHistory(); err != nil {
+		return "", err
+	}
+	if err := en.builder.attachFiles(); err != nil {
+		return "", err
+	}
 	if err := en.builder.commit(); err != nil {
 		return "", err
 	}
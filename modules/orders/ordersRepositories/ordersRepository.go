@@ -7,16 +7,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/modules/entities"
+	"github.com/jetsadawwts/go-restapi/modules/files/filesPatterns"
 	"github.com/jetsadawwts/go-restapi/modules/orders"
 	"github.com/jetsadawwts/go-restapi/modules/orders/ordersPatterns"
+	"github.com/jetsadawwts/go-restapi/modules/products"
+	"github.com/jetsadawwts/go-restapi/modules/products/productsPatterns"
 	"github.com/jmoiron/sqlx"
 )
 
 type IOrdersRepository interface {
 	FindOneOrder(orderId string) (*orders.Order, error)
 	FindOrder(req *orders.OrderFilter) ([]*orders.Order, int)
+	FindOrderByCursor(req *orders.OrderFilter) ([]*orders.Order, string, string, error)
 	InsertOrder(req *orders.Order) (string, error)
-	UpdateOrder(req *orders.Order) error
+	UpdateOrder(req *orders.Order, history *orders.OrderStatusHistory) error
+	FindOrderStatusHistory(orderId string) ([]*orders.OrderStatusHistory, error)
 }
 
 type ordersRepository struct {
@@ -42,7 +48,10 @@ func (r *ordersRepository) FindOneOrder(orderId string) (*orders.Order, error) {
 					FROM (
 						SELECT
 							"spo"."id",
+							"spo"."variant_id",
 							"spo"."qty",
+							"spo"."unit_price",
+							"spo"."line_total",
 							"spo"."product" 
 						FROM "products_orders" "spo"
 						WHERE "spo"."order_id" = "o"."id"
@@ -51,12 +60,7 @@ func (r *ordersRepository) FindOneOrder(orderId string) (*orders.Order, error) {
 				"o"."address",
 				"o"."contact",
 				"o"."status",
-				(
-					SELECT 
-						SUM(COALESCE(("po"."product" ->> 'price')::FLOAT*("po"."qty")::FLOAT, 0))
-					FROM "products_orders" "po"
-					WHERE "po"."order_id" = "o"."id"
-				) AS "total_paid",
+				"o"."total_paid",
 				"o"."created_at",
 				"o"."updated_at"
 			FROM "orders" "o"
@@ -86,6 +90,19 @@ func (r *ordersRepository) FindOrder(req *orders.OrderFilter) ([]*orders.Order,
 	return engineer.FindOrder(), engineer.CountOrder()
 }
 
+func (r *ordersRepository) FindOrderByCursor(req *orders.OrderFilter) ([]*orders.Order, string, string, error) {
+	if err := ordersPatterns.CheckCursor(req); err != nil {
+		return nil, "", "", err
+	}
+
+	builder := ordersPatterns.FindOrderBuilder(r.db, req)
+	engineer := ordersPatterns.FindOrderEngineer(builder)
+
+	result, cursors := engineer.FindOrderByCursor()
+	page, next, prev := entities.KeysetPage(req.PaginationReq, result, cursors)
+	return page, next, prev, nil
+}
+
 func (r *ordersRepository) InsertOrder(req *orders.Order) (string, error) {
 	builder := ordersPatterns.InsertOrderBuilder(r.db, req)
 	orderId, err := ordersPatterns.InsertOrderEngineer(builder).InsertOrder()
@@ -95,7 +112,7 @@ func (r *ordersRepository) InsertOrder(req *orders.Order) (string, error) {
 	return orderId, nil
 }
 
-func (r *ordersRepository) UpdateOrder(req *orders.Order) error {
+func (r *ordersRepository) UpdateOrder(req *orders.Order, history *orders.OrderStatusHistory) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
@@ -125,10 +142,24 @@ func (r *ordersRepository) UpdateOrder(req *orders.Order) error {
 		lastIndex++
 	}
 
+	if len(queryWhereStack) == 0 {
+		return nil
+	}
+
 	values = append(values, req.Id)
 
 	queryClose := fmt.Sprintf(`
-		WHERE "id" = $%d;`, lastIndex)
+		WHERE "id" = $%d`, lastIndex)
+
+	// Guard against a concurrent transition from the same status
+	if history != nil && history.FromStatus != nil {
+		lastIndex++
+		values = append(values, *history.FromStatus)
+
+		queryClose += fmt.Sprintf(`
+		AND "status" = $%d`, lastIndex)
+	}
+	queryClose += ";"
 
 	for i := range queryWhereStack {
 		if i != len(queryWhereStack)-1 {
@@ -139,13 +170,154 @@ func (r *ordersRepository) UpdateOrder(req *orders.Order) error {
 	}
 	query += queryClose
 
-	if _, err := r.db.ExecContext(
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(
 		ctx,
 		query,
 		values...,
-	); err != nil {
+	)
+	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("update order failed: %v", err)
 	}
 
+	if history != nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			tx.Rollback()
+			return fmt.Errorf("%w: order status has been changed by someone else", orders.ErrInvalidStatusTransition)
+		}
+
+		queryHistory := `
+		INSERT INTO "order_status_history" (
+			"order_id",
+			"from_status",
+			"to_status",
+			"changed_by"
+		)
+		VALUES
+		($1, $2, $3, $4);`
+
+		if _, err := tx.ExecContext(
+			ctx,
+			queryHistory,
+			req.Id,
+			history.FromStatus,
+			history.ToStatus,
+			history.ChangedBy,
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert order_status_history failed: %v", err)
+		}
+
+		if history.ToStatus == orders.StatusCanceled {
+			if err := r.releaseStock(ctx, tx, req.Id, history.ChangedBy); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if req.TransferSlip != nil {
+		if err := filesPatterns.AttachOrderFiles(ctx, tx, req.Id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// releaseStock puts the reserved quantity of a canceled order back on the shelf
+func (r *ordersRepository) releaseStock(ctx context.Context, tx *sqlx.Tx, orderId, changedBy string) error {
+	query := `
+	SELECT
+		"product_id",
+		COALESCE("variant_id"::TEXT, '') AS "variant_id",
+		SUM("qty") AS "qty"
+	FROM "products_orders"
+	WHERE "order_id" = $1
+	AND "product_id" IS NOT NULL
+	AND "reserved"
+	GROUP BY "product_id", "variant_id";`
+
+	type reserved struct {
+		ProductId string `db:"product_id"`
+		VariantId string `db:"variant_id"`
+		Qty       int    `db:"qty"`
+	}
+
+	lines := make([]*reserved, 0)
+	if err := tx.SelectContext(ctx, &lines, query, orderId); err != nil {
+		return fmt.Errorf("get products_orders failed: %v", err)
+	}
+
+	productIds := make([]string, 0)
+	variantIds := make([]string, 0)
+	for _, l := range lines {
+		if l.VariantId != "" {
+			variantIds = append(variantIds, l.VariantId)
+		} else {
+			productIds = append(productIds, l.ProductId)
+		}
+	}
+
+	// Products and variants deleted since the order was placed have nothing to release
+	productStock, err := productsPatterns.LockProductsStock(ctx, tx, productIds)
+	if err != nil {
+		return err
+	}
+	variantStock, err := productsPatterns.LockVariantsStock(ctx, tx, variantIds)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		movement := &products.StockMovement{
+			ProductId: l.ProductId,
+			QtyChange: l.Qty,
+			Reason:    productsPatterns.StockReasonOrderCanceled,
+			OrderId:   &orderId,
+			CreatedBy: &changedBy,
+		}
+		if l.VariantId != "" {
+			if _, ok := variantStock[l.VariantId]; !ok {
+				continue
+			}
+			movement.VariantId = &l.VariantId
+		} else if _, ok := productStock[l.ProductId]; !ok {
+			continue
+		}
+
+		if err := productsPatterns.ChangeStock(ctx, tx, movement); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (r *ordersRepository) FindOrderStatusHistory(orderId string) ([]*orders.OrderStatusHistory, error) {
+	query := `
+	SELECT
+		"id",
+		"order_id",
+		"from_status",
+		"to_status",
+		"changed_by",
+		"created_at"
+	FROM "order_status_history"
+	WHERE "order_id" = $1
+	ORDER BY "created_at" ASC;`
+
+	history := make([]*orders.OrderStatusHistory, 0)
+	if err := r.db.Select(&history, query, orderId); err != nil {
+		return nil, fmt.Errorf("get order status history failed: %v", err)
+	}
+	return history, nil
+}
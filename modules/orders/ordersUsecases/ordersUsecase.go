@@ -7,25 +7,43 @@ import (
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/orders"
 	"github.com/jetsadawwts/go-restapi/modules/orders/ordersRepositories"
+	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jetsadawwts/go-restapi/modules/products/productsRepositories"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
 )
 
 type IOrdersUsecase interface {
 	FindOneOrder(orderId string) (*orders.Order, error)
 	FindOrder(req *orders.OrderFilter) *entities.PaginateRes
+	FindOrderByCursor(req *orders.OrderFilter) (*entities.CursorPaginateRes, error)
 	InsertOrder(req *orders.Order) (*orders.Order, error)
-	UpdateOrder(req *orders.Order) (*orders.Order, error)
+	UpdateOrder(req *orders.Order, changedBy string, isAdmin bool) (*orders.Order, error)
+	FindOrderStatusHistory(orderId string) ([]*orders.OrderStatusHistory, error)
 }
 
 type ordersUsecase struct {
 	ordersRepository   ordersRepositories.IOrdersRepository
 	productsRepository productsRepositories.IProductsRepository
+	signer             storage.ISigner
 }
 
-func OrdersUsecase(ordersRepository ordersRepositories.IOrdersRepository, productsRepository productsRepositories.IProductsRepository) IOrdersUsecase {
+func OrdersUsecase(ordersRepository ordersRepositories.IOrdersRepository, productsRepository productsRepositories.IProductsRepository, signer storage.ISigner) IOrdersUsecase {
 	return &ordersUsecase{
 		ordersRepository:   ordersRepository,
 		productsRepository: productsRepository,
+		signer:             signer,
+	}
+}
+
+// signSlips hands out fresh download urls for the private transfer slips
+func (u *ordersUsecase) signSlips(data ...*orders.Order) {
+	for _, o := range data {
+		if o.TransferSlip == nil || o.TransferSlip.Url == "" {
+			continue
+		}
+		if signed := u.signer.Sign(o.TransferSlip.Url); signed != o.TransferSlip.Url {
+			o.TransferSlip.SignedUrl = signed
+		}
 	}
 }
 
@@ -34,12 +52,14 @@ func (u *ordersUsecase) FindOneOrder(orderId string) (*orders.Order, error) {
 	if err != nil {
 		return nil, err
 	}
+	u.signSlips(order)
 
 	return order, nil
 }
 
 func (u *ordersUsecase) FindOrder(req *orders.OrderFilter) *entities.PaginateRes {
 	orders, count := u.ordersRepository.FindOrder(req)
+	u.signSlips(orders...)
 	return &entities.PaginateRes{
 		Data:      orders,
 		Page:      req.Page,
@@ -49,21 +69,55 @@ func (u *ordersUsecase) FindOrder(req *orders.OrderFilter) *entities.PaginateRes
 	}
 }
 
+func (u *ordersUsecase) FindOrderByCursor(req *orders.OrderFilter) (*entities.CursorPaginateRes, error) {
+	orders, next, prev, err := u.ordersRepository.FindOrderByCursor(req)
+	if err != nil {
+		return nil, err
+	}
+	u.signSlips(orders...)
+	return &entities.CursorPaginateRes{
+		Data:       orders,
+		Limit:      req.Limit,
+		NextCursor: next,
+		PrevCursor: prev,
+	}, nil
+}
+
 func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 	//Check if products is exists
+	req.TotalPaid = 0
 	for i := range req.Products {
 		if req.Products[i].Product == nil {
 			return nil, fmt.Errorf("product is nil")
 		}
+		if req.Products[i].Qty < 1 {
+			return nil, fmt.Errorf("qty of product %s is invalid", req.Products[i].Product.Id)
+		}
 
 		prod, err := u.productsRepository.FindOneProduct(req.Products[i].Product.Id)
 		if err != nil {
 			return nil, err
 		}
 
-		//Set price
-		req.TotalPaid += req.Products[i].Product.Price * float64(req.Products[i].Qty)
+		//Pick the ordered variant, the snapshot keeps only that one
+		var variant *products.Variant
+		if len(prod.Variants) > 0 || req.Products[i].VariantId != "" {
+			variant = prod.FindVariant(req.Products[i].VariantId)
+			if variant == nil {
+				return nil, fmt.Errorf("variant of product %s is invalid", prod.Id)
+			}
+			prod.Variants = []*products.Variant{variant}
+		}
+
+		//Set price from the catalog, never from the request
 		req.Products[i].Product = prod
+		req.Products[i].UnitPrice = prod.UnitPrice(variant)
+		req.Products[i].LineTotal = roundPrice(req.Products[i].UnitPrice * float64(req.Products[i].Qty))
+		req.TotalPaid += req.Products[i].LineTotal
+	}
+	req.TotalPaid = roundPrice(req.TotalPaid)
+	if req.TransferSlip != nil {
+		req.TransferSlip.SignedUrl = ""
 	}
 
 	orderId, err := u.ordersRepository.InsertOrder(req)
@@ -71,7 +125,7 @@ func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 		return nil, err
 	}
 
-	order, err := u.ordersRepository.FindOneOrder(orderId)
+	order, err := u.FindOneOrder(orderId)
 	if err != nil {
 		return nil, err
 	}
@@ -79,16 +133,54 @@ func (u *ordersUsecase) InsertOrder(req *orders.Order) (*orders.Order, error) {
 	return order, nil
 }
 
-func (u *ordersUsecase) UpdateOrder(req *orders.Order) (*orders.Order, error) {
-	if err := u.ordersRepository.UpdateOrder(req); err != nil {
+func (u *ordersUsecase) UpdateOrder(req *orders.Order, changedBy string, isAdmin bool) (*orders.Order, error) {
+	current, err := u.ordersRepository.FindOneOrder(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin && current.UserId != changedBy {
+		return nil, fmt.Errorf("order not found")
+	}
+
+	var history *orders.OrderStatusHistory
+	if req.Status == current.Status {
+		req.Status = ""
+	}
+	if req.Status != "" {
+		if !orders.CanTransition(current.Status, req.Status, isAdmin) {
+			return nil, fmt.Errorf("%w: %s -> %s", orders.ErrInvalidStatusTransition, current.Status, req.Status)
+		}
+		history = &orders.OrderStatusHistory{
+			OrderId:    req.Id,
+			FromStatus: &current.Status,
+			ToStatus:   req.Status,
+			ChangedBy:  changedBy,
+		}
+	}
+
+	if req.TransferSlip != nil {
+		req.TransferSlip.SignedUrl = ""
+	}
+	if err := u.ordersRepository.UpdateOrder(req, history); err != nil {
 		return nil, err
 	}
 
-	order, err := u.ordersRepository.FindOneOrder(req.Id)
+	order, err := u.FindOneOrder(req.Id)
 	if err != nil {
 		return nil, err
 	}
 
 	return order, nil
+}
+
+func (u *ordersUsecase) FindOrderStatusHistory(orderId string) ([]*orders.OrderStatusHistory, error) {
+	history, err := u.ordersRepository.FindOrderStatusHistory(orderId)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
 
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
 }
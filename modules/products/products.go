@@ -1,10 +1,19 @@
 package products
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 )
 
+var (
+	ErrOutOfStock      = errors.New("product is out of stock")
+	ErrProductNotFound = errors.New("product not found")
+)
+
 type Product struct {
 	Id          string            `json:"id"`
 	Title       string            `json:"title"`
@@ -13,12 +22,129 @@ type Product struct {
 	CreateAt    string            `json:"created_at"`
 	UpdateAt    string            `json:"update_at"`
 	Price       float64           `json:"price"`
+	Stock       int               `json:"stock"`
 	Images      []*entities.Image `json:"images"`
+	Options     []string          `json:"options"`
+	Variants    []*Variant        `json:"variants"`
+	Highlight   string            `json:"highlight,omitempty"` //search snippet
+}
+
+type Variant struct {
+	Id      string            `json:"id"`
+	Sku     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   *float64          `json:"price"` //nil uses the product price
+	Stock   int               `json:"stock"`
+	Images  []*entities.Image `json:"images"`
+}
+
+func (p *Product) FindVariant(variantId string) *Variant {
+	for _, v := range p.Variants {
+		if v.Id == variantId {
+			return v
+		}
+	}
+	return nil
+}
+
+// ValidateVariants checks the variants of a create or update request, option names
+// are only checked against the product when the request carries them.
+func (p *Product) ValidateVariants() error {
+	optionMap := make(map[string]bool)
+	for _, o := range p.Options {
+		optionMap[o] = true
+	}
+
+	skuMap := make(map[string]bool)
+	for _, v := range p.Variants {
+		v.Sku = strings.Trim(v.Sku, " ")
+		if v.Sku == "" {
+			return fmt.Errorf("variant sku is required")
+		}
+		if skuMap[v.Sku] {
+			return fmt.Errorf("variant sku %s is duplicated", v.Sku)
+		}
+		skuMap[v.Sku] = true
+
+		if v.Price != nil && *v.Price < 0 {
+			return fmt.Errorf("variant %s price is invalid", v.Sku)
+		}
+		if v.Stock < 0 {
+			return fmt.Errorf("variant %s stock is invalid", v.Sku)
+		}
+		if v.Options == nil {
+			v.Options = make(map[string]string)
+		}
+		if p.Options != nil {
+			for k := range v.Options {
+				if !optionMap[k] {
+					return fmt.Errorf("variant %s option %s is not an option of the product", v.Sku, k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// UnitPrice is the price of one item of the product or of the given variant.
+func (p *Product) UnitPrice(variant *Variant) float64 {
+	if variant != nil && variant.Price != nil {
+		return *variant.Price
+	}
+	return p.Price
 }
 
 type ProductFilter struct {
-	Id string `query:"id"`
-	Search string `query:"search"` //title & description
+	Id          string  `query:"id"`
+	Search      string  `query:"search"`      //title & description, words match by prefix, "quoted phrase", -exclude
+	CategoryIds []int   `query:"category_id"` //?category_id=1&category_id=2 or ?category_id=1,2
+	MinPrice    float64 `query:"min_price"`
+	MaxPrice    float64 `query:"max_price"`
+	InStock     bool    `query:"in_stock"`
+	StartDate   string  `query:"start_date"` //YYYY-MM-DD
+	EndDate     string  `query:"end_date"`   //YYYY-MM-DD
 	*entities.PaginationReq
 	*entities.SortReq
 }
+
+type ProductFacets struct {
+	Categories []*CategoryFacet `json:"categories"`
+	Prices     []*PriceFacet    `json:"prices"`
+}
+
+type CategoryFacet struct {
+	Id    int    `db:"id" json:"id"`
+	Title string `db:"title" json:"title"`
+	Count int    `db:"count" json:"count"`
+}
+
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"` //nil is unbounded
+	Count int      `json:"count"`
+}
+
+type StockAdjustReq struct {
+	ProductId string `json:"-"`
+	VariantId string `json:"variant_id" form:"variant_id"`
+	QtyChange int    `json:"qty_change" form:"qty_change"`
+	Reason    string `json:"reason" form:"reason"`
+	CreatedBy string `json:"-"`
+}
+
+type StockMovement struct {
+	Id         string  `db:"id" json:"id"`
+	ProductId  string  `db:"product_id" json:"product_id"`
+	VariantId  *string `db:"variant_id" json:"variant_id"`
+	QtyChange  int     `db:"qty_change" json:"qty_change"`
+	StockAfter int     `db:"stock_after" json:"stock_after"`
+	Reason     string  `db:"reason" json:"reason"`
+	OrderId    *string `db:"order_id" json:"order_id"`
+	CreatedBy  *string `db:"created_by" json:"created_by"`
+	CreatedAt  string  `db:"created_at" json:"created_at"`
+}
+
+type StockMovementFilter struct {
+	ProductId string `query:"-"`
+	*entities.PaginationReq
+}
@@ -1,15 +1,14 @@
 package productsHandlers
 
 import (
-	"fmt"
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
-	"github.com/jetsadawwts/go-restapi/modules/files"
-	"github.com/jetsadawwts/go-restapi/modules/files/filesUsecases"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jetsadawwts/go-restapi/modules/products/productsUsecases"
 )
@@ -22,6 +21,8 @@ const (
 	insertProductErr  productsHandlersErrCode = "products-003"
 	updateProductErr  productsHandlersErrCode = "products-004"
 	deleteProductErr  productsHandlersErrCode = "products-005"
+	adjustStockErr    productsHandlersErrCode = "products-006"
+	findStockErr      productsHandlersErrCode = "products-007"
 )
 
 type IProductsHandler interface {
@@ -30,19 +31,19 @@ type IProductsHandler interface {
 	AddProduct(c *fiber.Ctx) error
 	UpdateProduct(c *fiber.Ctx) error
 	DeleteProduct(c *fiber.Ctx) error
+	AdjustStock(c *fiber.Ctx) error
+	FindStockMovement(c *fiber.Ctx) error
 }
 
 type productsHandler struct {
 	cfg             config.IConfig
 	productsUsecase productsUsecases.IProductsUsecase
-	filesUsecase    filesUsecases.IFilesUsecase
 }
 
-func ProductsHandler(cfg config.IConfig, productsUsecase productsUsecases.IProductsUsecase, filesUsecase filesUsecases.IFilesUsecase) IProductsHandler {
+func ProductsHandler(cfg config.IConfig, productsUsecase productsUsecases.IProductsUsecase) IProductsHandler {
 	return &productsHandler{
 		cfg:             cfg,
 		productsUsecase: productsUsecase,
-		filesUsecase:    filesUsecase,
 	}
 }
 
@@ -83,13 +84,76 @@ func (h *productsHandler) FindProduct(c *fiber.Ctx) error {
 		req.Limit = 5
 	}
 
-	if req.OrderBy == "" {
+	// Filter
+	if req.MinPrice < 0 || req.MaxPrice < 0 || (req.MaxPrice > 0 && req.MinPrice > req.MaxPrice) {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(findProductErr),
+			"price range is invalid",
+		).Res()
+	}
+	for _, id := range req.CategoryIds {
+		if id <= 0 {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findProductErr),
+				"category id is invalid",
+			).Res()
+		}
+	}
+
+	// Date	YYYY-MM-DD
+	if req.StartDate != "" {
+		start, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findProductErr),
+				"start date is invalid",
+			).Res()
+		}
+		req.StartDate = start.Format("2006-01-02")
+	}
+	if req.EndDate != "" {
+		end, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findProductErr),
+				"end date is invalid",
+			).Res()
+		}
+		req.EndDate = end.Format("2006-01-02")
+	}
+
+	// A search without an explicit order is ranked by relevance
+	if req.OrderBy == "" && req.Search == "" {
 		req.OrderBy = "title"
 	}
-	if req.Sort == "" {
+	if req.Sort == "" && req.Search == "" {
 		req.Sort = "ASC"
 	}
 
+	// Keyset pagination is opted into with the cursor parameter, empty for the first page
+	if c.Context().QueryArgs().Has("cursor") {
+		if err := req.ParseCursor(req.OrderBy, req.Sort); err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findProductErr),
+				err.Error(),
+			).Res()
+		}
+		products, err := h.productsUsecase.FindProductByCursor(req)
+		if err != nil {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(findProductErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Success(fiber.StatusOK, products).Res()
+	}
+
 	products := h.productsUsecase.FindProduct(req)
 	return entities.NewResponse(c).Success(fiber.StatusOK, products).Res()
 }
@@ -116,6 +180,22 @@ func (h *productsHandler) AddProduct(c *fiber.Ctx) error {
 		).Res()
 	}
 
+	if req.Stock < 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(insertProductErr),
+			"stock is invalid.",
+		).Res()
+	}
+
+	if err := req.ValidateVariants(); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(insertProductErr),
+			err.Error(),
+		).Res()
+	}
+
 	product, err := h.productsUsecase.AddProduct(req)
 	if err != nil {
 		return entities.NewResponse(c).Error(
@@ -146,6 +226,14 @@ func (h *productsHandler) UpdateProduct(c *fiber.Ctx) error {
 
 	req.Id = productId
 
+	if err := req.ValidateVariants(); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(updateProductErr),
+			err.Error(),
+		).Res()
+	}
+
 	product, err := h.productsUsecase.UpdateProduct(req)
 	if err != nil {
 		return entities.NewResponse(c).Error(
@@ -161,8 +249,15 @@ func (h *productsHandler) UpdateProduct(c *fiber.Ctx) error {
 func (h *productsHandler) DeleteProduct(c *fiber.Ctx) error {
 	productId := strings.Trim(c.Params("product_id"), " ")
 
-	product, err := h.productsUsecase.FindOneProduct(productId)
-	if err != nil {
+	if _, err := h.productsUsecase.FindOneProduct(productId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(deleteProductErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.productsUsecase.DeleteProduct(productId); err != nil {
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
 			string(deleteProductErr),
@@ -170,30 +265,87 @@ func (h *productsHandler) DeleteProduct(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	deleteFileReq := make([]*files.DeleteFileReq, 0)
+	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+
+}
 
-	for _, p := range product.Images {
-		deleteFileReq = append(deleteFileReq, &files.DeleteFileReq{
-			Destination: fmt.Sprintf("images/products/%s", p.FileName),
-		})
+func (h *productsHandler) AdjustStock(c *fiber.Ctx) error {
+	req := new(products.StockAdjustReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(adjustStockErr),
+			err.Error(),
+		).Res()
 	}
 
-	if err := h.filesUsecase.DeleteFileOnGCP(deleteFileReq); err != nil {
+	req.ProductId = strings.Trim(c.Params("product_id"), " ")
+	req.Reason = strings.Trim(req.Reason, " ")
+	req.CreatedBy = c.Locals("userId").(string)
+
+	if req.QtyChange == 0 {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(adjustStockErr),
+			"qty change is invalid.",
+		).Res()
+	}
+	if req.Reason == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(adjustStockErr),
+			"reason is required.",
+		).Res()
+	}
+
+	movement, err := h.productsUsecase.AdjustStock(req)
+	if err != nil {
+		if errors.Is(err, products.ErrProductNotFound) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(adjustStockErr),
+				err.Error(),
+			).Res()
+		}
+		if errors.Is(err, products.ErrOutOfStock) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrConflict.Code,
+				string(adjustStockErr),
+				err.Error(),
+			).Res()
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrInternalServerError.Code,
-			string(deleteProductErr),
+			string(adjustStockErr),
 			err.Error(),
 		).Res()
 	}
 
-	if err := h.productsUsecase.DeleteProduct(productId); err != nil {
+	return entities.NewResponse(c).Success(fiber.StatusOK, movement).Res()
+}
+
+func (h *productsHandler) FindStockMovement(c *fiber.Ctx) error {
+	req := &products.StockMovementFilter{
+		PaginationReq: &entities.PaginationReq{},
+	}
+	if err := c.QueryParser(req); err != nil {
 		return entities.NewResponse(c).Error(
-			fiber.ErrInternalServerError.Code,
-			string(deleteProductErr),
+			fiber.ErrBadRequest.Code,
+			string(findStockErr),
 			err.Error(),
 		).Res()
 	}
 
-	return entities.NewResponse(c).Success(fiber.StatusNoContent, nil).Res()
+	req.ProductId = strings.Trim(c.Params("product_id"), " ")
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 5 {
+		req.Limit = 5
+	}
 
+	return entities.NewResponse(c).Success(
+		fiber.StatusOK,
+		h.productsUsecase.FindStockMovement(req),
+	).Res()
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jetsadawwts/go-restapi/pkg/utils"
 	"github.com/jmoiron/sqlx"
@@ -19,12 +20,18 @@ type IFindProductBuilder interface {
 	initQuery()
 	countQuery()
 	whereQuery()
+	cursorQuery()
+	facetCategoryQuery()
+	facetPriceQuery()
 	sort()
 	paginate()
 	closeJsonQuery()
 	resetQuery()
 	Result() []*products.Product
+	CursorResult() ([]*products.Product, []*entities.Cursor)
 	Count() int
+	CategoryFacets() []*products.CategoryFacet
+	PriceFacets() []*products.PriceFacet
 	PrintQuery()
 }
 
@@ -34,15 +41,69 @@ type findProductBuilder struct {
 	query          string
 	lastStackIndex int
 	values         []any
+	tsQuery        string
+	tsQueryIndex   int
 }
 
 func FindProductBuilder(db *sqlx.DB, req *products.ProductFilter) IFindProductBuilder {
 	return &findProductBuilder{
-		db:  db,
-		req: req,
+		db:      db,
+		req:     req,
+		tsQuery: BuildTsQuery(req.Search),
 	}
 }
 
+// priceBuckets are the lower bounds of the price facet buckets, the last one is open ended
+var priceBuckets = []float64{0, 500, 1000, 5000, 10000}
+
+// fromPriceQuery is the lowest price a product sells for, its own price or the cheapest variant
+const fromPriceQuery = `COALESCE((
+			SELECT
+				MIN(COALESCE("v"."price", "p"."price"))
+			FROM "product_variants" "v"
+			WHERE "v"."product_id" = "p"."id"
+		), "p"."price")`
+
+// cursorCasts are the types a cursor value is compared as for each order
+var cursorCasts = map[string]string{
+	"id":        "VARCHAR",
+	"title":     "VARCHAR",
+	"price":     "FLOAT",
+	"relevance": "REAL",
+}
+
+// CheckCursor refuses a cursor whose value is not of the type its order is
+// compared as, a tampered cursor would otherwise fail the cast in the query.
+func CheckCursor(req *products.ProductFilter) error {
+	if req.PaginationReq == nil || req.Keyset == nil {
+		return nil
+	}
+
+	b := &findProductBuilder{req: req, tsQuery: BuildTsQuery(req.Search)}
+	orderBy, _ := b.sortKey()
+	switch req.Keyset.Value.(type) {
+	case string:
+		if cursorCasts[orderBy] == "VARCHAR" {
+			return nil
+		}
+	case float64:
+		if cursorCasts[orderBy] != "VARCHAR" {
+			return nil
+		}
+	}
+	return fmt.Errorf("cursor is invalid")
+}
+
+// tsQueryParam binds the search query once and returns its placeholder, so the
+// select list, the where clause and the sort share the same parameter.
+func (b *findProductBuilder) tsQueryParam() string {
+	if b.tsQueryIndex == 0 {
+		b.values = append(b.values, b.tsQuery)
+		b.tsQueryIndex = len(b.values)
+	}
+	return fmt.Sprintf("to_tsquery('simple', $%d)", b.tsQueryIndex)
+}
+
 func (b *findProductBuilder) openJsonQuery() {
 	b.query += `
 	SELECT
@@ -51,11 +112,32 @@ func (b *findProductBuilder) openJsonQuery() {
 }
 func (b *findProductBuilder) initQuery() {
 	b.query += `
-		SELECT
+		SELECT`
+
+	// Highlighted snippet of the matching text
+	if b.tsQuery != "" {
+		b.query += fmt.Sprintf(`
+			ts_headline(
+				'simple',
+				"p"."title" || ' - ' || "p"."description",
+				%s,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'
+			) AS "highlight",`, b.tsQueryParam())
+	}
+
+	// Sort key of the row to build cursors from
+	if b.req.CursorMode {
+		orderBy, _ := b.sortKey()
+		b.query += fmt.Sprintf(`
+			%s AS "sort_key",`, b.orderByExpr(orderBy))
+	}
+
+	b.query += `
 			"p"."id",
 			"p"."title",
 			"p"."description",
 			"p"."price",
+			"p"."stock",
 			(
 				SELECT
 					to_jsonb("ct")
@@ -77,11 +159,42 @@ func (b *findProductBuilder) initQuery() {
 					SELECT
 						"i"."id",
 						"i"."filename",
-						"i"."url"
+						"i"."url",
+						"i"."variants"
 					FROM "images" "i"
 					WHERE "i"."product_id" = "p"."id"
+					AND "i"."variant_id" IS NULL
 				) AS "it"
-			) AS "images"
+			) AS "images",
+			"p"."options",
+			(
+				SELECT
+					COALESCE(array_to_json(array_agg("vt")), '[]'::json)
+				FROM (
+					SELECT
+						"v"."id",
+						"v"."sku",
+						"v"."options",
+						"v"."price",
+						"v"."stock",
+						(
+							SELECT
+								COALESCE(array_to_json(array_agg("vit")), '[]'::json)
+							FROM (
+								SELECT
+									"vi"."id",
+									"vi"."filename",
+									"vi"."url",
+									"vi"."variants"
+								FROM "images" "vi"
+								WHERE "vi"."variant_id" = "v"."id"
+							) AS "vit"
+						) AS "images"
+					FROM "product_variants" "v"
+					WHERE "v"."product_id" = "p"."id"
+					ORDER BY "v"."created_at"
+				) AS "vt"
+			) AS "variants"
 		FROM "products" "p"
 		WHERE 1 = 1`
 }
@@ -93,6 +206,13 @@ func (b *findProductBuilder) countQuery() {
 		WHERE 1 = 1`
 }
 func (b *findProductBuilder) whereQuery() {
+	b.query += b.whereConditions(false, false)
+	// Last stack record
+	b.lastStackIndex = len(b.values)
+}
+// whereConditions builds the filter, a facet leaves out its own dimension so it
+// still counts the alternatives to what is currently selected.
+func (b *findProductBuilder) whereConditions(skipCategory, skipPrice bool) string {
 	var queryWhere string
 	queryWhereStack := make([]string, 0)
 
@@ -100,64 +220,195 @@ func (b *findProductBuilder) whereQuery() {
 	if b.req.Id != "" {
 		b.values = append(b.values, b.req.Id)
 
-		queryWhereStack = append(queryWhereStack, `
-		AND "p"."id" = ?`)
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND "p"."id" = $%d`, len(b.values)))
 	}
 
 	// Search check
-	if b.req.Search != "" {
-		b.values = append(
-			b.values,
-			"%"+strings.ToLower(b.req.Search)+"%",
-			"%"+strings.ToLower(b.req.Search)+"%",
-		)
+	if b.tsQuery != "" {
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND "p"."search_vector" @@ %s`, b.tsQueryParam()))
+	}
+
+	// Category check
+	if len(b.req.CategoryIds) > 0 && !skipCategory {
+		b.values = append(b.values, b.req.CategoryIds)
+
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND EXISTS (
+			SELECT 1
+			FROM "products_categories" "fpc"
+			WHERE "fpc"."product_id" = "p"."id"
+			AND "fpc"."category_id" = ANY($%d::INT[])
+		)`, len(b.values)))
+	}
+
+	// Price check
+	if b.req.MinPrice > 0 && !skipPrice {
+		b.values = append(b.values, b.req.MinPrice)
+
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND %s >= $%d`, fromPriceQuery, len(b.values)))
+	}
+	if b.req.MaxPrice > 0 && !skipPrice {
+		b.values = append(b.values, b.req.MaxPrice)
 
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND %s <= $%d`, fromPriceQuery, len(b.values)))
+	}
+
+	// Stock check
+	if b.req.InStock {
 		queryWhereStack = append(queryWhereStack, `
-		AND (LOWER("p"."title") LIKE ? OR LOWER("p"."description") LIKE ?)`)
+		AND (
+			"p"."stock" > 0 OR EXISTS (
+				SELECT 1
+				FROM "product_variants" "sv"
+				WHERE "sv"."product_id" = "p"."id"
+				AND "sv"."stock" > 0
+			)
+		)`)
+	}
+
+	// Date check
+	if b.req.StartDate != "" {
+		b.values = append(b.values, b.req.StartDate)
+
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND "p"."created_at" >= DATE($%d)`, len(b.values)))
+	}
+	if b.req.EndDate != "" {
+		b.values = append(b.values, b.req.EndDate)
+
+		queryWhereStack = append(queryWhereStack, fmt.Sprintf(`
+		AND "p"."created_at" < ($%d)::DATE + 1`, len(b.values)))
 	}
 
 	for i := range queryWhereStack {
-		if i != len(queryWhereStack)-1 {
-			queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(i+1), 1)
-		} else {
-			queryWhere += strings.Replace(queryWhereStack[i], "?", "$"+strconv.Itoa(i+1), 1)
-			queryWhere = strings.Replace(queryWhere, "?", "$"+strconv.Itoa(i+2), 1)
-		}
+		queryWhere += queryWhereStack[i]
 	}
-	// Last stack record
+	return queryWhere
+}
+func (b *findProductBuilder) cursorQuery() {
+	if b.req.Keyset == nil {
+		return
+	}
+
+	// Rows after the cursor in the reading direction, the id breaks ties
+	orderBy, sort := b.sortKey()
+	operator := ">"
+	if (sort == "DESC") != b.req.Keyset.Prev {
+		operator = "<"
+	}
+
+	b.values = append(b.values, b.req.Keyset.Value, b.req.Keyset.Id)
+	b.query += fmt.Sprintf(`
+		AND (%s, "p"."id") %s (($%d)::%s, $%d)`,
+		b.orderByExpr(orderBy),
+		operator,
+		len(b.values)-1,
+		cursorCasts[orderBy],
+		len(b.values),
+	)
+	b.lastStackIndex = len(b.values)
+}
+func (b *findProductBuilder) facetCategoryQuery() {
+	b.query += `
+		SELECT
+			"c"."id",
+			"c"."title",
+			COUNT(DISTINCT "p"."id") AS "count"
+		FROM "products" "p"
+			JOIN "products_categories" "pc" ON "pc"."product_id" = "p"."id"
+			JOIN "categories" "c" ON "c"."id" = "pc"."category_id"
+		WHERE 1 = 1`
+	b.query += b.whereConditions(true, false)
+	b.query += `
+		GROUP BY "c"."id", "c"."title"
+		ORDER BY "c"."title";`
+	b.lastStackIndex = len(b.values)
+}
+func (b *findProductBuilder) facetPriceQuery() {
+	bounds := make([]string, 0)
+	for _, bucket := range priceBuckets[1:] {
+		bounds = append(bounds, strconv.FormatFloat(bucket, 'f', -1, 64))
+	}
+
+	b.query += fmt.Sprintf(`
+		SELECT
+			width_bucket(%s, ARRAY[%s]::FLOAT[]) AS "bucket",
+			COUNT(*) AS "count"
+		FROM "products" "p"
+		WHERE 1 = 1`, fromPriceQuery, strings.Join(bounds, ", "))
+	b.query += b.whereConditions(false, true)
+	b.query += `
+		GROUP BY "bucket";`
 	b.lastStackIndex = len(b.values)
+}
+// sortKey resolves the order of the listing, a search without an explicit
+// order is ranked by relevance.
+func (b *findProductBuilder) sortKey() (string, string) {
+	sortMap := map[string]string{
+		"DESC": "DESC",
+		"ASC":  "ASC",
+	}
+	sort := sortMap[strings.ToUpper(b.req.Sort)]
 
-	// Summary query
-	b.query += queryWhere
+	orderBy := b.req.OrderBy
+	if cursorCasts[orderBy] == "" || (orderBy == "relevance" && b.tsQuery == "") {
+		orderBy = ""
+	}
+	if orderBy == "" && b.tsQuery != "" {
+		orderBy = "relevance"
+		if b.req.Sort == "" {
+			sort = sortMap["DESC"]
+		}
+	}
+	if orderBy == "" {
+		orderBy = "title"
+	}
+	if sort == "" {
+		sort = sortMap["ASC"]
+	}
+	return orderBy, sort
 }
-func (b *findProductBuilder) sort() {
+func (b *findProductBuilder) orderByExpr(orderBy string) string {
 	orderByMap := map[string]string{
 		"id":    "\"p\".\"id\"",
 		"title": "\"p\".\"title\"",
 		"price": "\"p\".\"price\"",
 	}
-	if orderByMap[b.req.OrderBy] == "" {
-		b.req.OrderBy = orderByMap["title"]
-	} else {
-		b.req.OrderBy = orderByMap[b.req.OrderBy]
+	if orderBy == "relevance" {
+		return fmt.Sprintf(`ts_rank_cd("p"."search_vector", %s)`, b.tsQueryParam())
 	}
+	return orderByMap[orderBy]
+}
+func (b *findProductBuilder) sort() {
+	orderBy, sort := b.sortKey()
 
-	sortMap := map[string]string{
-		"DESC": "DESC",
-		"ASC":  "ASC",
-	}
-	if sortMap[b.req.Sort] == "" {
-		b.req.Sort = sortMap["ASC"]
-	} else {
-		b.req.Sort = sortMap[strings.ToUpper(b.req.Sort)]
+	// Reading backwards from a cursor, the page is put back in order afterwards
+	if b.req.Keyset != nil && b.req.Keyset.Prev {
+		if sort == "ASC" {
+			sort = "DESC"
+		} else {
+			sort = "ASC"
+		}
 	}
 
-	b.values = append(b.values, b.req.OrderBy)
 	b.query += fmt.Sprintf(`
-		ORDER BY $%d %s`, b.lastStackIndex+1, b.req.Sort)
+		ORDER BY %s %s, "p"."id" %s`, b.orderByExpr(orderBy), sort, sort)
 	b.lastStackIndex = len(b.values)
 }
 func (b *findProductBuilder) paginate() {
+	// One extra row tells whether another page follows
+	if b.req.CursorMode {
+		b.values = append(b.values, b.req.Limit+1)
+
+		b.query += fmt.Sprintf(`	LIMIT $%d`, b.lastStackIndex+1)
+		b.lastStackIndex = len(b.values)
+		return
+	}
+
 	// offset (page - 1)*limit
 	b.values = append(b.values, (b.req.Page-1)*b.req.Limit, b.req.Limit)
 
@@ -172,8 +423,11 @@ func (b *findProductBuilder) resetQuery() {
 	b.query = ""
 	b.values = make([]any, 0)
 	b.lastStackIndex = 0
+	b.tsQueryIndex = 0
 }
 func (b *findProductBuilder) Result() []*products.Product {
+	// The builder is reused for the next query, failed or not
+	defer b.resetQuery()
 	_, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 
@@ -189,10 +443,44 @@ func (b *findProductBuilder) Result() []*products.Product {
 		log.Printf("unmarshal products failed: %v\n", err)
 		return make([]*products.Product, 0)
 	}
-	b.resetQuery()
 	return productsData
 }
+func (b *findProductBuilder) CursorResult() ([]*products.Product, []*entities.Cursor) {
+	defer b.resetQuery()
+	_, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	bytes := make([]byte, 0)
+	rows := make([]*struct {
+		*products.Product
+		SortKey any `json:"sort_key"`
+	}, 0)
+
+	if err := b.db.Get(&bytes, b.query, b.values...); err != nil {
+		log.Printf("find products failed: %v\n", err)
+		return make([]*products.Product, 0), make([]*entities.Cursor, 0)
+	}
+
+	if err := json.Unmarshal(bytes, &rows); err != nil {
+		log.Printf("unmarshal products failed: %v\n", err)
+		return make([]*products.Product, 0), make([]*entities.Cursor, 0)
+	}
+
+	productsData := make([]*products.Product, len(rows))
+	cursors := make([]*entities.Cursor, len(rows))
+	for i, row := range rows {
+		productsData[i] = row.Product
+		cursors[i] = &entities.Cursor{
+			OrderBy: b.req.OrderBy,
+			Sort:    b.req.Sort,
+			Value:   row.SortKey,
+			Id:      row.Id,
+		}
+	}
+	return productsData, cursors
+}
 func (b *findProductBuilder) Count() int {
+	defer b.resetQuery()
 	_, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 
@@ -201,9 +489,43 @@ func (b *findProductBuilder) Count() int {
 		log.Printf("count products failed: %v\n", err)
 		return 0
 	}
-	b.resetQuery()
 	return count
 }
+func (b *findProductBuilder) CategoryFacets() []*products.CategoryFacet {
+	defer b.resetQuery()
+	facets := make([]*products.CategoryFacet, 0)
+	if err := b.db.Select(&facets, b.query, b.values...); err != nil {
+		log.Printf("find category facets failed: %v\n", err)
+		return make([]*products.CategoryFacet, 0)
+	}
+	return facets
+}
+func (b *findProductBuilder) PriceFacets() []*products.PriceFacet {
+	defer b.resetQuery()
+	rows := make([]*struct {
+		Bucket int `db:"bucket"`
+		Count  int `db:"count"`
+	}, 0)
+	if err := b.db.Select(&rows, b.query, b.values...); err != nil {
+		log.Printf("find price facets failed: %v\n", err)
+	}
+
+	// Every bucket is listed, empty ones included
+	facets := make([]*products.PriceFacet, len(priceBuckets))
+	for i := range priceBuckets {
+		facets[i] = &products.PriceFacet{Min: priceBuckets[i]}
+		if i != len(priceBuckets)-1 {
+			facets[i].Max = &priceBuckets[i+1]
+		}
+	}
+	// width_bucket returns 0 below the first bound, the 0 bucket starts at zero anyway
+	for _, r := range rows {
+		if r.Bucket >= 0 && r.Bucket < len(facets) {
+			facets[r.Bucket].Count += r.Count
+		}
+	}
+	return facets
+}
 func (b *findProductBuilder) PrintQuery() {
 	utils.Debug(b.values)
 	fmt.Println(b.query)
@@ -227,10 +549,34 @@ func (en *findProductEngineer) FindProduct() IFindProductBuilder {
 	return en.builder
 }
 
+func (en *findProductEngineer) FindProductByCursor() IFindProductBuilder {
+	en.builder.openJsonQuery()
+	en.builder.initQuery()
+	en.builder.whereQuery()
+	en.builder.cursorQuery()
+	en.builder.sort()
+	en.builder.paginate()
+	en.builder.closeJsonQuery()
+	return en.builder
+}
+
 func (en *findProductEngineer) CountProduct() IFindProductBuilder {
 	en.builder.countQuery()
 	en.builder.whereQuery()
 	return en.builder
 }
 
+func (en *findProductEngineer) FacetProduct() *products.ProductFacets {
+	en.builder.facetCategoryQuery()
+	categories := en.builder.CategoryFacets()
+
+	en.builder.facetPriceQuery()
+	prices := en.builder.PriceFacets()
+
+	return &products.ProductFacets{
+		Categories: categories,
+		Prices:     prices,
+	}
+}
+
 
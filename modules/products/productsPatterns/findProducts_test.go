@@ -0,0 +1,46 @@
+package productsPatterns
+
+import (
+	"testing"
+
+	"github.com/jetsadawwts/go-restapi/modules/entities"
+	"github.com/jetsadawwts/go-restapi/modules/products"
+)
+
+func TestCheckCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+		search  string
+		value   any
+		ok      bool
+	}{
+		{"title", "title", "", "Coffee", true},
+		{"id", "id", "", "P000001", true},
+		{"price", "price", "", float64(120.5), true},
+		{"price as text", "price", "", "120.5", false},
+		{"title as number", "title", "", float64(1), false},
+		// Without an order the products are sorted by title, or by relevance when searching
+		{"default order", "", "", "Coffee", true},
+		{"default order when searching", "", "coffee", float64(0.1), true},
+		{"relevance when searching", "relevance", "coffee", float64(0.1), true},
+		{"relevance without a search", "relevance", "", float64(0.1), false},
+		{"null", "price", "", nil, false},
+		{"object", "title", "", map[string]any{"title": "Coffee"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &products.ProductFilter{
+				Search: tt.search,
+				PaginationReq: &entities.PaginationReq{
+					Keyset: &entities.Cursor{OrderBy: tt.orderBy, Value: tt.value, Id: "P000001"},
+				},
+				SortReq: &entities.SortReq{OrderBy: tt.orderBy},
+			}
+			if err := CheckCursor(req); (err == nil) != tt.ok {
+				t.Errorf("CheckCursor error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
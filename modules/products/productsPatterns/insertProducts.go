@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jetsadawwts/go-restapi/modules/files/filesPatterns"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jmoiron/sqlx"
 )
@@ -14,6 +15,9 @@ type IInsertProductBuidler interface {
 	insertProduct() error
 	insertCategory() error
 	insertAttachment() error
+	insertStock() error
+	insertVariants() error
+	attachFiles() error
 	commit() error
 	getProductId() string
 }
@@ -51,17 +55,24 @@ func (b *insertProductBuilder) insertProduct() error {
 	INSERT INTO "products" (
 		"title",
 		"description",
-		"price"
+		"price",
+		"stock",
+		"options"
 	)
-	VALUES ($1, $2, $3)
+	VALUES ($1, $2, $3, 0, $4)
 		RETURNING "id";`
 
+	if b.req.Options == nil {
+		b.req.Options = make([]string, 0)
+	}
+
 	if err := b.tx.QueryRowxContext(
 		ctx,
 		query,
 		b.req.Title,
 		b.req.Description,
 		b.req.Price,
+		b.req.Options,
 	).Scan(&b.req.Id); err != nil {
 		b.tx.Rollback()
 		return fmt.Errorf("insert product failed: %v", err)
@@ -91,6 +102,10 @@ func (b *insertProductBuilder) insertCategory() error {
 	return nil
 }
 func (b *insertProductBuilder) insertAttachment() error {
+	if len(b.req.Images) == 0 {
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 
@@ -98,6 +113,7 @@ func (b *insertProductBuilder) insertAttachment() error {
 	INSERT INTO "images" (
 		"filename",
 		"url",
+		"variants",
 		"product_id"
 	)
 	VALUES`
@@ -108,17 +124,18 @@ func (b *insertProductBuilder) insertAttachment() error {
 		valueStack = append(valueStack,
 			b.req.Images[i].FileName,
 			b.req.Images[i].Url,
+			b.req.Images[i].VariantsJson(),
 			b.req.Id,
 		)
 
 		if i != len(b.req.Images)-1 {
 			query += fmt.Sprintf(`
-			($%d, $%d, $%d),`, index+1, index+2, index+3)
+			($%d, $%d, $%d::jsonb, $%d),`, index+1, index+2, index+3, index+4)
 		} else {
 			query += fmt.Sprintf(`
-			($%d, $%d, $%d);`, index+1, index+2, index+3)
+			($%d, $%d, $%d::jsonb, $%d);`, index+1, index+2, index+3, index+4)
 		}
-		index += 3
+		index += 4
 	}
 
 	if _, err := b.tx.ExecContext(
@@ -131,6 +148,46 @@ func (b *insertProductBuilder) insertAttachment() error {
 	}
 	return nil
 }
+func (b *insertProductBuilder) insertStock() error {
+	if b.req.Stock == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := ChangeStock(ctx, b.tx, &products.StockMovement{
+		ProductId: b.req.Id,
+		QtyChange: b.req.Stock,
+		Reason:    StockReasonInitial,
+	}); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return nil
+}
+func (b *insertProductBuilder) insertVariants() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	for _, v := range b.req.Variants {
+		if err := insertVariant(ctx, b.tx, b.req.Id, v); err != nil {
+			b.tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
+func (b *insertProductBuilder) attachFiles() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	if err := filesPatterns.AttachProductFiles(ctx, b.tx, b.req.Id); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return nil
+}
 func (b *insertProductBuilder) commit() error {
 	if err := b.tx.Commit(); err != nil {
 		return err
@@ -158,6 +215,15 @@ func (en *insertProductEngineer) InsertProduct() (string, error) {
 	if err := en.builder.insertAttachment(); err != nil {
 		return "", err
 	}
+	if err := en.builder.insertStock(); err != nil {
+		return "", err
+	}
+	if err := en.builder.insertVariants(); err != nil {
+		return "", err
+	}
+	if err := en.builder.attachFiles(); err != nil {
+		return "", err
+	}
 	if err := en.builder.commit(); err != nil {
 		return "", err
 	}
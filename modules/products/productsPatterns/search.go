@@ -0,0 +1,52 @@
+package productsPatterns
+
+import (
+	"strings"
+	"unicode"
+)
+
+// BuildTsQuery turns a storefront search into a to_tsquery('simple', ...) expression.
+// Quoted text is matched as a phrase, every other word as a prefix and a leading "-"
+// excludes a word, e.g. `"coffee bean" dark -decaf` -> `(coffee <-> bean) & dark:* & !decaf:*`.
+// It returns an empty string when nothing searchable is left.
+func BuildTsQuery(search string) string {
+	terms := make([]string, 0)
+
+	parts := strings.Split(search, `"`)
+	for i, part := range parts {
+		// Odd parts are inside quotes
+		if i%2 == 1 {
+			if words := lexemes(part); len(words) > 0 {
+				terms = append(terms, "("+strings.Join(words, " <-> ")+")")
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			negate := strings.HasPrefix(field, "-")
+			for _, word := range lexemes(field) {
+				term := word + ":*"
+				if negate {
+					term = "!" + term
+				}
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	// A query made only of exclusions matches nothing useful
+	for _, t := range terms {
+		if !strings.HasPrefix(t, "!") {
+			return strings.Join(terms, " & ")
+		}
+	}
+	return ""
+}
+
+// lexemes splits text on anything that is not a letter or a digit, so no tsquery
+// operator from the user input reaches the database.
+func lexemes(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+}
@@ -0,0 +1,35 @@
+package productsPatterns
+
+import "testing"
+
+func TestBuildTsQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		search string
+		want   string
+	}{
+		{"word", "coffee", "coffee:*"},
+		{"words", "dark  Coffee", "dark:* & coffee:*"},
+		{"phrase", `"coffee bean"`, "(coffee <-> bean)"},
+		{"phrase, word and exclusion", `"coffee bean" dark -decaf`, "(coffee <-> bean) & dark:* & !decaf:*"},
+		{"unclosed quote", `"coffee bean`, "(coffee <-> bean)"},
+		{"thai", "กาแฟ", "กาแฟ:*"},
+		{"combining marks", "ชาเขียว", "ชาเขียว:*"},
+		{"digits", "v60", "v60:*"},
+		// Operators typed by the user are split away, not passed to to_tsquery
+		{"operators", "coffee&!tea|(milk):*", "coffee:* & tea:* & milk:*"},
+		{"hyphenated word", "cold-brew", "cold:* & brew:*"},
+		{"only exclusions", "-decaf -tea", ""},
+		{"empty phrase", `""`, ""},
+		{"only symbols", "&|!:*", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BuildTsQuery(tt.search); got != tt.want {
+				t.Errorf("BuildTsQuery(%q) = %q, want %q", tt.search, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package productsPatterns
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jetsadawwts/go-restapi/modules/products"
+	"github.com/jmoiron/sqlx"
+)
+
+// Reasons recorded in the stock ledger by the system itself
+const (
+	StockReasonInitial       = "initial stock"
+	StockReasonOrderReserved = "order reserved"
+	StockReasonOrderCanceled = "order canceled"
+)
+
+// LockProductsStock takes row locks on the products in id order, so concurrent
+// transactions always lock in the same sequence, and returns the current stock.
+func LockProductsStock(ctx context.Context, tx *sqlx.Tx, productIds []string) (map[string]int, error) {
+	query := `
+	SELECT
+		"id",
+		"stock"
+	FROM "products"
+	WHERE "id" = ANY($1)
+	ORDER BY "id"
+	FOR UPDATE;`
+
+	rows, err := tx.QueryxContext(ctx, query, productIds)
+	if err != nil {
+		return nil, fmt.Errorf("lock products stock failed: %v", err)
+	}
+	defer rows.Close()
+
+	stock := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var qty int
+		if err := rows.Scan(&id, &qty); err != nil {
+			return nil, fmt.Errorf("scan products stock failed: %v", err)
+		}
+		stock[id] = qty
+	}
+	return stock, rows.Err()
+}
+
+// LockVariantsStock is LockProductsStock for product variants.
+func LockVariantsStock(ctx context.Context, tx *sqlx.Tx, variantIds []string) (map[string]int, error) {
+	query := `
+	SELECT
+		"id",
+		"stock"
+	FROM "product_variants"
+	WHERE "id" = ANY($1::uuid[])
+	ORDER BY "id"
+	FOR UPDATE;`
+
+	rows, err := tx.QueryxContext(ctx, query, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("lock variants stock failed: %v", err)
+	}
+	defer rows.Close()
+
+	stock := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var qty int
+		if err := rows.Scan(&id, &qty); err != nil {
+			return nil, fmt.Errorf("scan variants stock failed: %v", err)
+		}
+		stock[id] = qty
+	}
+	return stock, rows.Err()
+}
+
+// LockVariantStock locks one variant of a product and returns its stock,
+// ErrProductNotFound when the product has no such variant.
+func LockVariantStock(ctx context.Context, tx *sqlx.Tx, productId, variantId string) (int, error) {
+	query := `
+	SELECT
+		"stock"
+	FROM "product_variants"
+	WHERE "id" = $1::uuid
+	AND "product_id" = $2
+	FOR UPDATE;`
+
+	var stock int
+	if err := tx.QueryRowxContext(ctx, query, variantId, productId).Scan(&stock); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %s (%s)", products.ErrProductNotFound, productId, variantId)
+		}
+		return 0, fmt.Errorf("lock variant stock failed: %v", err)
+	}
+	return stock, nil
+}
+
+// ChangeStock applies qtyChange to a product, or to one of its variants when VariantId
+// is set, and appends the movement to the ledger. The update refuses to take stock below zero,
+// ErrOutOfStock then tells it from a missing product, ErrProductNotFound.
+func ChangeStock(ctx context.Context, tx *sqlx.Tx, req *products.StockMovement) error {
+	query := `
+	UPDATE "products" SET
+		"stock" = "stock" + $1
+	WHERE "id" = $2
+	AND "stock" + $1 >= 0
+		RETURNING "stock";`
+	values := []any{req.QtyChange, req.ProductId}
+	target := req.ProductId
+	queryExists := `SELECT EXISTS (SELECT 1 FROM "products" WHERE "id" = $1);`
+	existsValues := []any{req.ProductId}
+
+	if req.VariantId != nil {
+		query = `
+		UPDATE "product_variants" SET
+			"stock" = "stock" + $1
+		WHERE "product_id" = $2
+		AND "id" = $3
+		AND "stock" + $1 >= 0
+			RETURNING "stock";`
+		values = append(values, *req.VariantId)
+		target = fmt.Sprintf("%s (%s)", req.ProductId, *req.VariantId)
+		queryExists = `SELECT EXISTS (SELECT 1 FROM "product_variants" WHERE "product_id" = $1 AND "id" = $2);`
+		existsValues = append(existsValues, *req.VariantId)
+	}
+
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		values...,
+	).Scan(&req.StockAfter); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("update stock failed: %v", err)
+		}
+
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, queryExists, existsValues...); err != nil {
+			return fmt.Errorf("check stock target failed: %v", err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s", products.ErrProductNotFound, target)
+		}
+		return fmt.Errorf("%w: %s", products.ErrOutOfStock, target)
+	}
+
+	queryLedger := `
+	INSERT INTO "stock_movements" (
+		"product_id",
+		"variant_id",
+		"qty_change",
+		"stock_after",
+		"reason",
+		"order_id",
+		"created_by"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING "id";`
+
+	if err := tx.QueryRowxContext(
+		ctx,
+		queryLedger,
+		req.ProductId,
+		req.VariantId,
+		req.QtyChange,
+		req.StockAfter,
+		req.Reason,
+		req.OrderId,
+		req.CreatedBy,
+	).Scan(&req.Id); err != nil {
+		return fmt.Errorf("insert stock_movements failed: %v", err)
+	}
+	return nil
+}
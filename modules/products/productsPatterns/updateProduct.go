@@ -5,8 +5,7 @@ import (
 	"fmt"
 
 	"github.com/jetsadawwts/go-restapi/modules/entities"
-	"github.com/jetsadawwts/go-restapi/modules/files"
-	"github.com/jetsadawwts/go-restapi/modules/files/filesUsecases"
+	"github.com/jetsadawwts/go-restapi/modules/files/filesPatterns"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jmoiron/sqlx"
 )
@@ -17,10 +16,13 @@ type IUpdateProductBuilder interface {
 	updateTitleQuery()
 	updateDescriptionQuery()
 	updatePriceQuery()
+	updateOptionsQuery()
 	updateCategory() error
+	upsertVariants() error
 	insertImages() error
 	getOldImages() []*entities.Image
 	deleteOldImages() error
+	attachFiles() error
 	closeQuery()
 	updateProduct() error
 	getQueryFields() []string
@@ -35,20 +37,18 @@ type updateProductBuilder struct {
 	db             *sqlx.DB
 	tx             *sqlx.Tx
 	req            *products.Product
-	filesUsecases  filesUsecases.IFilesUsecase
 	query          string
 	queryFields    []string
 	lastStackIndex int
 	values         []any
 }
 
-func UpdateProductBuilder(db *sqlx.DB, req *products.Product, filesUsecases filesUsecases.IFilesUsecase) IUpdateProductBuilder {
+func UpdateProductBuilder(db *sqlx.DB, req *products.Product) IUpdateProductBuilder {
 	return &updateProductBuilder{
-		db:            db,
-		req:           req,
-		filesUsecases: filesUsecases,
-		queryFields:   make([]string, 0),
-		values:        make([]any, 0),
+		db:          db,
+		req:         req,
+		queryFields: make([]string, 0),
+		values:      make([]any, 0),
 	}
 }
 
@@ -95,6 +95,15 @@ func (b *updateProductBuilder) updatePriceQuery() {
 		"price" = $%d`, b.lastStackIndex))
 	}
 }
+func (b *updateProductBuilder) updateOptionsQuery() {
+	if b.req.Options != nil {
+		b.values = append(b.values, b.req.Options)
+		b.lastStackIndex = len(b.values)
+
+		b.queryFields = append(b.queryFields, fmt.Sprintf(`
+		"options" = $%d`, b.lastStackIndex))
+	}
+}
 func (b *updateProductBuilder) updateCategory() error {
 	if b.req.Category == nil {
 		return nil
@@ -119,11 +128,46 @@ func (b *updateProductBuilder) updateCategory() error {
 	}
 	return nil
 }
+func (b *updateProductBuilder) upsertVariants() error {
+	// Variants are only replaced when the request lists them
+	if b.req.Variants == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	keepIds := make([]string, 0)
+	for _, v := range b.req.Variants {
+		if v.Id != "" {
+			keepIds = append(keepIds, v.Id)
+		}
+	}
+	if err := deleteMissingVariants(ctx, b.tx, b.req.Id, keepIds); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+
+	for _, v := range b.req.Variants {
+		if v.Id != "" {
+			if err := updateVariant(ctx, b.tx, b.req.Id, v); err != nil {
+				b.tx.Rollback()
+				return err
+			}
+			continue
+		}
+		if err := insertVariant(ctx, b.tx, b.req.Id, v); err != nil {
+			b.tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
 func (b *updateProductBuilder) insertImages() error {
 	query := `
 	INSERT INTO "images" (
 		"filename",
 		"url",
+		"variants",
 		"product_id"
 	)
 	VALUES`
@@ -134,17 +178,18 @@ func (b *updateProductBuilder) insertImages() error {
 		valueStack = append(valueStack,
 			b.req.Images[i].FileName,
 			b.req.Images[i].Url,
+			b.req.Images[i].VariantsJson(),
 			b.req.Id,
 		)
 
 		if i != len(b.req.Images)-1 {
 			query += fmt.Sprintf(`
-			($%d, $%d, $%d),`, index+1, index+2, index+3)
+			($%d, $%d, $%d::jsonb, $%d),`, index+1, index+2, index+3, index+4)
 		} else {
 			query += fmt.Sprintf(`
-			($%d, $%d, $%d);`, index+1, index+2, index+3)
+			($%d, $%d, $%d::jsonb, $%d);`, index+1, index+2, index+3, index+4)
 		}
-		index += 3
+		index += 4
 	}
 
 	if _, err := b.tx.ExecContext(
@@ -164,7 +209,8 @@ func (b *updateProductBuilder) getOldImages() []*entities.Image {
 		"filename",
 		"url"
 	FROM "images"
-	WHERE "product_id" = $1;`
+	WHERE "product_id" = $1
+	AND "variant_id" IS NULL;`
 
 	images := make([]*entities.Image, 0)
 	if err := b.db.Select(
@@ -179,19 +225,10 @@ func (b *updateProductBuilder) getOldImages() []*entities.Image {
 func (b *updateProductBuilder) deleteOldImages() error {
 	query := `
 	DELETE FROM "images"
-	WHERE "product_id" = $1;`
-
-	images := b.getOldImages()
-	if len(images) > 0 {
-		deleteFileReq := make([]*files.DeleteFileReq, 0)
-		for _, img := range images {
-			deleteFileReq = append(deleteFileReq, &files.DeleteFileReq{
-				Destination: fmt.Sprintf("images/products/%s", img.FileName),
-			})
-		}
-		b.filesUsecases.DeleteFileOnGCP(deleteFileReq)
-	}
+	WHERE "product_id" = $1
+	AND "variant_id" IS NULL;`
 
+	// The objects stay in storage, the sweeper removes them once they are orphans
 	if _, err := b.tx.ExecContext(
 		context.Background(),
 		query,
@@ -202,6 +239,13 @@ func (b *updateProductBuilder) deleteOldImages() error {
 	}
 	return nil
 }
+func (b *updateProductBuilder) attachFiles() error {
+	if err := filesPatterns.AttachProductFiles(context.Background(), b.tx, b.req.Id); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return nil
+}
 func (b *updateProductBuilder) closeQuery() {
 	b.values = append(b.values, b.req.Id)
 	b.lastStackIndex = len(b.values)
@@ -236,6 +280,7 @@ func (en *updateProductEngineer) sumQueryFields() {
 	en.builder.updateTitleQuery()
 	en.builder.updateDescriptionQuery()
 	en.builder.updatePriceQuery()
+	en.builder.updateOptionsQuery()
 
 	fields := en.builder.getQueryFields()
 
@@ -258,9 +303,11 @@ func (en *updateProductEngineer) UpdateProduct() error {
 
 	fmt.Println(en.builder.getQuery())
 
-	// Update product
-	if err := en.builder.updateProduct(); err != nil {
-		return err
+	// Update product, a request may only touch the category, images or variants
+	if len(en.builder.getQueryFields()) > 0 {
+		if err := en.builder.updateProduct(); err != nil {
+			return err
+		}
 	}
 
 	// Update category
@@ -268,6 +315,11 @@ func (en *updateProductEngineer) UpdateProduct() error {
 		return err
 	}
 
+	// Update variants
+	if err := en.builder.upsertVariants(); err != nil {
+		return err
+	}
+
 	if en.builder.getImagesLen() > 0 {
 		if err := en.builder.deleteOldImages(); err != nil {
 			return err
@@ -277,6 +329,11 @@ func (en *updateProductEngineer) UpdateProduct() error {
 		}
 	}
 
+	// Track which uploaded files the product uses
+	if err := en.builder.attachFiles(); err != nil {
+		return err
+	}
+
 	// Commit
 	if err := en.builder.commit(); err != nil {
 		return err
@@ -0,0 +1,149 @@
+package productsPatterns
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jetsadawwts/go-restapi/modules/entities"
+	"github.com/jetsadawwts/go-restapi/modules/products"
+	"github.com/jmoiron/sqlx"
+)
+
+// insertVariant creates a variant of productId with its images, its opening stock goes
+// through the stock ledger like any other movement.
+func insertVariant(ctx context.Context, tx *sqlx.Tx, productId string, v *products.Variant) error {
+	query := `
+	INSERT INTO "product_variants" (
+		"product_id",
+		"sku",
+		"options",
+		"price",
+		"stock"
+	)
+	VALUES ($1, $2, $3, $4, 0)
+		RETURNING "id";`
+
+	if err := tx.QueryRowxContext(
+		ctx,
+		query,
+		productId,
+		v.Sku,
+		v.Options,
+		v.Price,
+	).Scan(&v.Id); err != nil {
+		return fmt.Errorf("insert product_variants failed: %v", err)
+	}
+
+	if err := insertVariantImages(ctx, tx, productId, v.Id, v.Images); err != nil {
+		return err
+	}
+
+	if v.Stock > 0 {
+		if err := ChangeStock(ctx, tx, &products.StockMovement{
+			ProductId: productId,
+			VariantId: &v.Id,
+			QtyChange: v.Stock,
+			Reason:    StockReasonInitial,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateVariant changes the sku, options and price of an existing variant. Stock is
+// left untouched, it only moves through the stock endpoint and orders.
+func updateVariant(ctx context.Context, tx *sqlx.Tx, productId string, v *products.Variant) error {
+	query := `
+	UPDATE "product_variants" SET
+		"sku" = $1,
+		"options" = $2,
+		"price" = $3
+	WHERE "id" = $4
+	AND "product_id" = $5;`
+
+	result, err := tx.ExecContext(
+		ctx,
+		query,
+		v.Sku,
+		v.Options,
+		v.Price,
+		v.Id,
+		productId,
+	)
+	if err != nil {
+		return fmt.Errorf("update product_variants failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("variant %s not found", v.Id)
+	}
+
+	if len(v.Images) > 0 {
+		queryDelete := `
+		DELETE FROM "images"
+		WHERE "variant_id" = $1;`
+
+		if _, err := tx.ExecContext(ctx, queryDelete, v.Id); err != nil {
+			return fmt.Errorf("delete variant images failed: %v", err)
+		}
+		if err := insertVariantImages(ctx, tx, productId, v.Id, v.Images); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMissingVariants removes the variants of productId that are not listed in keepIds.
+func deleteMissingVariants(ctx context.Context, tx *sqlx.Tx, productId string, keepIds []string) error {
+	query := `
+	DELETE FROM "product_variants"
+	WHERE "product_id" = $1
+	AND NOT ("id" = ANY($2::uuid[]));`
+
+	if _, err := tx.ExecContext(ctx, query, productId, keepIds); err != nil {
+		return fmt.Errorf("delete product_variants failed: %v", err)
+	}
+	return nil
+}
+
+func insertVariantImages(ctx context.Context, tx *sqlx.Tx, productId, variantId string, images []*entities.Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO "images" (
+		"filename",
+		"url",
+		"variants",
+		"product_id",
+		"variant_id"
+	)
+	VALUES`
+
+	valueStack := make([]any, 0)
+	var index int
+	for i := range images {
+		valueStack = append(valueStack,
+			images[i].FileName,
+			images[i].Url,
+			images[i].VariantsJson(),
+			productId,
+			variantId,
+		)
+
+		if i != len(images)-1 {
+			query += fmt.Sprintf(`
+			($%d, $%d, $%d::jsonb, $%d, $%d),`, index+1, index+2, index+3, index+4, index+5)
+		} else {
+			query += fmt.Sprintf(`
+			($%d, $%d, $%d::jsonb, $%d, $%d);`, index+1, index+2, index+3, index+4, index+5)
+		}
+		index += 5
+	}
+
+	if _, err := tx.ExecContext(ctx, query, valueStack...); err != nil {
+		return fmt.Errorf("insert variant images failed: %v", err)
+	}
+	return nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
-	"github.com/jetsadawwts/go-restapi/modules/files/filesUsecases"
+	"github.com/jetsadawwts/go-restapi/modules/files/filesPatterns"
 	"github.com/jetsadawwts/go-restapi/modules/products"
 	"github.com/jetsadawwts/go-restapi/modules/products/productsPatterns"
 	"github.com/jmoiron/sqlx"
@@ -16,22 +18,24 @@ import (
 type IProductsRepository interface {
 	FindOneProduct(productId string) (*products.Product, error)
 	FindProduct(req *products.ProductFilter) ([]*products.Product, int)
+	FindProductByCursor(req *products.ProductFilter) ([]*products.Product, string, string, error)
+	FindProductFacets(req *products.ProductFilter) *products.ProductFacets
 	InsertProduct(req *products.Product) (*products.Product, error)
 	UpdateProduct(req *products.Product) (*products.Product, error)
 	DeleteProduct(productId string) error 
+	AdjustStock(req *products.StockAdjustReq) (*products.StockMovement, error)
+	FindStockMovement(req *products.StockMovementFilter) ([]*products.StockMovement, int)
 }
 
 type productsRepository struct {
-	db           *sqlx.DB
-	cfg          config.IConfig
-	filesUsecase filesUsecases.IFilesUsecase
+	db  *sqlx.DB
+	cfg config.IConfig
 }
 
-func ProductsRepository(db *sqlx.DB, cfg config.IConfig, filesUsecase filesUsecases.IFilesUsecase) IProductsRepository {
+func ProductsRepository(db *sqlx.DB, cfg config.IConfig) IProductsRepository {
 	return &productsRepository{
-		db:           db,
-		cfg:          cfg,
-		filesUsecase: filesUsecase,
+		db:  db,
+		cfg: cfg,
 	}
 }
 
@@ -45,6 +49,7 @@ func (r *productsRepository) FindOneProduct(productId string) (*products.Product
 				"p"."title",
 				"p"."description",
 				"p"."price",
+				"p"."stock",
 				(
 					SELECT 
 						to_jsonb("ct")
@@ -66,11 +71,42 @@ func (r *productsRepository) FindOneProduct(productId string) (*products.Product
 						SELECT 
 							"i"."id",
 							"i"."filename",
-							"i"."url"
+							"i"."url",
+							"i"."variants"
 						FROM "images" "i"
 						WHERE "i"."product_id" = "p"."id"
+						AND "i"."variant_id" IS NULL
 					) AS "it"
-				) AS "images"
+				) AS "images",
+				"p"."options",
+				(
+					SELECT
+						COALESCE(array_to_json(array_agg("vt")), '[]'::json)
+					FROM (
+						SELECT
+							"v"."id",
+							"v"."sku",
+							"v"."options",
+							"v"."price",
+							"v"."stock",
+							(
+								SELECT
+									COALESCE(array_to_json(array_agg("vit")), '[]'::json)
+								FROM (
+									SELECT
+										"vi"."id",
+										"vi"."filename",
+										"vi"."url",
+										"vi"."variants"
+									FROM "images" "vi"
+									WHERE "vi"."variant_id" = "v"."id"
+								) AS "vit"
+							) AS "images"
+						FROM "product_variants" "v"
+						WHERE "v"."product_id" = "p"."id"
+						ORDER BY "v"."created_at"
+					) AS "vt"
+				) AS "variants"
 			FROM "products" "p"
 			WHERE "p"."id" = $1
 			LIMIT 1
@@ -102,6 +138,26 @@ func (r *productsRepository) FindProduct(req *products.ProductFilter) ([]*produc
 	return result, count
 }
 
+func (r *productsRepository) FindProductByCursor(req *products.ProductFilter) ([]*products.Product, string, string, error) {
+	if err := productsPatterns.CheckCursor(req); err != nil {
+		return nil, "", "", err
+	}
+
+	builder := productsPatterns.FindProductBuilder(r.db, req)
+	engineer := productsPatterns.FindProductEngineer(builder)
+
+	result, cursors := engineer.FindProductByCursor().CursorResult()
+	page, next, prev := entities.KeysetPage(req.PaginationReq, result, cursors)
+	return page, next, prev, nil
+}
+
+func (r *productsRepository) FindProductFacets(req *products.ProductFilter) *products.ProductFacets {
+	builder := productsPatterns.FindProductBuilder(r.db, req)
+	engineer := productsPatterns.FindProductEngineer(builder)
+
+	return engineer.FacetProduct()
+}
+
 func (r *productsRepository) InsertProduct(req *products.Product) (*products.Product, error) {
 	builder := productsPatterns.InsertProductBuilder(r.db, req)
 	engineer := productsPatterns.InsertProductEngineer(builder)
@@ -120,7 +176,7 @@ func (r *productsRepository) InsertProduct(req *products.Product) (*products.Pro
 }
 
 func (r *productsRepository) UpdateProduct(req *products.Product) (*products.Product, error) {
-	builder := productsPatterns.UpdateProductBuilder(r.db, req, r.filesUsecase)
+	builder := productsPatterns.UpdateProductBuilder(r.db, req)
 	engineer :=  productsPatterns.UpdateProductEngineer(builder)
 
 	if err := engineer.UpdateProduct(); err != nil {
@@ -137,16 +193,121 @@ func (r *productsRepository) UpdateProduct(req *products.Product) (*products.Pro
 }
 
 func (r *productsRepository) DeleteProduct(productId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// The images become orphans, the sweeper removes them from storage
+	if err := filesPatterns.DetachProductFiles(ctx, tx, productId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	query := `
 		DELETE FROM "products" WHERE "id" = $1;
 	`
-	if _, err := r.db.ExecContext(
-		context.Background(),
+	if _, err := tx.ExecContext(
+		ctx,
 		query,
 		productId,
 	); err != nil {
+		tx.Rollback()
 		return fmt.Errorf("delete product failed: %v", err)
 	}
-	
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (r *productsRepository) AdjustStock(req *products.StockAdjustReq) (*products.StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := &products.StockMovement{
+		ProductId: req.ProductId,
+		QtyChange: req.QtyChange,
+		Reason:    req.Reason,
+		CreatedBy: &req.CreatedBy,
+	}
+
+	if req.VariantId != "" {
+		movement.VariantId = &req.VariantId
+		if _, err := productsPatterns.LockVariantStock(ctx, tx, req.ProductId, req.VariantId); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	} else {
+		stock, err := productsPatterns.LockProductsStock(ctx, tx, []string{req.ProductId})
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if _, ok := stock[req.ProductId]; !ok {
+			tx.Rollback()
+			return nil, fmt.Errorf("%w: %s", products.ErrProductNotFound, req.ProductId)
+		}
+	}
+	if err := productsPatterns.ChangeStock(ctx, tx, movement); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+func (r *productsRepository) FindStockMovement(req *products.StockMovementFilter) ([]*products.StockMovement, int) {
+	query := `
+	SELECT
+		"id",
+		"product_id",
+		"variant_id",
+		"qty_change",
+		"stock_after",
+		"reason",
+		"order_id",
+		"created_by",
+		"created_at"
+	FROM "stock_movements"
+	WHERE "product_id" = $1
+	ORDER BY "created_at" DESC
+	OFFSET $2 LIMIT $3;`
+
+	movements := make([]*products.StockMovement, 0)
+	if err := r.db.Select(
+		&movements,
+		query,
+		req.ProductId,
+		(req.Page-1)*req.Limit,
+		req.Limit,
+	); err != nil {
+		log.Printf("find stock movements failed: %v\n", err)
+		return make([]*products.StockMovement, 0), 0
+	}
+
+	queryCount := `
+	SELECT
+		COUNT(*) AS "count"
+	FROM "stock_movements"
+	WHERE "product_id" = $1;`
+
+	var count int
+	if err := r.db.Get(&count, queryCount, req.ProductId); err != nil {
+		log.Printf("count stock movements failed: %v\n", err)
+		return movements, 0
+	}
+	return movements, count
+}
@@ -11,9 +11,12 @@ import (
 type IProductsUsecase interface {
 	FindOneProduct(productId string) (*products.Product, error)
 	FindProduct(req *products.ProductFilter) *entities.PaginateRes
+	FindProductByCursor(req *products.ProductFilter) (*entities.CursorPaginateRes, error)
 	AddProduct(req *products.Product) (*products.Product, error)
 	UpdateProduct(req  *products.Product) (*products.Product, error) 
 	DeleteProduct(productId string) error
+	AdjustStock(req *products.StockAdjustReq) (*products.StockMovement, error)
+	FindStockMovement(req *products.StockMovementFilter) *entities.PaginateRes
 }
 
 type productsUsecase struct {
@@ -43,9 +46,28 @@ func (u *productsUsecase) FindProduct(req *products.ProductFilter) *entities.Pag
 		Limit: req.Limit,
 		TotalItem: count,
 		TotalPage: int(math.Ceil(float64(count) / float64(req.Limit))),
+		Facets: u.productsRepository.FindProductFacets(req),
 	}
 }
 
+func (u *productsUsecase) FindProductByCursor(req *products.ProductFilter) (*entities.CursorPaginateRes, error) {
+	products, next, prev, err := u.productsRepository.FindProductByCursor(req)
+	if err != nil {
+		return nil, err
+	}
+	res := &entities.CursorPaginateRes{
+		Data:       products,
+		Limit:      req.Limit,
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+	// The facets do not change from page to page, only the first one has them
+	if req.Keyset == nil {
+		res.Facets = u.productsRepository.FindProductFacets(req)
+	}
+	return res, nil
+}
+
 func (u *productsUsecase) AddProduct(req *products.Product) (*products.Product, error) {
 	product, err := u.productsRepository.InsertProduct(req)
 	if err != nil  {
@@ -67,4 +89,23 @@ func (u *productsUsecase) DeleteProduct(productId string) error {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (u *productsUsecase) AdjustStock(req *products.StockAdjustReq) (*products.StockMovement, error) {
+	movement, err := u.productsRepository.AdjustStock(req)
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+func (u *productsUsecase) FindStockMovement(req *products.StockMovementFilter) *entities.PaginateRes {
+	movements, count := u.productsRepository.FindStockMovement(req)
+	return &entities.PaginateRes{
+		Data:      movements,
+		Page:      req.Page,
+		Limit:     req.Limit,
+		TotalItem: count,
+		TotalPage: int(math.Ceil(float64(count) / float64(req.Limit))),
+	}
+}
@@ -0,0 +1,135 @@
+package roles
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Permissions a role may grant, a route asks for the ones it needs
+const (
+	PermProductsWrite    = "products:write"
+	PermStockRead        = "stock:read"
+	PermStockWrite       = "stock:write"
+	PermCategoriesWrite  = "categories:write"
+	PermOrdersReadAll    = "orders:read_all"
+	PermOrdersWriteAll   = "orders:write_all"
+	PermFilesUpload      = "files:upload"
+	PermFilesReadAll     = "files:read_all"
+	PermFilesDelete      = "files:delete"
+	PermUsersReadAll     = "users:read_all"
+	PermUsersWriteAll    = "users:write_all"
+	PermUsersCreateAdmin = "users:create_admin"
+	PermApiKeysManage    = "apikeys:manage"
+	PermRolesManage      = "roles:manage"
+)
+
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var PermissionList = []*Permission{
+	{PermProductsWrite, "Add, update and delete products"},
+	{PermStockRead, "See the stock movements of products"},
+	{PermStockWrite, "Adjust the stock of products"},
+	{PermCategoriesWrite, "Add and delete categories"},
+	{PermOrdersReadAll, "See the orders of every user"},
+	{PermOrdersWriteAll, "Place and update orders for any user"},
+	{PermFilesUpload, "Upload files anywhere, not only transfer slips"},
+	{PermFilesReadAll, "Download the private files of every user"},
+	{PermFilesDelete, "Delete the files of every user"},
+	{PermUsersReadAll, "See the profile, sessions and security events of every user"},
+	{PermUsersWriteAll, "Manage the sessions and sign in of every user"},
+	{PermUsersCreateAdmin, "Sign up admins"},
+	{PermApiKeysManage, "Create, list and revoke api keys"},
+	{PermRolesManage, "Manage roles and assign them to users"},
+}
+
+func IsPermission(name string) bool {
+	for _, p := range PermissionList {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The roles sign up assigns, they cannot be deleted
+const (
+	CustomerRoleId = 1
+	AdminRoleId    = 2
+)
+
+var (
+	ErrRoleNotFound   = errors.New("role not found")
+	ErrRoleTitleTaken = errors.New("role title has been used")
+	ErrRoleBuiltIn    = errors.New("built-in roles cannot be deleted")
+	ErrRoleInUse      = errors.New("role is still assigned to users")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrNoRolesManager = errors.New("at least one user has to keep the " + PermRolesManage + " permission")
+)
+
+type Role struct {
+	Id          int      `db:"id" json:"id"`
+	Title       string   `db:"title" json:"title"`
+	Permissions []string `db:"permissions" json:"permissions"`
+	Users       int      `db:"users" json:"users"` // how many users hold the role
+}
+
+type RoleReq struct {
+	Title       string   `json:"title" form:"title"`
+	Permissions []string `json:"permissions" form:"permissions"`
+}
+
+// Validate checks the title and that every permission is known
+func (obj *RoleReq) Validate() error {
+	obj.Title = strings.TrimSpace(obj.Title)
+	if obj.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	return validatePermissions(&obj.Permissions)
+}
+
+type RolePermissionsReq struct {
+	Permissions []string `json:"permissions" form:"permissions"`
+}
+
+func (obj *RolePermissionsReq) Validate() error {
+	return validatePermissions(&obj.Permissions)
+}
+
+// validatePermissions refuses unknown permissions, a missing list becomes an
+// empty one as the column is not null
+func validatePermissions(permissions *[]string) error {
+	if *permissions == nil {
+		*permissions = []string{}
+	}
+	for _, p := range *permissions {
+		if !IsPermission(p) {
+			return fmt.Errorf("permission %q is unknown", p)
+		}
+	}
+	return nil
+}
+
+// Permissions is the set a signed in user was granted through their role
+type Permissions map[string]struct{}
+
+func NewPermissions(names []string) Permissions {
+	p := make(Permissions, len(names))
+	for _, name := range names {
+		p[name] = struct{}{}
+	}
+	return p
+}
+
+// Has tells whether every permission asked for is granted
+func (p Permissions) Has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := p[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package rolesHandlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jetsadawwts/go-restapi/config"
+	"github.com/jetsadawwts/go-restapi/modules/entities"
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/modules/roles/rolesUsecases"
+)
+
+type rolesHandlersErrCode string
+
+const (
+	findRolesErr         rolesHandlersErrCode = "roles-001"
+	insertRoleErr        rolesHandlersErrCode = "roles-002"
+	updatePermissionsErr rolesHandlersErrCode = "roles-003"
+	deleteRoleErr        rolesHandlersErrCode = "roles-004"
+	assignRoleErr        rolesHandlersErrCode = "roles-005"
+)
+
+type IRolesHandler interface {
+	FindRoles(c *fiber.Ctx) error
+	FindPermissions(c *fiber.Ctx) error
+	InsertRole(c *fiber.Ctx) error
+	UpdateRolePermissions(c *fiber.Ctx) error
+	DeleteRole(c *fiber.Ctx) error
+	AssignRole(c *fiber.Ctx) error
+}
+
+type rolesHandler struct {
+	cfg          config.IConfig
+	rolesUsecase rolesUsecases.IRolesUsecase
+}
+
+func RolesHandler(cfg config.IConfig, rolesUsecase rolesUsecases.IRolesUsecase) IRolesHandler {
+	return &rolesHandler{cfg: cfg, rolesUsecase: rolesUsecase}
+}
+
+// errStatus maps the errors of the roles usecase to a status code
+func errStatus(err error) int {
+	switch {
+	case errors.Is(err, roles.ErrRoleNotFound), errors.Is(err, roles.ErrUserNotFound):
+		return fiber.ErrNotFound.Code
+	case errors.Is(err, roles.ErrRoleTitleTaken),
+		errors.Is(err, roles.ErrRoleBuiltIn),
+		errors.Is(err, roles.ErrRoleInUse),
+		errors.Is(err, roles.ErrNoRolesManager):
+		return fiber.ErrConflict.Code
+	}
+	return fiber.ErrInternalServerError.Code
+}
+
+func parseRoleId(c *fiber.Ctx) (int, bool) {
+	roleId, err := strconv.Atoi(strings.Trim(c.Params("role_id"), " "))
+	if err != nil || roleId <= 0 {
+		return 0, false
+	}
+	return roleId, true
+}
+
+func (h *rolesHandler) FindRoles(c *fiber.Ctx) error {
+	result, err := h.rolesUsecase.FindRoles()
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(findRolesErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
+}
+
+// FindPermissions lists every permission a role may grant
+func (h *rolesHandler) FindPermissions(c *fiber.Ctx) error {
+	return entities.NewResponse(c).Success(fiber.StatusOK, roles.PermissionList).Res()
+}
+
+func (h *rolesHandler) InsertRole(c *fiber.Ctx) error {
+	req := new(roles.RoleReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(insertRoleErr),
+			err.Error(),
+		).Res()
+	}
+	if err := req.Validate(); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(insertRoleErr),
+			err.Error(),
+		).Res()
+	}
+
+	role, err := h.rolesUsecase.InsertRole(req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			errStatus(err),
+			string(insertRoleErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusCreated, role).Res()
+}
+
+func (h *rolesHandler) UpdateRolePermissions(c *fiber.Ctx) error {
+	roleId, ok := parseRoleId(c)
+	if !ok {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(updatePermissionsErr),
+			"role id is invalid",
+		).Res()
+	}
+
+	req := new(roles.RolePermissionsReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(updatePermissionsErr),
+			err.Error(),
+		).Res()
+	}
+	if err := req.Validate(); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(updatePermissionsErr),
+			err.Error(),
+		).Res()
+	}
+
+	role, err := h.rolesUsecase.UpdateRolePermissions(roleId, req)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			errStatus(err),
+			string(updatePermissionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, role).Res()
+}
+
+func (h *rolesHandler) DeleteRole(c *fiber.Ctx) error {
+	roleId, ok := parseRoleId(c)
+	if !ok {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(deleteRoleErr),
+			"role id is invalid",
+		).Res()
+	}
+
+	if err := h.rolesUsecase.DeleteRole(roleId); err != nil {
+		return entities.NewResponse(c).Error(
+			errStatus(err),
+			string(deleteRoleErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(
+		fiber.StatusOK,
+		&struct {
+			RoleId int `json:"role_id"`
+		}{
+			RoleId: roleId,
+		},
+	).Res()
+}
+
+func (h *rolesHandler) AssignRole(c *fiber.Ctx) error {
+	roleId, ok := parseRoleId(c)
+	if !ok {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(assignRoleErr),
+			"role id is invalid",
+		).Res()
+	}
+	userId := strings.Trim(c.Params("user_id"), " ")
+
+	if err := h.rolesUsecase.AssignRole(userId, roleId); err != nil {
+		return entities.NewResponse(c).Error(
+			errStatus(err),
+			string(assignRoleErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(
+		fiber.StatusOK,
+		&struct {
+			UserId string `json:"user_id"`
+			RoleId int    `json:"role_id"`
+		}{
+			UserId: userId,
+			RoleId: roleId,
+		},
+	).Res()
+}
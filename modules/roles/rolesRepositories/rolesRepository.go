@@ -0,0 +1,252 @@
+package rolesRepositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jmoiron/sqlx"
+)
+
+type IRolesRepository interface {
+	FindRoles() ([]*roles.Role, error)
+	FindOneRole(roleId int) (*roles.Role, error)
+	InsertRole(req *roles.RoleReq) (int, error)
+	UpdateRolePermissions(roleId int, permissions []string) error
+	DeleteRole(roleId int) error
+	AssignRole(userId string, roleId int) error
+}
+
+type rolesRepository struct {
+	db *sqlx.DB
+}
+
+func RolesRepository(db *sqlx.DB) IRolesRepository {
+	return &rolesRepository{db: db}
+}
+
+const roleColumns = `
+			"r"."id",
+			"r"."title",
+			"r"."permissions",
+			(
+				SELECT
+					COUNT(*)
+				FROM "users" "u"
+				WHERE "u"."role_id" = "r"."id"
+			) AS "users"`
+
+func (r *rolesRepository) FindRoles() ([]*roles.Role, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t")), '[]'::json)
+	FROM (
+		SELECT` + roleColumns + `
+		FROM "roles" "r"
+		ORDER BY "r"."id"
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query); err != nil {
+		return nil, fmt.Errorf("get roles failed: %v", err)
+	}
+
+	result := make([]*roles.Role, 0)
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal roles failed: %v", err)
+	}
+	return result, nil
+}
+
+func (r *rolesRepository) FindOneRole(roleId int) (*roles.Role, error) {
+	query := `
+	SELECT
+		to_jsonb("t")
+	FROM (
+		SELECT` + roleColumns + `
+		FROM "roles" "r"
+		WHERE "r"."id" = $1
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, roleId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, roles.ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("get role failed: %v", err)
+	}
+
+	role := new(roles.Role)
+	if err := json.Unmarshal(raw, role); err != nil {
+		return nil, fmt.Errorf("unmarshal role failed: %v", err)
+	}
+	return role, nil
+}
+
+func (r *rolesRepository) InsertRole(req *roles.RoleReq) (int, error) {
+	query := `
+	INSERT INTO "roles" (
+		"title",
+		"permissions"
+	)
+	VALUES ($1, $2::varchar[])
+	RETURNING "id";`
+
+	var roleId int
+	if err := r.db.QueryRowxContext(
+		context.Background(),
+		query,
+		req.Title,
+		req.Permissions,
+	).Scan(&roleId); err != nil {
+		if strings.Contains(err.Error(), `"roles_title_key"`) {
+			return 0, roles.ErrRoleTitleTaken
+		}
+		return 0, fmt.Errorf("insert role failed: %v", err)
+	}
+	return roleId, nil
+}
+
+// lockRoles serializes the changes of who may manage roles, two admins
+// taking the permission from each other at once would otherwise both succeed.
+func lockRoles(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `SELECT "id" FROM "roles" FOR UPDATE;`); err != nil {
+		return fmt.Errorf("lock roles failed: %v", err)
+	}
+	return nil
+}
+
+// checkRolesManager refuses a change leaving nobody able to manage roles
+func checkRolesManager(ctx context.Context, tx *sqlx.Tx) error {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM "users" "u"
+		JOIN "roles" "r" ON "r"."id" = "u"."role_id"
+		WHERE $1 = ANY("r"."permissions")
+	);`
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, query, roles.PermRolesManage); err != nil {
+		return fmt.Errorf("check roles manager failed: %v", err)
+	}
+	if !exists {
+		return roles.ErrNoRolesManager
+	}
+	return nil
+}
+
+func (r *rolesRepository) UpdateRolePermissions(roleId int, permissions []string) error {
+	ctx := context.Background()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := lockRoles(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	UPDATE "roles" SET
+		"permissions" = $2::varchar[]
+	WHERE "id" = $1;`
+
+	result, err := tx.ExecContext(ctx, query, roleId, permissions)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update role permissions failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return roles.ErrRoleNotFound
+	}
+	if err := checkRolesManager(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// DeleteRole only deletes a role nobody holds, the users of a role would be
+// deleted along with it.
+func (r *rolesRepository) DeleteRole(roleId int) error {
+	query := `
+	DELETE FROM "roles"
+	WHERE "id" = $1
+	AND NOT EXISTS (
+		SELECT 1
+		FROM "users"
+		WHERE "role_id" = $1
+	);`
+
+	result, err := r.db.ExecContext(context.Background(), query, roleId)
+	if err != nil {
+		return fmt.Errorf("delete role failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		if _, err := r.FindOneRole(roleId); err != nil {
+			return err
+		}
+		return roles.ErrRoleInUse
+	}
+	return nil
+}
+
+func (r *rolesRepository) AssignRole(userId string, roleId int) error {
+	ctx := context.Background()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := lockRoles(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM "roles" WHERE "id" = $1);`, roleId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get role failed: %v", err)
+	}
+	if !exists {
+		tx.Rollback()
+		return roles.ErrRoleNotFound
+	}
+
+	query := `
+	UPDATE "users" SET
+		"role_id" = $2
+	WHERE "id" = $1;`
+
+	result, err := tx.ExecContext(ctx, query, userId, roleId)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("assign role failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return roles.ErrUserNotFound
+	}
+	if err := checkRolesManager(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package rolesUsecases
+
+import (
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/modules/roles/rolesRepositories"
+)
+
+type IRolesUsecase interface {
+	FindRoles() ([]*roles.Role, error)
+	InsertRole(req *roles.RoleReq) (*roles.Role, error)
+	UpdateRolePermissions(roleId int, req *roles.RolePermissionsReq) (*roles.Role, error)
+	DeleteRole(roleId int) error
+	AssignRole(userId string, roleId int) error
+}
+
+type rolesUsecase struct {
+	rolesRepository rolesRepositories.IRolesRepository
+}
+
+func RolesUsecase(rolesRepository rolesRepositories.IRolesRepository) IRolesUsecase {
+	return &rolesUsecase{rolesRepository: rolesRepository}
+}
+
+func (u *rolesUsecase) FindRoles() ([]*roles.Role, error) {
+	result, err := u.rolesRepository.FindRoles()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (u *rolesUsecase) InsertRole(req *roles.RoleReq) (*roles.Role, error) {
+	roleId, err := u.rolesRepository.InsertRole(req)
+	if err != nil {
+		return nil, err
+	}
+	role, err := u.rolesRepository.FindOneRole(roleId)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// UpdateRolePermissions replaces the whole permission set of a role
+func (u *rolesUsecase) UpdateRolePermissions(roleId int, req *roles.RolePermissionsReq) (*roles.Role, error) {
+	if err := u.rolesRepository.UpdateRolePermissions(roleId, req.Permissions); err != nil {
+		return nil, err
+	}
+	role, err := u.rolesRepository.FindOneRole(roleId)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (u *rolesUsecase) DeleteRole(roleId int) error {
+	if roleId == roles.CustomerRoleId || roleId == roles.AdminRoleId {
+		return roles.ErrRoleBuiltIn
+	}
+	if err := u.rolesRepository.DeleteRole(roleId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AssignRole takes effect at the next request of the user, JwtAuth reads the
+// role of the user and not the one of the token.
+func (u *rolesUsecase) AssignRole(userId string, roleId int) error {
+	if err := u.rolesRepository.AssignRole(userId, roleId); err != nil {
+		return err
+	}
+	return nil
+}
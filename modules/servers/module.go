@@ -1,8 +1,11 @@
 package servers
 
 import (
+	"log"
+
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/jetsadawwts/go-restapi/modules/appinfo"
 	appinfohandlers "github.com/jetsadawwts/go-restapi/modules/appinfo/appinfoHandlers"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo/appinfoRepositories"
 	"github.com/jetsadawwts/go-restapi/modules/appinfo/appinfoUsecases"
@@ -11,6 +14,7 @@ import (
 	"github.com/jetsadawwts/go-restapi/modules/orders/ordersUsecases"
 
 	"github.com/jetsadawwts/go-restapi/modules/files/filesHandlers"
+	"github.com/jetsadawwts/go-restapi/modules/files/filesRepositories"
 	"github.com/jetsadawwts/go-restapi/modules/files/filesUsecases"
 
 	"github.com/jetsadawwts/go-restapi/modules/products/productsHandlers"
@@ -23,6 +27,11 @@ import (
 
 	"github.com/jetsadawwts/go-restapi/modules/monitors/monitorHandlers"
 
+	"github.com/jetsadawwts/go-restapi/modules/roles"
+	"github.com/jetsadawwts/go-restapi/modules/roles/rolesHandlers"
+	"github.com/jetsadawwts/go-restapi/modules/roles/rolesRepositories"
+	"github.com/jetsadawwts/go-restapi/modules/roles/rolesUsecases"
+
 	"github.com/jetsadawwts/go-restapi/modules/users/usersHandlers"
 	"github.com/jetsadawwts/go-restapi/modules/users/usersRepositories"
 	"github.com/jetsadawwts/go-restapi/modules/users/usersUsecases"
@@ -31,6 +40,7 @@ import (
 type IModuleFactory interface {
 	MonitorModule()
 	UsersModule()
+	RolesModule()
 	AppinfoModule()
 	FilesModule()
 	ProductsModule()
@@ -54,31 +64,73 @@ func InitModule(r fiber.Router, s *server, m middlewaresHandlers.IMiddlewaresHan
 func InitMiddlewares(s *server) middlewaresHandlers.IMiddlewaresHandler {
 	respository := middlewaresRepositories.MiddlewaresRepository(s.db)
 	usecase := middlewaresUsecases.MiddlewaresUsecase(respository)
-	return middlewaresHandlers.MiddlewaresHandler(s.cfg, usecase)
+	return middlewaresHandlers.MiddlewaresHandler(s.cfg, usecase, s.storage, s.keyset)
 
 }
 
 func (m *moduleFactory) MonitorModule() {
-	handler := monitorHandlers.MonitorHandler(m.s.cfg)
+	handler := monitorHandlers.MonitorHandler(m.s.cfg, m.s.keyset)
 
 	m.r.Get("/", handler.HealthCheck)
+
+	// Outside of v1 where other services look for it
+	m.s.app.Get("/.well-known/jwks.json", handler.Jwks)
 }
 
 func (m *moduleFactory) UsersModule() {
 	respository := usersRepositories.UsersRepository(m.s.db)
-	usecase := usersUsecases.UsersUsecase(m.s.cfg, respository)
-	handler := usersHandlers.UsersHandler(m.s.cfg, usecase)
+	usecase := usersUsecases.UsersUsecase(m.s.cfg, m.s.keyset, m.s.mailer, m.s.hasher, m.s.policy, respository)
+	handler := usersHandlers.UsersHandler(m.s.cfg, m.s.keyset, usecase)
+
+	// The authenticator secrets stored before TOTP_SECRET_KEY was set
+	if err := usecase.SealTotpSecrets(); err != nil {
+		log.Fatalf("encrypt totp secrets failed: %v", err)
+	}
 
 	router := m.r.Group("/users")
 
 	router.Post("/signup", m.m.ApiKeyAuth(), handler.SignUpCustomer)
-	router.Post("/signup-admin", m.m.JwtAuth(), m.m.Authorize(2), handler.SignUpAdmin)
+	router.Post("/signup-admin", m.m.JwtAuth(), m.m.RequirePermission(roles.PermUsersCreateAdmin), handler.SignUpAdmin)
 	router.Post("/signin", m.m.ApiKeyAuth(), handler.SignIn)
 	router.Post("/refresh", m.m.ApiKeyAuth(), handler.RefreshPassport)
-	router.Post("/signout", m.m.ApiKeyAuth(), handler.SignOut)
+	router.Post("/signout", m.m.ApiKeyAuth(), m.m.JwtAuth(), handler.SignOut)
+	router.Post("/forgot-password", m.m.ApiKeyAuth(), handler.ForgotPassword)
+	router.Post("/reset-password", m.m.ApiKeyAuth(), handler.ResetPassword)
+	router.Post("/verify-email", m.m.ApiKeyAuth(), handler.VerifyEmail)
+	router.Post("/signin/2fa", m.m.ApiKeyAuth(), handler.SignInTwoFactor)
+
+	router.Post("/2fa/setup", m.m.JwtAuth(), handler.SetupTotp)
+	router.Post("/2fa/enable", m.m.JwtAuth(), handler.EnableTotp)
+	router.Post("/2fa/disable", m.m.JwtAuth(), handler.DisableTotp)
+
+	router.Get("/admin/secret", m.m.JwtAuth(), m.m.RequirePermission(roles.PermUsersCreateAdmin), handler.GenerateAdminToken)
+	router.Get("/:user_id", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermUsersReadAll), handler.GetUserProfile)
+
+	// ParamsCheck lets the users granted the permission manage the sessions of any user
+	router.Get("/:user_id/sessions", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermUsersReadAll), handler.FindSessions)
+	router.Delete("/:user_id/sessions", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermUsersWriteAll), handler.DeleteSessions)
+	router.Delete("/:user_id/sessions/:session_id", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermUsersWriteAll), handler.DeleteSession)
+
+	router.Post("/:user_id/verify-email", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermUsersWriteAll), handler.ResendVerification)
+
+	router.Get("/:user_id/security-events", m.m.JwtAuth(), m.m.RequirePermission(roles.PermUsersReadAll), handler.FindSecurityEvents)
+	router.Post("/:user_id/unlock", m.m.JwtAuth(), m.m.RequirePermission(roles.PermUsersWriteAll), handler.UnlockUser)
 
-	router.Get("/admin/secret", m.m.JwtAuth(), m.m.Authorize(2), handler.GenerateAdminToken)
-	router.Get("/:user_id", m.m.JwtAuth(), m.m.ParamsCheck(), handler.GetUserProfile)
+}
+
+func (m *moduleFactory) RolesModule() {
+	repository := rolesRepositories.RolesRepository(m.s.db)
+	usecase := rolesUsecases.RolesUsecase(repository)
+	handler := rolesHandlers.RolesHandler(m.s.cfg, usecase)
+
+	router := m.r.Group("/roles")
+
+	router.Get("/", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.FindRoles)
+	router.Get("/permissions", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.FindPermissions)
+	router.Post("/", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.InsertRole)
+	router.Put("/:role_id/permissions", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.UpdateRolePermissions)
+	router.Delete("/:role_id", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.DeleteRole)
+	router.Put("/:role_id/users/:user_id", m.m.JwtAuth(), m.m.RequirePermission(roles.PermRolesManage), handler.AssignRole)
 
 }
 
@@ -87,54 +139,75 @@ func (m *moduleFactory) AppinfoModule() {
 	usecase := appinfoUsecases.AppinfoUsecase(respository)
 	handler := appinfohandlers.AppinfoHandler(m.s.cfg, usecase)
 
+	// A fresh deployment has no api key to sign in with yet
+	if err := usecase.SeedApiKey(m.s.cfg.Jwt().BootstrapApiKey()); err != nil {
+		log.Fatalf("seed bootstrap api key failed: %v", err)
+	}
+
 	router := m.r.Group("/appinfo")
-	router.Get("/apikey", m.m.JwtAuth(), m.m.Authorize(2), handler.GenerateApiKey)
-	router.Get("/categories", m.m.ApiKeyAuth(), handler.FindCategory)
+	router.Post("/apikeys", m.m.JwtAuth(), m.m.RequirePermission(roles.PermApiKeysManage), handler.CreateApiKey)
+	router.Get("/apikeys", m.m.JwtAuth(), m.m.RequirePermission(roles.PermApiKeysManage), handler.FindApiKeys)
+	router.Delete("/apikeys/:apikey_id", m.m.JwtAuth(), m.m.RequirePermission(roles.PermApiKeysManage), handler.RevokeApiKey)
+	router.Get("/categories", m.m.ApiKeyAuth(appinfo.ScopeCategoriesRead), handler.FindCategory)
 
-	router.Post("/categories", m.m.JwtAuth(), m.m.Authorize(2), handler.AddCategory)
-	router.Delete("/:category_id/categories", m.m.JwtAuth(), m.m.Authorize(2), handler.RemoveCategory)
+	router.Post("/categories", m.m.JwtAuth(), m.m.RequirePermission(roles.PermCategoriesWrite), handler.AddCategory)
+	router.Delete("/:category_id/categories", m.m.JwtAuth(), m.m.RequirePermission(roles.PermCategoriesWrite), handler.RemoveCategory)
 
 }
 
 func (m *moduleFactory) FilesModule() {
-	usecase := filesUsecases.FilesUsecase(m.s.cfg)
+	repository := filesRepositories.FilesRepository(m.s.db)
+	usecase := filesUsecases.FilesUsecase(m.s.cfg, m.s.storage, m.s.signer, repository)
 	handler := filesHandlers.FilesHandler(m.s.cfg, usecase)
 	router := m.r.Group("/files")
 
-	router.Post("/upload", m.m.JwtAuth(), m.m.Authorize(2), handler.UploadFiles)
-	router.Patch("/delete", m.m.JwtAuth(), m.m.Authorize(2), handler.DeleteFiles)
+	// Unreferenced files are removed in the background
+	go usecase.RunSweeper(m.s.ctx)
+
+	router.Post("/upload", m.m.JwtAuth(), m.m.RequirePermission(roles.PermFilesUpload), handler.UploadFiles)
+	router.Patch("/delete", m.m.JwtAuth(), handler.DeleteFiles)
+	router.Get("/download/*", m.m.JwtAuth(), handler.DownloadFile)
+
+	// Resumable uploads, tus protocol
+	router.Options("/uploads", handler.UploadOptions)
+	router.Post("/uploads", m.m.JwtAuth(), handler.CreateUpload)
+	router.Head("/uploads/:upload_id", m.m.JwtAuth(), handler.UploadStatus)
+	router.Get("/uploads/:upload_id", m.m.JwtAuth(), handler.FindUpload)
+	router.Patch("/uploads/:upload_id", m.m.JwtAuth(), handler.UploadChunk)
+	router.Delete("/uploads/:upload_id", m.m.JwtAuth(), handler.DeleteUpload)
 
 }
 
 func (m *moduleFactory) ProductsModule() {
-	filesUsecase := filesUsecases.FilesUsecase(m.s.cfg)
-
-	productsRespository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, filesUsecase)
+	productsRespository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg)
 	productsUsecase := productsUsecases.ProductsUsecase(productsRespository)
-	productsHandler := productsHandlers.ProductsHandler(m.s.cfg, productsUsecase, filesUsecase)
+	productsHandler := productsHandlers.ProductsHandler(m.s.cfg, productsUsecase)
 
 	router := m.r.Group("/products")
 	
-	router.Get("/", m.m.ApiKeyAuth(), productsHandler.FindProduct)
-	router.Get("/:product_id", m.m.ApiKeyAuth(), productsHandler.FindOneProduct)
-	router.Post("/", m.m.JwtAuth(), m.m.Authorize(2), productsHandler.AddProduct)
-	router.Patch("/:product_id", m.m.JwtAuth(), m.m.Authorize(2), productsHandler.UpdateProduct)
-	router.Delete("/:product_id", m.m.JwtAuth(), m.m.Authorize(2), productsHandler.DeleteProduct)
+	router.Get("/", m.m.ApiKeyAuth(appinfo.ScopeProductsRead), productsHandler.FindProduct)
+	router.Get("/:product_id", m.m.ApiKeyAuth(appinfo.ScopeProductsRead), productsHandler.FindOneProduct)
+	router.Post("/", m.m.JwtAuth(), m.m.RequirePermission(roles.PermProductsWrite), productsHandler.AddProduct)
+	router.Patch("/:product_id", m.m.JwtAuth(), m.m.RequirePermission(roles.PermProductsWrite), productsHandler.UpdateProduct)
+	router.Delete("/:product_id", m.m.JwtAuth(), m.m.RequirePermission(roles.PermProductsWrite), productsHandler.DeleteProduct)
+
+	router.Get("/:product_id/stock", m.m.JwtAuth(), m.m.RequirePermission(roles.PermStockRead), productsHandler.FindStockMovement)
+	router.Patch("/:product_id/stock", m.m.JwtAuth(), m.m.RequirePermission(roles.PermStockWrite), productsHandler.AdjustStock)
 
 }
 
 func (m *moduleFactory) OrdersModule() {
-	filesUsecase := filesUsecases.FilesUsecase(m.s.cfg)
-	productsRepository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg, filesUsecase)
+	productsRepository := productsRepositories.ProductsRepository(m.s.db, m.s.cfg)
 	ordersRepository := ordersRepositories.OrdersRepository(m.s.db)
-	ordersUsecase := ordersUsecases.OrdersUsecase(ordersRepository, productsRepository)
+	ordersUsecase := ordersUsecases.OrdersUsecase(ordersRepository, productsRepository, m.s.signer)
 	ordersHandler := ordersHandlers.OrdersHandler(m.s.cfg, ordersUsecase)
 
 	router := m.r.Group("/orders")
 
-	router.Get("/:user_id/:order_id", m.m.JwtAuth(), m.m.ParamsCheck(), ordersHandler.FindOneOrder)
-	router.Get("/", m.m.JwtAuth(), m.m.Authorize(2), ordersHandler.FindOrder)
-	router.Post("/", m.m.JwtAuth(), ordersHandler.InsertOrder)
-	router.Patch("/:user_id/:order_id", m.m.JwtAuth(), m.m.ParamsCheck(), ordersHandler.UpdateOrder)
+	router.Get("/:user_id/:order_id/history", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermOrdersReadAll), ordersHandler.FindOrderStatusHistory)
+	router.Get("/:user_id/:order_id", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermOrdersReadAll), ordersHandler.FindOneOrder)
+	router.Get("/", m.m.JwtAuth(), m.m.RequirePermission(roles.PermOrdersReadAll), ordersHandler.FindOrder)
+	router.Post("/", m.m.JwtAuth(), m.m.VerifiedEmail(), ordersHandler.InsertOrder)
+	router.Patch("/:user_id/:order_id", m.m.JwtAuth(), m.m.ParamsCheck(roles.PermOrdersWriteAll), ordersHandler.UpdateOrder)
 
 }
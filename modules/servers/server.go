@@ -1,6 +1,7 @@
 package servers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
@@ -8,6 +9,10 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jetsadawwts/go-restapi/config"
+	"github.com/jetsadawwts/go-restapi/pkg/auth"
+	"github.com/jetsadawwts/go-restapi/pkg/mailer"
+	"github.com/jetsadawwts/go-restapi/pkg/password"
+	"github.com/jetsadawwts/go-restapi/pkg/storage"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -16,15 +21,28 @@ type IServer interface {
 }
 
 type server struct {
-	app *fiber.App
-	cfg config.IConfig
-	db  *sqlx.DB
+	app     *fiber.App
+	ctx     context.Context
+	cfg     config.IConfig
+	db      *sqlx.DB
+	storage storage.IStorage
+	signer  storage.ISigner
+	keyset  auth.IKeyset
+	mailer  mailer.IMailer
+	hasher  password.IHasher
+	policy  password.IPolicy
 }
 
-func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
+func NewServer(cfg config.IConfig, db *sqlx.DB, storage storage.IStorage, signer storage.ISigner, keyset auth.IKeyset, mailer mailer.IMailer, hasher password.IHasher, policy password.IPolicy) IServer {
 	return &server{
-		cfg: cfg,
-		db:  db,
+		cfg:     cfg,
+		db:      db,
+		storage: storage,
+		signer:  signer,
+		keyset:  keyset,
+		mailer:  mailer,
+		hasher:  hasher,
+		policy:  policy,
 		app: fiber.New(fiber.Config{
 			AppName:      cfg.App().Name(),
 			BodyLimit:    cfg.App().BodyLimit(),
@@ -32,13 +50,29 @@ func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
 			WriteTimeout: cfg.App().WriteTimeout(),
 			JSONEncoder:  json.Marshal,
 			JSONDecoder:  json.Unmarshal,
+			// Bodies are read as handlers consume them, the BodyLimit middleware
+			// enforces the limit since fasthttp no longer does once streaming
+			StreamRequestBody:            true,
+			DisablePreParseMultipartForm: true,
+			// Slice query params also take comma separated values, e.g. ?category_id=1,2
+			EnableSplittingOnParsers: true,
+			// c.IP() only reads the proxy header of the trusted proxies
+			ProxyHeader:             cfg.App().ProxyHeader(),
+			EnableTrustedProxyCheck: cfg.App().ProxyHeader() != "",
+			TrustedProxies:          cfg.App().TrustedProxies(),
+			EnableIPValidation:      true,
 		}),
 	}
 }
 
 func (s *server) Start() {
+	// Background work stops once the server shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx = ctx
+
 	//Middlewares
 	m := InitMiddlewares(s)
+	s.app.Use(m.BodyLimit())
 	s.app.Use(m.Logger())
 	s.app.Use(m.Cors())
 	s.app.Use(m.StreamingFile())
@@ -50,6 +84,7 @@ func (s *server) Start() {
 
 	modules.MonitorModule()
 	modules.UsersModule()
+	modules.RolesModule()
 	modules.AppinfoModule()
 	modules.FilesModule()
 	modules.ProductsModule()
@@ -63,6 +98,7 @@ func (s *server) Start() {
 	go func() {
 		_ = <-c
 		log.Println("server is shutting down...")
+		cancel()
 		_ = s.app.Shutdown()
 	}()
 
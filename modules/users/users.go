@@ -1,17 +1,19 @@
 package users
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"regexp"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type User struct {
-	Id       string `db:"id" json:"id"`
-	Email    string `db:"email" json:"email"`
-	Username string `db:"username" json:"username"`
-	RoleId   int    `db:"role_id" json:"role_id"`
+	Id            string `db:"id" json:"id"`
+	Email         string `db:"email" json:"email"`
+	Username      string `db:"username" json:"username"`
+	RoleId        int    `db:"role_id" json:"role_id"`
+	EmailVerified bool   `db:"email_verified" json:"email_verified"`
 }
 
 type UserRegisterReq struct {
@@ -26,20 +28,13 @@ type UserCredential struct {
 }
 
 type UserCredentialCheck struct {
-	Id       string `db:"id"`
-	Email    string `db:"email"`
-	Password string `db:"password"`
-	Username string `db:"username"`
-	RoleId   int    `db:"role_id"`
-}
-
-func (obj *UserRegisterReq) BcryptHashing() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(obj.Password), 10)
-	if err != nil {
-		return fmt.Errorf("hashed password failed: %v", err)
-	}
-	obj.Password = string(hashedPassword)
-	return nil
+	Id            string `db:"id"`
+	Email         string `db:"email"`
+	Password      string `db:"password"`
+	Username      string `db:"username"`
+	RoleId        int    `db:"role_id"`
+	EmailVerified bool   `db:"email_verified"`
+	TotpEnabled   bool   `db:"totp_enabled"`
 }
 
 func (obj *UserRegisterReq) IsEmail() bool {
@@ -50,9 +45,12 @@ func (obj *UserRegisterReq) IsEmail() bool {
 	return match
 }
 
+// UserPassport holds the tokens of a sign in, or only a challenge token when
+// the user still has to give a second factor.
 type UserPassport struct {
-	User  *User      `json:"user"`
-	Token *UserToken `json:"token"`
+	User           *User      `json:"user"`
+	Token          *UserToken `json:"token"`
+	ChallengeToken string     `json:"challenge_token,omitempty"`
 }
 
 type UserToken struct {
@@ -70,11 +68,142 @@ type UserRefreshCredential struct {
 	RefreshToken string `json:"refresh_token" form:"refresh_token"`
 }
 
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrOauthNotFound       = errors.New("oauth not found.")
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, every session of its sign in has been revoked")
+	ErrResetTokenInvalid   = errors.New("reset token is invalid or has expired")
+	ErrResetThrottled      = errors.New("a reset mail was sent recently, try again later")
+	// Verification
+	ErrVerificationTokenInvalid = errors.New("verification token is invalid or has expired")
+	ErrEmailAlreadyVerified     = errors.New("email has already been verified")
+	ErrVerificationThrottled    = errors.New("a verification mail was sent recently, try again later")
+	// Two-factor
+	ErrTotpNotSetUp         = errors.New("two-factor authentication has not been set up")
+	ErrTotpAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTotpNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrTotpRequired         = errors.New("two-factor authentication is mandatory for admins")
+	ErrTotpUnavailable      = errors.New("two-factor authentication is not configured on this server")
+	ErrTwoFactorCodeInvalid = errors.New("two-factor code is invalid")
+	// Brute force
+	ErrAccountLocked  = errors.New("account is locked after too many failed sign ins")
+	ErrTooManySignIns = errors.New("too many failed sign ins")
+)
+
+const (
+	// SecurityEventRefreshTokenReuse is recorded when a used refresh token comes back
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventPasswordReset     = "password_reset"
+	SecurityEventTotpEnabled       = "totp_enabled"
+	SecurityEventTotpDisabled      = "totp_disabled"
+	SecurityEventRecoveryCodeUsed  = "recovery_code_used"
+	SecurityEventSignInFailed      = "sign_in_failed"
+	SecurityEventAccountLocked     = "account_locked"
+	SecurityEventAccountUnlocked   = "account_unlocked"
+	SecurityEventIpBlocked         = "ip_blocked" // recorded without a user
+)
+
 type Oauth struct {
-	Id     string `db:"id" json:"id"`
-	UserId string `db:"user_id" json:"user_id"`
+	Id        string     `db:"id" json:"id"`
+	UserId    string     `db:"user_id" json:"user_id"`
+	FamilyId  string     `db:"family_id" json:"family_id"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+// Device is where a sign in or a refresh came from
+type Device struct {
+	UserAgent string
+	Ip        string
+}
+
+// Session is a sign in and the refreshes that followed it, its id is the
+// family of its oauth.
+type Session struct {
+	Id         string `db:"id" json:"id"`
+	UserAgent  string `db:"user_agent" json:"user_agent"`
+	Ip         string `db:"ip" json:"ip"`
+	CreatedAt  string `db:"created_at" json:"created_at"`
+	LastUsedAt string `db:"last_used_at" json:"last_used_at"`
+	Current    bool   `db:"current" json:"current"` // the session of the caller
+}
+
+type SecurityEvent struct {
+	Id        string         `db:"id" json:"id"`
+	UserId    string         `db:"user_id" json:"user_id"` // empty for events of no user
+	Event     string         `db:"event" json:"event"`
+	Details   map[string]any `db:"details" json:"details"`
+	CreatedAt string         `db:"created_at" json:"created_at"`
 }
 
 type UserRemoveCredential struct {
 	OauthId string `json:"oauth_id" form:"oauth_id"`
 }
+
+type ForgotPasswordReq struct {
+	Email string `json:"email" form:"email"`
+}
+
+type ResetPasswordReq struct {
+	Token    string `json:"token" form:"token"`
+	Password string `json:"password" form:"password"`
+}
+
+type VerifyEmailReq struct {
+	Token string `json:"token" form:"token"`
+}
+
+// Totp is the authenticator of a user, the secret is set at setup and only
+// asked for once enabled.
+type Totp struct {
+	Secret   *string `db:"totp_secret"`
+	Enabled  bool    `db:"totp_enabled"`
+	LastStep int64   `db:"totp_last_step"` // of the last code accepted
+}
+
+// TotpSecret is a secret stored before the secrets were encrypted
+type TotpSecret struct {
+	UserId string `db:"id"`
+	Secret string `db:"totp_secret"`
+}
+
+type TotpSetup struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"` // otpauth uri, usually shown as a qr code
+}
+
+// TwoFactorReq holds a code of the authenticator or a recovery code
+type TwoFactorReq struct {
+	Code string `json:"code" form:"code"`
+}
+
+type TwoFactorSignInReq struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token"`
+	Code           string `json:"code" form:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// LoginThrottle is the failed sign ins of an account or an ip
+type LoginThrottle struct {
+	Failures   int     `db:"failures"`
+	RetryAfter float64 `db:"retry_after"` // seconds left locked, 0 when not locked
+}
+
+// SignInThrottledError refuses a sign in without checking its credentials,
+// it wraps ErrAccountLocked or ErrTooManySignIns.
+type SignInThrottledError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *SignInThrottledError) Error() string {
+	return fmt.Sprintf("%v, try again in %d seconds", e.Err, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+func (e *SignInThrottledError) Unwrap() error {
+	return e.Err
+}
@@ -1,14 +1,20 @@
 package usersHandlers
 
 import (
+	"errors"
+	"math"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/entities"
 	"github.com/jetsadawwts/go-restapi/modules/users"
 	"github.com/jetsadawwts/go-restapi/modules/users/usersUsecases"
 	"github.com/jetsadawwts/go-restapi/pkg/auth"
+	"github.com/jetsadawwts/go-restapi/pkg/password"
 )
 
 type userHandlerErrCode string
@@ -21,6 +27,21 @@ const (
 	SignUpAdminErr        userHandlerErrCode = "users-005"
 	GenerateAdminTokenErr userHandlerErrCode = "users-006"
 	GetUserProfileErr     userHandlerErrCode = "users-007"
+	RefreshTokenReusedErr userHandlerErrCode = "users-008"
+	FindSessionsErr       userHandlerErrCode = "users-009"
+	DeleteSessionErr      userHandlerErrCode = "users-010"
+	DeleteSessionsErr     userHandlerErrCode = "users-011"
+	ForgotPasswordErr     userHandlerErrCode = "users-012"
+	ResetPasswordErr      userHandlerErrCode = "users-013"
+	VerifyEmailErr        userHandlerErrCode = "users-014"
+	ResendVerificationErr userHandlerErrCode = "users-015"
+	SignInTwoFactorErr    userHandlerErrCode = "users-016"
+	SetupTotpErr          userHandlerErrCode = "users-017"
+	EnableTotpErr         userHandlerErrCode = "users-018"
+	DisableTotpErr        userHandlerErrCode = "users-019"
+	AccountLockedErr      userHandlerErrCode = "users-020"
+	FindSecurityEventsErr userHandlerErrCode = "users-021"
+	UnlockUserErr         userHandlerErrCode = "users-022"
 )
 
 type IUsersHandler interface {
@@ -31,20 +52,55 @@ type IUsersHandler interface {
 	SignUpAdmin(c *fiber.Ctx) error
 	GenerateAdminToken(c *fiber.Ctx) error
 	GetUserProfile(c *fiber.Ctx) error
+	FindSessions(c *fiber.Ctx) error
+	DeleteSession(c *fiber.Ctx) error
+	DeleteSessions(c *fiber.Ctx) error
+	ForgotPassword(c *fiber.Ctx) error
+	ResetPassword(c *fiber.Ctx) error
+	VerifyEmail(c *fiber.Ctx) error
+	ResendVerification(c *fiber.Ctx) error
+	SignInTwoFactor(c *fiber.Ctx) error
+	SetupTotp(c *fiber.Ctx) error
+	EnableTotp(c *fiber.Ctx) error
+	DisableTotp(c *fiber.Ctx) error
+	FindSecurityEvents(c *fiber.Ctx) error
+	UnlockUser(c *fiber.Ctx) error
 }
 
 type usersHandler struct {
 	cfg          config.IConfig
+	keyset       auth.IKeyset
 	usersUsecase usersUsecases.IUsersUsecase
 }
 
-func UsersHandler(cfg config.IConfig, usersUsecase usersUsecases.IUsersUsecase) IUsersHandler {
+func UsersHandler(cfg config.IConfig, keyset auth.IKeyset, usersUsecase usersUsecases.IUsersUsecase) IUsersHandler {
 	return &usersHandler{
 		cfg:          cfg,
+		keyset:       keyset,
 		usersUsecase: usersUsecase,
 	}
 }
 
+// maxUserAgentLen keeps a forged header from bloating the oauth table
+const maxUserAgentLen = 512
+
+// device tells where the request came from
+func device(c *fiber.Ctx) *users.Device {
+	// Postgres refuses text that is not valid utf-8, a cut rune included
+	userAgent := strings.ToValidUTF8(c.Get(fiber.HeaderUserAgent), "")
+	if len(userAgent) > maxUserAgentLen {
+		n := maxUserAgentLen
+		for n > 0 && !utf8.RuneStart(userAgent[n]) {
+			n--
+		}
+		userAgent = userAgent[:n]
+	}
+	return &users.Device{
+		UserAgent: userAgent,
+		Ip:        c.IP(),
+	}
+}
+
 func (h *usersHandler) SignUpCustomer(c *fiber.Ctx) error {
 	//Request body parser
 	req := new(users.UserRegisterReq)
@@ -68,6 +124,13 @@ func (h *usersHandler) SignUpCustomer(c *fiber.Ctx) error {
 	//Insert
 	result, err := h.usersUsecase.InsertCustomer(req)
 	if err != nil {
+		if errors.Is(err, password.ErrPolicy) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(SignUpCustomerErr),
+				err.Error(),
+			).Res()
+		}
 		switch err.Error() {
 		case "username has been used":
 			return entities.NewResponse(c).Error(
@@ -116,6 +179,13 @@ func (h *usersHandler) SignUpAdmin(c *fiber.Ctx) error {
 	//Insert
 	result, err := h.usersUsecase.InsertAdmin(req)
 	if err != nil {
+		if errors.Is(err, password.ErrPolicy) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(SignUpAdminErr),
+				err.Error(),
+			).Res()
+		}
 		switch err.Error() {
 		case "username has been used":
 			return entities.NewResponse(c).Error(
@@ -142,18 +212,36 @@ func (h *usersHandler) SignUpAdmin(c *fiber.Ctx) error {
 }
 
 func (h *usersHandler) GenerateAdminToken(c *fiber.Ctx) error {
-	adminToken, err := auth.NewAuth(auth.Admin, h.cfg.Jwt(), nil)
+	adminToken, err := auth.NewAuth(auth.Admin, h.cfg.Jwt(), h.keyset, nil)
 	if err != nil {
 		return entities.NewResponse(c).Error(fiber.ErrInternalServerError.Code,
 			string(GenerateAdminTokenErr), err.Error()).Res()
 	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, &struct {
-		Token string `json: "token"`
+		Token string `json:"token"`
 	}{
 		Token: adminToken.SignToken(),
 	}).Res()
 }
 
+// signInThrottled refuses a sign in with the time left to wait, a locked
+// account has its own error code.
+func signInThrottled(c *fiber.Ctx, throttled *users.SignInThrottledError, errCode userHandlerErrCode) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	if errors.Is(throttled, users.ErrAccountLocked) {
+		return entities.NewResponse(c).Error(
+			fiber.StatusLocked,
+			string(AccountLockedErr),
+			throttled.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Error(
+		fiber.ErrTooManyRequests.Code,
+		string(errCode),
+		throttled.Error(),
+	).Res()
+}
+
 func (h *usersHandler) SignIn(c *fiber.Ctx) error {
 	req := new(users.UserCredential)
 	if err := c.BodyParser(req); err != nil {
@@ -164,8 +252,12 @@ func (h *usersHandler) SignIn(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	passport, err := h.usersUsecase.GetPassport(req)
+	passport, err := h.usersUsecase.GetPassport(req, device(c))
 	if err != nil {
+		var throttled *users.SignInThrottledError
+		if errors.As(err, &throttled) {
+			return signInThrottled(c, throttled, SignInErr)
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrBadRequest.Code,
 			string(SignInErr),
@@ -185,13 +277,28 @@ func (h *usersHandler) RefreshPassport(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	passport, err := h.usersUsecase.RefreshPassport(req)
+	passport, err := h.usersUsecase.RefreshPassport(req, device(c))
 	if err != nil {
-		return entities.NewResponse(c).Error(
-			fiber.ErrBadRequest.Code,
-			string(RefreshPassportErr),
-			err.Error(),
-		).Res()
+		switch {
+		case errors.Is(err, users.ErrRefreshTokenReused):
+			return entities.NewResponse(c).Error(
+				fiber.ErrUnauthorized.Code,
+				string(RefreshTokenReusedErr),
+				err.Error(),
+			).Res()
+		case errors.Is(err, users.ErrRefreshTokenRevoked):
+			return entities.NewResponse(c).Error(
+				fiber.ErrUnauthorized.Code,
+				string(RefreshPassportErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(RefreshPassportErr),
+				err.Error(),
+			).Res()
+		}
 	}
 	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
 }
@@ -207,7 +314,14 @@ func (h *usersHandler) SignOut(c *fiber.Ctx) error {
 		).Res()
 	}
 
-	if err := h.usersUsecase.DeleteOauth(req.OauthId); err != nil {
+	if err := h.usersUsecase.DeleteOauth(c.Locals("userId").(string), req.OauthId); err != nil {
+		if errors.Is(err, users.ErrOauthNotFound) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(SignOutErr),
+				err.Error(),
+			).Res()
+		}
 		return entities.NewResponse(c).Error(
 			fiber.ErrBadRequest.Code,
 			string(SignOutErr),
@@ -240,3 +354,345 @@ func (h *usersHandler) GetUserProfile(c *fiber.Ctx) error {
 
 	return entities.NewResponse(c).Success(fiber.StatusOK, result).Res()
 }
+
+func (h *usersHandler) FindSessions(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+	accessToken := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+
+	sessions, err := h.usersUsecase.FindSessions(userId, accessToken)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(FindSessionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, sessions).Res()
+}
+
+func (h *usersHandler) DeleteSession(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+	sessionId := strings.Trim(c.Params("session_id"), " ")
+	if _, err := uuid.Parse(sessionId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(DeleteSessionErr),
+			"session id is invalid",
+		).Res()
+	}
+
+	if err := h.usersUsecase.DeleteSession(userId, sessionId); err != nil {
+		if errors.Is(err, users.ErrSessionNotFound) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(DeleteSessionErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(DeleteSessionErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+// DeleteSessions signs the user out everywhere, the session of the caller included
+func (h *usersHandler) DeleteSessions(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+
+	if err := h.usersUsecase.DeleteSessions(userId); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(DeleteSessionsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ForgotPassword(c *fiber.Ctx) error {
+	req := new(users.ForgotPasswordReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(ForgotPasswordErr),
+			err.Error(),
+		).Res()
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(ForgotPasswordErr),
+			"email is required",
+		).Res()
+	}
+
+	if err := h.usersUsecase.ForgotPassword(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(ForgotPasswordErr),
+			err.Error(),
+		).Res()
+	}
+
+	// The same answer whether the email is registered or not
+	return entities.NewResponse(c).Success(fiber.StatusOK, &struct {
+		Message string `json:"message"`
+	}{
+		Message: "if the email is registered, a reset link has been sent to it",
+	}).Res()
+}
+
+func (h *usersHandler) ResetPassword(c *fiber.Ctx) error {
+	req := new(users.ResetPasswordReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(ResetPasswordErr),
+			err.Error(),
+		).Res()
+	}
+	if req.Token == "" || req.Password == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(ResetPasswordErr),
+			"token and password are required",
+		).Res()
+	}
+
+	if err := h.usersUsecase.ResetPassword(req); err != nil {
+		if errors.Is(err, users.ErrResetTokenInvalid) || errors.Is(err, password.ErrPolicy) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(ResetPasswordErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(ResetPasswordErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) VerifyEmail(c *fiber.Ctx) error {
+	req := new(users.VerifyEmailReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(VerifyEmailErr),
+			err.Error(),
+		).Res()
+	}
+	if req.Token == "" {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(VerifyEmailErr),
+			"token is required",
+		).Res()
+	}
+
+	if err := h.usersUsecase.VerifyEmail(req); err != nil {
+		if errors.Is(err, users.ErrVerificationTokenInvalid) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(VerifyEmailErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(VerifyEmailErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) ResendVerification(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+
+	if err := h.usersUsecase.ResendVerification(userId); err != nil {
+		switch {
+		case errors.Is(err, users.ErrEmailAlreadyVerified):
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(ResendVerificationErr),
+				err.Error(),
+			).Res()
+		case errors.Is(err, users.ErrVerificationThrottled):
+			return entities.NewResponse(c).Error(
+				fiber.ErrTooManyRequests.Code,
+				string(ResendVerificationErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(ResendVerificationErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) SignInTwoFactor(c *fiber.Ctx) error {
+	req := new(users.TwoFactorSignInReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(SignInTwoFactorErr),
+			err.Error(),
+		).Res()
+	}
+
+	passport, err := h.usersUsecase.SignInTwoFactor(req, device(c))
+	if err != nil {
+		var throttled *users.SignInThrottledError
+		if errors.As(err, &throttled) {
+			return signInThrottled(c, throttled, SignInTwoFactorErr)
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrUnauthorized.Code,
+			string(SignInTwoFactorErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, passport).Res()
+}
+
+// The two-factor routes act on the caller only, an admin passing ParamsCheck
+// must not enroll the authenticator of someone else.
+
+func (h *usersHandler) SetupTotp(c *fiber.Ctx) error {
+	setup, err := h.usersUsecase.SetupTotp(c.Locals("userId").(string))
+	if err != nil {
+		if errors.Is(err, users.ErrTotpAlreadyEnabled) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(SetupTotpErr),
+				err.Error(),
+			).Res()
+		}
+		if errors.Is(err, users.ErrTotpUnavailable) {
+			return entities.NewResponse(c).Error(
+				fiber.ErrServiceUnavailable.Code,
+				string(SetupTotpErr),
+				err.Error(),
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(SetupTotpErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, setup).Res()
+}
+
+func (h *usersHandler) EnableTotp(c *fiber.Ctx) error {
+	req := new(users.TwoFactorReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(EnableTotpErr),
+			err.Error(),
+		).Res()
+	}
+
+	codes, err := h.usersUsecase.EnableTotp(c.Locals("userId").(string), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrTotpAlreadyEnabled),
+			errors.Is(err, users.ErrTotpNotSetUp),
+			errors.Is(err, users.ErrTwoFactorCodeInvalid):
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(EnableTotpErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(EnableTotpErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, codes).Res()
+}
+
+func (h *usersHandler) DisableTotp(c *fiber.Ctx) error {
+	req := new(users.TwoFactorReq)
+	if err := c.BodyParser(req); err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrBadRequest.Code,
+			string(DisableTotpErr),
+			err.Error(),
+		).Res()
+	}
+
+	if err := h.usersUsecase.DisableTotp(c.Locals("userId").(string), req); err != nil {
+		switch {
+		case errors.Is(err, users.ErrTotpRequired):
+			return entities.NewResponse(c).Error(
+				fiber.ErrForbidden.Code,
+				string(DisableTotpErr),
+				err.Error(),
+			).Res()
+		case errors.Is(err, users.ErrTotpNotEnabled),
+			errors.Is(err, users.ErrTwoFactorCodeInvalid):
+			return entities.NewResponse(c).Error(
+				fiber.ErrBadRequest.Code,
+				string(DisableTotpErr),
+				err.Error(),
+			).Res()
+		default:
+			return entities.NewResponse(c).Error(
+				fiber.ErrInternalServerError.Code,
+				string(DisableTotpErr),
+				err.Error(),
+			).Res()
+		}
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
+
+func (h *usersHandler) FindSecurityEvents(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+
+	events, err := h.usersUsecase.FindSecurityEvents(userId)
+	if err != nil {
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(FindSecurityEventsErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, events).Res()
+}
+
+func (h *usersHandler) UnlockUser(c *fiber.Ctx) error {
+	userId := strings.Trim(c.Params("user_id"), " ")
+
+	if err := h.usersUsecase.UnlockUser(c.Locals("userId").(string), userId); err != nil {
+		if err.Error() == "get user failed: sql: no rows in result set" {
+			return entities.NewResponse(c).Error(
+				fiber.ErrNotFound.Code,
+				string(UnlockUserErr),
+				"user not found",
+			).Res()
+		}
+		return entities.NewResponse(c).Error(
+			fiber.ErrInternalServerError.Code,
+			string(UnlockUserErr),
+			err.Error(),
+		).Res()
+	}
+	return entities.NewResponse(c).Success(fiber.StatusOK, nil).Res()
+}
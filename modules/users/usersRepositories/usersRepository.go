@@ -2,6 +2,9 @@ package usersRepositories
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,11 +16,37 @@ import (
 type IUsersRepository interface {
 	InsertUser(req *users.UserRegisterReq, isAdmin bool) (*users.UserPassport, error)
 	FindOneUserByEmail(email string) (*users.UserCredentialCheck, error)
-	InsertOauth(req *users.UserPassport) error
+	InsertOauth(req *users.UserPassport, device *users.Device) error
 	FindOneOauth(refreshToken string) (*users.Oauth, error)
-	UpdateOauth(req *users.UserToken) error
+	RotateOauth(oauth *users.Oauth, req *users.UserToken, device *users.Device) error
+	RevokeOauthFamily(familyId string) error
+	InsertSecurityEvent(req *users.SecurityEvent) error
 	GetProfile(userId string) (*users.User, error)
-	DeleteOauth(oauthId string) error
+	DeleteOauth(userId, oauthId string) error
+	FindSessions(userId, accessToken string) ([]*users.Session, error)
+	DeleteSession(userId, sessionId string) error
+	DeleteSessions(userId string) error
+	InsertPasswordReset(userId, tokenHash string, expires, interval time.Duration) error
+	ResetPassword(tokenHash, password string) (string, error)
+	InsertEmailVerification(userId, tokenHash string, expires, interval time.Duration) error
+	VerifyEmail(tokenHash string) (string, error)
+	FindTotp(userId string) (*users.Totp, error)
+	SetupTotp(userId, secret string) error
+	FindPlainTotpSecrets(sealedPrefix string) ([]*users.TotpSecret, error)
+	SealTotpSecret(userId, plain, sealed string) error
+	EnableTotp(userId string, step int64, codeHashes []string) error
+	DisableTotp(userId string) error
+	IsPrivileged(userId string) (bool, error)
+	UseTotpStep(userId string, step int64) error
+	UseRecoveryCode(userId, codeHash string) error
+	FindSecurityEvents(userId string, limit int) ([]*users.SecurityEvent, error)
+	FindLoginThrottle(key string) (*users.LoginThrottle, error)
+	InsertLoginFailure(key string, window time.Duration) (int, error)
+	ReserveLoginAttempt(key string, window time.Duration, threshold int) (int, bool, error)
+	ReleaseLoginAttempt(key string) error
+	LockLogin(key string, duration time.Duration) error
+	DeleteLoginThrottle(key string) error
+	UpdatePassword(userId, current, password string) error
 }
 
 type usersRepository struct {
@@ -62,7 +91,9 @@ func (r *usersRepository) FindOneUserByEmail(email string) (*users.UserCredentia
 			"email",
 			"password",
 			"username",
-			"role_id"
+			"role_id",
+			"email_verified",
+			"totp_enabled"
 		FROM "users"
 		WHERE "email" = $1;`
 
@@ -74,7 +105,7 @@ func (r *usersRepository) FindOneUserByEmail(email string) (*users.UserCredentia
 
 }
 
-func (r *usersRepository) InsertOauth(req *users.UserPassport) error {
+func (r *usersRepository) InsertOauth(req *users.UserPassport, device *users.Device) error {
 	ctx, cacel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cacel()
 
@@ -82,9 +113,11 @@ func (r *usersRepository) InsertOauth(req *users.UserPassport) error {
 	INSERT INTO "oauth" (
 		"user_id",
 		"refresh_token",
-		"access_token"
+		"access_token",
+		"user_agent",
+		"ip"
 	)
-	VALUES ($1, $2, $3)
+	VALUES ($1, $2, $3, $4, $5)
 		RETURNING "id";`
 	if err := r.db.QueryRowContext(
 		ctx,
@@ -92,6 +125,8 @@ func (r *usersRepository) InsertOauth(req *users.UserPassport) error {
 		req.User.Id,
 		req.Token.RefreshToken,
 		req.Token.AccessToken,
+		device.UserAgent,
+		device.Ip,
 	).Scan(&req.Token.Id); err != nil {
 		return fmt.Errorf("insert oauth failed: %v", err)
 	}
@@ -103,27 +138,114 @@ func (r *usersRepository) FindOneOauth(refreshToken string) (*users.Oauth, error
 	query := `
 	SELECT
 		"id",
-		"user_id"
+		"user_id",
+		"family_id",
+		"used_at",
+		"revoked_at"
 	FROM "oauth"
 	WHERE "refresh_token" = $1;`
 
 	oauth := new(users.Oauth)
 	if err := r.db.Get(oauth, query, refreshToken); err != nil {
-		return nil, fmt.Errorf("oauth not found.")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, users.ErrOauthNotFound
+		}
+		return nil, fmt.Errorf("get oauth failed: %v", err)
 	}
 	return oauth, nil
 }
 
-func (r *usersRepository) UpdateOauth(req *users.UserToken) error {
-	query := `
-		UPDATE "oauth" SET
-			"access_token" = :access_token,
-			"refresh_token" = :refresh_token
-		WHERE "id" = :id;`
+// RotateOauth marks the oauth used and continues its family with the new
+// tokens, an oauth used or revoked in the meantime is reported as reused.
+func (r *usersRepository) RotateOauth(oauth *users.Oauth, req *users.UserToken, device *users.Device) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	if _, err := r.db.NamedExecContext(context.Background(), query, req); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	queryUsed := `
+	UPDATE "oauth" SET
+		"used_at" = now()
+	WHERE "id" = $1
+	AND "used_at" IS NULL
+	AND "revoked_at" IS NULL;`
+
+	result, err := tx.ExecContext(ctx, queryUsed, oauth.Id)
+	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("update oauth failed: %v", err)
 	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return users.ErrRefreshTokenReused
+	}
+
+	queryInsert := `
+	INSERT INTO "oauth" (
+		"user_id",
+		"family_id",
+		"refresh_token",
+		"access_token",
+		"user_agent",
+		"ip"
+	)
+	VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING "id";`
+
+	if err := tx.QueryRowContext(
+		ctx,
+		queryInsert,
+		oauth.UserId,
+		oauth.FamilyId,
+		req.RefreshToken,
+		req.AccessToken,
+		device.UserAgent,
+		device.Ip,
+	).Scan(&req.Id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert oauth failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevokeOauthFamily revokes every token issued since the sign in of the family
+func (r *usersRepository) RevokeOauthFamily(familyId string) error {
+	query := `
+	UPDATE "oauth" SET
+		"revoked_at" = now()
+	WHERE "family_id" = $1
+	AND "revoked_at" IS NULL;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, familyId); err != nil {
+		return fmt.Errorf("revoke oauth failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) InsertSecurityEvent(req *users.SecurityEvent) error {
+	details, err := json.Marshal(req.Details)
+	if err != nil {
+		return fmt.Errorf("marshal security event details failed: %v", err)
+	}
+
+	query := `
+	INSERT INTO "security_events" (
+		"user_id",
+		"event",
+		"details"
+	)
+	VALUES (NULLIF($1, ''), $2, $3);`
+
+	if _, err := r.db.ExecContext(context.Background(), query, req.UserId, req.Event, details); err != nil {
+		return fmt.Errorf("insert security event failed: %v", err)
+	}
 	return nil
 }
 
@@ -133,7 +255,8 @@ func (r *usersRepository) GetProfile(userId string) (*users.User, error) {
 		"id",
 		"email",
 		"username",
-		"role_id"
+		"role_id",
+		"email_verified"
 	FROM "users"
 	WHERE "id" = $1;`
 
@@ -144,12 +267,693 @@ func (r *usersRepository) GetProfile(userId string) (*users.User, error) {
 	return profile, nil
 }
 
-func (r *usersRepository) DeleteOauth(oauthId string) error {
-	query := `DELETE FROM "oauth" WHERE "id" = $1;`
+// DeleteOauth signs out, the tokens of the whole family go with it. An oauth
+// of another user is reported as missing.
+func (r *usersRepository) DeleteOauth(userId, oauthId string) error {
+	query := `
+	DELETE FROM "oauth"
+	WHERE "user_id" = $1
+	AND "family_id" = (
+		SELECT "family_id" FROM "oauth" WHERE "id" = $2 AND "user_id" = $1
+	);`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, oauthId)
+	if err != nil {
+		return fmt.Errorf("delete oauth failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return users.ErrOauthNotFound
+	}
+	return nil
+}
+
+// FindSessions lists the sessions still signed in, each with the device of
+// its latest token.
+func (r *usersRepository) FindSessions(userId, accessToken string) ([]*users.Session, error) {
+	query := `
+	SELECT
+		"o"."family_id" AS "id",
+		"o"."user_agent",
+		"o"."ip",
+		"f"."created_at",
+		"o"."last_used_at",
+		("o"."access_token" = $2) AS "current"
+	FROM "oauth" "o"
+	JOIN (
+		SELECT
+			"family_id",
+			MIN("created_at") AS "created_at"
+		FROM "oauth"
+		WHERE "user_id" = $1
+		GROUP BY "family_id"
+	) AS "f" ON "f"."family_id" = "o"."family_id"
+	WHERE "o"."user_id" = $1
+	AND "o"."used_at" IS NULL
+	AND "o"."revoked_at" IS NULL
+	ORDER BY "o"."last_used_at" DESC;`
+
+	sessions := make([]*users.Session, 0)
+	if err := r.db.Select(&sessions, query, userId, accessToken); err != nil {
+		return nil, fmt.Errorf("get sessions failed: %v", err)
+	}
+	return sessions, nil
+}
+
+func (r *usersRepository) DeleteSession(userId, sessionId string) error {
+	query := `
+	DELETE FROM "oauth"
+	WHERE "user_id" = $1
+	AND "family_id" = $2;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, sessionId)
+	if err != nil {
+		return fmt.Errorf("delete session failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return users.ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteSessions signs the user out everywhere
+func (r *usersRepository) DeleteSessions(userId string) error {
+	query := `DELETE FROM "oauth" WHERE "user_id" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, userId); err != nil {
+		return fmt.Errorf("delete sessions failed: %v", err)
+	}
+	return nil
+}
+
+// InsertPasswordReset issues a reset token unless one was issued less than
+// the interval ago, the ones issued before are no longer usable.
+func (r *usersRepository) InsertPasswordReset(userId, tokenHash string, expires, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// The lock on the user keeps two requests from both passing the throttle
+	queryUser := `
+	SELECT
+		"id"
+	FROM "users"
+	WHERE "id" = $1
+	FOR UPDATE;`
+
+	if _, err := tx.ExecContext(ctx, queryUser, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get user failed: %v", err)
+	}
+
+	queryRecent := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM "password_resets"
+		WHERE "user_id" = $1
+		AND "created_at" > now() - make_interval(secs => $2)
+	);`
+
+	var recent bool
+	if err := tx.QueryRowContext(ctx, queryRecent, userId, interval.Seconds()).Scan(&recent); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get password resets failed: %v", err)
+	}
+	if recent {
+		tx.Rollback()
+		return users.ErrResetThrottled
+	}
+
+	queryUsed := `
+	UPDATE "password_resets" SET
+		"used_at" = now()
+	WHERE "user_id" = $1
+	AND "used_at" IS NULL;`
+
+	if _, err := tx.ExecContext(ctx, queryUsed, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update password resets failed: %v", err)
+	}
+
+	queryInsert := `
+	INSERT INTO "password_resets" (
+		"user_id",
+		"token_hash",
+		"expires_at"
+	)
+	VALUES ($1, $2, now() + make_interval(secs => $3));`
+
+	if _, err := tx.ExecContext(ctx, queryInsert, userId, tokenHash, expires.Seconds()); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert password reset failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResetPassword uses up a reset token to set the password and signs the user
+// out of every session, it returns the user whose password was reset.
+func (r *usersRepository) ResetPassword(tokenHash, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	queryUsed := `
+	UPDATE "password_resets" SET
+		"used_at" = now()
+	WHERE "token_hash" = $1
+	AND "used_at" IS NULL
+	AND "expires_at" > now()
+	RETURNING "user_id";`
+
+	var userId string
+	if err := tx.QueryRowContext(ctx, queryUsed, tokenHash).Scan(&userId); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", users.ErrResetTokenInvalid
+		}
+		return "", fmt.Errorf("update password reset failed: %v", err)
+	}
+
+	queryPassword := `
+	UPDATE "users" SET
+		"password" = $2
+	WHERE "id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryPassword, userId, password); err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("update password failed: %v", err)
+	}
+
+	queryOauth := `DELETE FROM "oauth" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryOauth, userId); err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("delete sessions failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+// InsertEmailVerification issues a verification token unless the email is
+// already verified or a token was issued less than the interval ago, the
+// ones issued before are no longer usable.
+func (r *usersRepository) InsertEmailVerification(userId, tokenHash string, expires, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// The lock on the user keeps two resends from both passing the throttle
+	queryUser := `
+	SELECT
+		"email_verified"
+	FROM "users"
+	WHERE "id" = $1
+	FOR UPDATE;`
+
+	var verified bool
+	if err := tx.QueryRowContext(ctx, queryUser, userId).Scan(&verified); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get user failed: %v", err)
+	}
+	if verified {
+		tx.Rollback()
+		return users.ErrEmailAlreadyVerified
+	}
+
+	queryRecent := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM "email_verifications"
+		WHERE "user_id" = $1
+		AND "created_at" > now() - make_interval(secs => $2)
+	);`
+
+	var recent bool
+	if err := tx.QueryRowContext(ctx, queryRecent, userId, interval.Seconds()).Scan(&recent); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get email verifications failed: %v", err)
+	}
+	if recent {
+		tx.Rollback()
+		return users.ErrVerificationThrottled
+	}
+
+	queryUsed := `
+	UPDATE "email_verifications" SET
+		"used_at" = now()
+	WHERE "user_id" = $1
+	AND "used_at" IS NULL;`
+
+	if _, err := tx.ExecContext(ctx, queryUsed, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update email verifications failed: %v", err)
+	}
+
+	queryInsert := `
+	INSERT INTO "email_verifications" (
+		"user_id",
+		"token_hash",
+		"expires_at"
+	)
+	VALUES ($1, $2, now() + make_interval(secs => $3));`
+
+	if _, err := tx.ExecContext(ctx, queryInsert, userId, tokenHash, expires.Seconds()); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert email verification failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyEmail uses up a verification token, it returns the user whose email
+// was verified.
+func (r *usersRepository) VerifyEmail(tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	queryUsed := `
+	UPDATE "email_verifications" SET
+		"used_at" = now()
+	WHERE "token_hash" = $1
+	AND "used_at" IS NULL
+	AND "expires_at" > now()
+	RETURNING "user_id";`
+
+	var userId string
+	if err := tx.QueryRowContext(ctx, queryUsed, tokenHash).Scan(&userId); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", users.ErrVerificationTokenInvalid
+		}
+		return "", fmt.Errorf("update email verification failed: %v", err)
+	}
+
+	queryVerified := `
+	UPDATE "users" SET
+		"email_verified" = TRUE
+	WHERE "id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryVerified, userId); err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("update user failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+func (r *usersRepository) FindTotp(userId string) (*users.Totp, error) {
+	query := `
+	SELECT
+		"totp_secret",
+		"totp_enabled",
+		"totp_last_step"
+	FROM "users"
+	WHERE "id" = $1;`
+
+	totp := new(users.Totp)
+	if err := r.db.Get(totp, query, userId); err != nil {
+		return nil, fmt.Errorf("get totp failed: %v", err)
+	}
+	return totp, nil
+}
+
+// SetupTotp sets a new secret, it replaces a secret not enabled yet but never
+// an enabled one.
+func (r *usersRepository) SetupTotp(userId, secret string) error {
+	query := `
+	UPDATE "users" SET
+		"totp_secret" = $2
+	WHERE "id" = $1
+	AND "totp_enabled" = FALSE;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, secret)
+	if err != nil {
+		return fmt.Errorf("update totp failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return users.ErrTotpAlreadyEnabled
+	}
+	return nil
+}
+
+// FindPlainTotpSecrets lists the secrets stored before they were encrypted
+func (r *usersRepository) FindPlainTotpSecrets(sealedPrefix string) ([]*users.TotpSecret, error) {
+	query := `
+	SELECT
+		"id",
+		"totp_secret"
+	FROM "users"
+	WHERE "totp_secret" IS NOT NULL
+	AND NOT starts_with("totp_secret", $1);`
+
+	secrets := make([]*users.TotpSecret, 0)
+	if err := r.db.Select(&secrets, query, sealedPrefix); err != nil {
+		return nil, fmt.Errorf("find totp secrets failed: %v", err)
+	}
+	return secrets, nil
+}
+
+// SealTotpSecret replaces a secret with its encrypted form, unless it was
+// changed in the meantime
+func (r *usersRepository) SealTotpSecret(userId, plain, sealed string) error {
+	query := `
+	UPDATE "users" SET
+		"totp_secret" = $3
+	WHERE "id" = $1
+	AND "totp_secret" = $2;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, userId, plain, sealed); err != nil {
+		return fmt.Errorf("update totp secret failed: %v", err)
+	}
+	return nil
+}
+
+// EnableTotp turns on the secret set up and replaces the recovery codes
+func (r *usersRepository) EnableTotp(userId string, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	queryEnable := `
+	UPDATE "users" SET
+		"totp_enabled" = TRUE,
+		"totp_last_step" = $2
+	WHERE "id" = $1
+	AND "totp_enabled" = FALSE
+	AND "totp_secret" IS NOT NULL;`
+
+	result, err := tx.ExecContext(ctx, queryEnable, userId, step)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update totp failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return users.ErrTotpAlreadyEnabled
+	}
+
+	queryDelete := `DELETE FROM "recovery_codes" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryDelete, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete recovery codes failed: %v", err)
+	}
+
+	queryInsert := `
+	INSERT INTO "recovery_codes" (
+		"user_id",
+		"code_hash"
+	)
+	SELECT $1, unnest($2::varchar[]);`
+
+	if _, err := tx.ExecContext(ctx, queryInsert, userId, codeHashes); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert recovery codes failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsPrivileged tells whether the role of a user grants any permission
+func (r *usersRepository) IsPrivileged(userId string) (bool, error) {
+	query := `
+	SELECT
+		cardinality("r"."permissions") > 0
+	FROM "users" "u"
+	JOIN "roles" "r" ON "r"."id" = "u"."role_id"
+	WHERE "u"."id" = $1;`
+
+	var privileged bool
+	if err := r.db.Get(&privileged, query, userId); err != nil {
+		return false, fmt.Errorf("get user role failed: %v", err)
+	}
+	return privileged, nil
+}
+
+// DisableTotp forgets the secret and the recovery codes
+func (r *usersRepository) DisableTotp(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	queryDisable := `
+	UPDATE "users" SET
+		"totp_secret" = NULL,
+		"totp_enabled" = FALSE,
+		"totp_last_step" = 0
+	WHERE "id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryDisable, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update totp failed: %v", err)
+	}
+
+	queryDelete := `DELETE FROM "recovery_codes" WHERE "user_id" = $1;`
+
+	if _, err := tx.ExecContext(ctx, queryDelete, userId); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete recovery codes failed: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseTotpStep records the step of an accepted code, a code of that step or
+// an earlier one is refused from then on.
+func (r *usersRepository) UseTotpStep(userId string, step int64) error {
+	query := `
+	UPDATE "users" SET
+		"totp_last_step" = $2
+	WHERE "id" = $1
+	AND "totp_last_step" < $2;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, step)
+	if err != nil {
+		return fmt.Errorf("update totp failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return users.ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+func (r *usersRepository) UseRecoveryCode(userId, codeHash string) error {
+	query := `
+	UPDATE "recovery_codes" SET
+		"used_at" = now()
+	WHERE "user_id" = $1
+	AND "code_hash" = $2
+	AND "used_at" IS NULL;`
+
+	result, err := r.db.ExecContext(context.Background(), query, userId, codeHash)
+	if err != nil {
+		return fmt.Errorf("update recovery code failed: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return users.ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+// FindSecurityEvents lists the latest events of a user first
+func (r *usersRepository) FindSecurityEvents(userId string, limit int) ([]*users.SecurityEvent, error) {
+	query := `
+	SELECT
+		COALESCE(array_to_json(array_agg("t")), '[]'::json)
+	FROM (
+		SELECT
+			"e"."id",
+			"e"."user_id",
+			"e"."event",
+			"e"."details",
+			"e"."created_at"
+		FROM "security_events" "e"
+		WHERE "e"."user_id" = $1
+		ORDER BY "e"."created_at" DESC
+		LIMIT $2
+	) AS "t";`
+
+	raw := make([]byte, 0)
+	if err := r.db.Get(&raw, query, userId, limit); err != nil {
+		return nil, fmt.Errorf("get security events failed: %v", err)
+	}
 
-	if _, err := r.db.ExecContext(context.Background(), query, oauthId); err != nil {
-		return fmt.Errorf("oauth not found.")
+	events := make([]*users.SecurityEvent, 0)
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return nil, fmt.Errorf("unmarshal security events failed: %v", err)
 	}
+	return events, nil
+}
 
+// FindLoginThrottle tells how an account or an ip is doing, one never seen
+// failing has no failures.
+func (r *usersRepository) FindLoginThrottle(key string) (*users.LoginThrottle, error) {
+	query := `
+	SELECT
+		"failures",
+		COALESCE(GREATEST(EXTRACT(EPOCH FROM ("locked_until" - now())), 0), 0) AS "retry_after"
+	FROM "login_throttles"
+	WHERE "key" = $1;`
+
+	throttle := new(users.LoginThrottle)
+	if err := r.db.Get(throttle, query, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return throttle, nil
+		}
+		return nil, fmt.Errorf("get login throttle failed: %v", err)
+	}
+	return throttle, nil
+}
+
+// InsertLoginFailure counts a failed sign in and returns the failures so far,
+// the count starts over once the previous failure is older than the window.
+func (r *usersRepository) InsertLoginFailure(key string, window time.Duration) (int, error) {
+	query := `
+	INSERT INTO "login_throttles" AS "l" (
+		"key",
+		"failures",
+		"last_failed_at"
+	)
+	VALUES ($1, 1, now())
+	ON CONFLICT ("key") DO UPDATE SET
+		"failures" = CASE
+			WHEN "l"."last_failed_at" < now() - make_interval(secs => $2) THEN 1
+			ELSE "l"."failures" + 1
+		END,
+		"last_failed_at" = now()
+	RETURNING "failures";`
+
+	var failures int
+	if err := r.db.QueryRowxContext(context.Background(), query, key, window.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("insert login failure failed: %v", err)
+	}
+	return failures, nil
+}
+
+// ReserveLoginAttempt counts an attempt as failed before it is checked, so
+// parallel attempts each get their own count instead of all passing a check
+// made before any of them failed. It reserves nothing while a wait is running.
+// An attempt after a lockout ran out starts at the threshold, it gets one
+// guess before the next lockout.
+func (r *usersRepository) ReserveLoginAttempt(key string, window time.Duration, threshold int) (int, bool, error) {
+	query := `
+	INSERT INTO "login_throttles" AS "l" (
+		"key",
+		"failures",
+		"last_failed_at"
+	)
+	VALUES ($1, 1, now())
+	ON CONFLICT ("key") DO UPDATE SET
+		"failures" = CASE
+			WHEN "l"."last_failed_at" < now() - make_interval(secs => $2) THEN 1
+			WHEN "l"."locked_until" IS NOT NULL AND "l"."failures" >= $3 THEN $3
+			ELSE "l"."failures" + 1
+		END,
+		"locked_until" = NULL,
+		"last_failed_at" = now()
+	WHERE "l"."locked_until" IS NULL
+	OR "l"."locked_until" <= now()
+	RETURNING "failures";`
+
+	var failures int
+	if err := r.db.QueryRowxContext(context.Background(), query, key, window.Seconds(), threshold).Scan(&failures); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("reserve login attempt failed: %v", err)
+	}
+	return failures, true, nil
+}
+
+// ReleaseLoginAttempt takes back a reserved attempt that did not fail
+func (r *usersRepository) ReleaseLoginAttempt(key string) error {
+	query := `
+	UPDATE "login_throttles" SET
+		"failures" = GREATEST("failures" - 1, 0)
+	WHERE "key" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, key); err != nil {
+		return fmt.Errorf("release login attempt failed: %v", err)
+	}
+	return nil
+}
+
+func (r *usersRepository) LockLogin(key string, duration time.Duration) error {
+	query := `
+	UPDATE "login_throttles" SET
+		"locked_until" = now() + make_interval(secs => $2)
+	WHERE "key" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, key, duration.Seconds()); err != nil {
+		return fmt.Errorf("update login throttle failed: %v", err)
+	}
+	return nil
+}
+
+// DeleteLoginThrottle forgets the failures of an account or an ip
+func (r *usersRepository) DeleteLoginThrottle(key string) error {
+	query := `DELETE FROM "login_throttles" WHERE "key" = $1;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, key); err != nil {
+		return fmt.Errorf("delete login throttle failed: %v", err)
+	}
+	return nil
+}
+
+// UpdatePassword replaces the hash only while it is still the current one, a
+// password reset in the meantime is never undone.
+func (r *usersRepository) UpdatePassword(userId, current, password string) error {
+	query := `
+	UPDATE "users" SET
+		"password" = $2
+	WHERE "id" = $1
+	AND "password" = $3;`
+
+	if _, err := r.db.ExecContext(context.Background(), query, userId, password, current); err != nil {
+		return fmt.Errorf("update password failed: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,201 @@
+package usersUsecases
+
+import (
+	"log"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/modules/users"
+)
+
+// Failed sign ins are counted against the account and against the ip they
+// came from. An account waits longer after every failure past a few, then is
+// locked, an ip is blocked once it failed for too many attempts whatever the
+// accounts it tried.
+
+func userThrottleKey(userId string) string { return "user:" + userId }
+
+func ipThrottleKey(ip string) string { return "ip:" + ip }
+
+// securityEventsLimit is how many of the latest events an admin reviews
+const securityEventsLimit = 100
+
+// backoff is how long an account waits after its failures, locked tells the
+// wait is a lockout.
+func (u *usersUsecase) backoff(failures int) (wait time.Duration, locked bool) {
+	cfg := u.cfg.Login()
+	if failures >= cfg.LockoutThreshold() {
+		return cfg.LockoutDuration(), true
+	}
+	if failures < cfg.BackoffAfter() {
+		return 0, false
+	}
+
+	wait = cfg.BackoffBase() << (failures - cfg.BackoffAfter())
+	if wait <= 0 || wait > cfg.LockoutDuration() {
+		wait = cfg.LockoutDuration()
+	}
+	return wait, false
+}
+
+func (u *usersUsecase) checkIpThrottle(device *users.Device) error {
+	if u.cfg.Login().IpThreshold() == 0 {
+		return nil
+	}
+	throttle, err := u.usersRepository.FindLoginThrottle(ipThrottleKey(device.Ip))
+	if err != nil {
+		return err
+	}
+	if throttle.RetryAfter > 0 {
+		return &users.SignInThrottledError{
+			Err:        users.ErrTooManySignIns,
+			RetryAfter: time.Duration(throttle.RetryAfter * float64(time.Second)),
+		}
+	}
+	return nil
+}
+
+// reserveUserAttempt counts an attempt of the account as failed before its
+// password or code is checked, a success takes it back. It returns the
+// failures of the account counting this attempt.
+func (u *usersUsecase) reserveUserAttempt(userId string) (int, error) {
+	cfg := u.cfg.Login()
+	key := userThrottleKey(userId)
+
+	failures, reserved, err := u.usersRepository.ReserveLoginAttempt(key, cfg.FailureWindow(), cfg.LockoutThreshold())
+	if err != nil {
+		return 0, err
+	}
+	if !reserved {
+		return 0, u.userThrottled(userId)
+	}
+	// Attempts made at once went past the threshold before the lockout began
+	if failures > cfg.LockoutThreshold() {
+		if err := u.usersRepository.LockLogin(key, cfg.LockoutDuration()); err != nil {
+			log.Printf("lock %s failed: %v\n", key, err)
+		}
+		return 0, &users.SignInThrottledError{
+			Err:        users.ErrAccountLocked,
+			RetryAfter: cfg.LockoutDuration(),
+		}
+	}
+	return failures, nil
+}
+
+// releaseUserAttempt takes back a reserved attempt that did not fail
+func (u *usersUsecase) releaseUserAttempt(userId string) {
+	if err := u.usersRepository.ReleaseLoginAttempt(userThrottleKey(userId)); err != nil {
+		log.Printf("release sign in attempt of %s failed: %v\n", userId, err)
+	}
+}
+
+// userThrottled is the refusal of an account waiting after its failures
+func (u *usersUsecase) userThrottled(userId string) error {
+	throttle, err := u.usersRepository.FindLoginThrottle(userThrottleKey(userId))
+	if err != nil {
+		return err
+	}
+
+	throttled := &users.SignInThrottledError{
+		Err:        users.ErrTooManySignIns,
+		RetryAfter: time.Duration(throttle.RetryAfter * float64(time.Second)),
+	}
+	// The wait ran out in between, the client may try again at once
+	if throttled.RetryAfter <= 0 {
+		throttled.RetryAfter = time.Second
+	}
+	if throttle.Failures >= u.cfg.Login().LockoutThreshold() {
+		throttled.Err = users.ErrAccountLocked
+	}
+	return throttled
+}
+
+// signInFailed counts a failure of the ip, when the ip throttle is on, and
+// makes the account wait after the failures it reserved, when the email is
+// known. Counting never fails the sign in, it is only logged.
+func (u *usersUsecase) signInFailed(userId string, failures int, device *users.Device, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	details["ip"] = device.Ip
+	details["user_agent"] = device.UserAgent
+
+	if u.cfg.Login().IpThreshold() > 0 {
+		u.ipSignInFailed(device)
+	}
+
+	if userId == "" {
+		u.recordSecurityEvent("", users.SecurityEventSignInFailed, details)
+		return
+	}
+
+	userKey := userThrottleKey(userId)
+	details["failures"] = failures
+	u.recordSecurityEvent(userId, users.SecurityEventSignInFailed, details)
+
+	wait, locked := u.backoff(failures)
+	if wait == 0 {
+		return
+	}
+	if err := u.usersRepository.LockLogin(userKey, wait); err != nil {
+		log.Printf("lock %s failed: %v\n", userKey, err)
+	}
+	if locked {
+		u.recordSecurityEvent(userId, users.SecurityEventAccountLocked, map[string]any{
+			"ip":       device.Ip,
+			"failures": failures,
+			"seconds":  int(wait.Seconds()),
+		})
+	}
+}
+
+// ipSignInFailed counts a failure of the ip and blocks it past the threshold
+func (u *usersUsecase) ipSignInFailed(device *users.Device) {
+	ipKey := ipThrottleKey(device.Ip)
+	ipFailures, err := u.usersRepository.InsertLoginFailure(ipKey, u.cfg.Login().FailureWindow())
+	if err != nil {
+		log.Printf("count sign in failure of %s failed: %v\n", ipKey, err)
+		return
+	}
+	if ipFailures < u.cfg.Login().IpThreshold() {
+		return
+	}
+	if err := u.usersRepository.LockLogin(ipKey, u.cfg.Login().LockoutDuration()); err != nil {
+		log.Printf("block %s failed: %v\n", ipKey, err)
+	}
+	if ipFailures == u.cfg.Login().IpThreshold() {
+		u.recordSecurityEvent("", users.SecurityEventIpBlocked, map[string]any{
+			"ip":       device.Ip,
+			"failures": ipFailures,
+		})
+	}
+}
+
+// signInSucceeded forgets the failures of the account, the ones of the ip
+// stay so a valid account cannot clear the ip it is guessing others from.
+func (u *usersUsecase) signInSucceeded(userId string) {
+	if err := u.usersRepository.DeleteLoginThrottle(userThrottleKey(userId)); err != nil {
+		log.Printf("reset sign in failures of %s failed: %v\n", userId, err)
+	}
+}
+
+// UnlockUser lifts the lockout of an account before it expires
+func (u *usersUsecase) UnlockUser(adminId, userId string) error {
+	if _, err := u.usersRepository.GetProfile(userId); err != nil {
+		return err
+	}
+	if err := u.usersRepository.DeleteLoginThrottle(userThrottleKey(userId)); err != nil {
+		return err
+	}
+	u.recordSecurityEvent(userId, users.SecurityEventAccountUnlocked, map[string]any{
+		"admin_id": adminId,
+	})
+	return nil
+}
+
+func (u *usersUsecase) FindSecurityEvents(userId string) ([]*users.SecurityEvent, error) {
+	events, err := u.usersRepository.FindSecurityEvents(userId, securityEventsLimit)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
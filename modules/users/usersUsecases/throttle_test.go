@@ -0,0 +1,213 @@
+package usersUsecases
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/config"
+	"github.com/jetsadawwts/go-restapi/modules/users"
+	"github.com/jetsadawwts/go-restapi/modules/users/usersRepositories"
+)
+
+// loginConfig stands in for the loaded config, only the login settings are read
+type loginConfig struct {
+	config.IConfig
+	backoffAfter     int
+	backoffBase      time.Duration
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+	ipThreshold      int
+}
+
+func testLoginConfig() *loginConfig {
+	return &loginConfig{
+		backoffAfter:     3,
+		backoffBase:      time.Second,
+		lockoutThreshold: 10,
+		lockoutDuration:  15 * time.Minute,
+	}
+}
+
+func (c *loginConfig) Login() config.ILoginConfig     { return c }
+func (c *loginConfig) BackoffAfter() int              { return c.backoffAfter }
+func (c *loginConfig) BackoffBase() time.Duration     { return c.backoffBase }
+func (c *loginConfig) LockoutThreshold() int          { return c.lockoutThreshold }
+func (c *loginConfig) LockoutDuration() time.Duration { return c.lockoutDuration }
+func (c *loginConfig) IpThreshold() int               { return c.ipThreshold }
+func (c *loginConfig) FailureWindow() time.Duration   { return time.Hour }
+
+// throttleRepository keeps the throttle in memory, the other queries are not
+// reached by these tests.
+type throttleRepository struct {
+	usersRepositories.IUsersRepository
+	reserveFailures int
+	reserved        bool
+	throttle        *users.LoginThrottle
+	ipFailures      int
+	locks           map[string]time.Duration
+	events          []string
+}
+
+func newThrottleRepository() *throttleRepository {
+	return &throttleRepository{
+		reserved: true,
+		throttle: new(users.LoginThrottle),
+		locks:    make(map[string]time.Duration),
+	}
+}
+
+func (r *throttleRepository) FindLoginThrottle(key string) (*users.LoginThrottle, error) {
+	return r.throttle, nil
+}
+
+func (r *throttleRepository) InsertLoginFailure(key string, window time.Duration) (int, error) {
+	return r.ipFailures, nil
+}
+
+func (r *throttleRepository) ReserveLoginAttempt(key string, window time.Duration, threshold int) (int, bool, error) {
+	return r.reserveFailures, r.reserved, nil
+}
+
+func (r *throttleRepository) LockLogin(key string, duration time.Duration) error {
+	r.locks[key] = duration
+	return nil
+}
+
+func (r *throttleRepository) InsertSecurityEvent(req *users.SecurityEvent) error {
+	r.events = append(r.events, req.Event)
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	capped := testLoginConfig()
+	capped.backoffBase = time.Minute
+	late := testLoginConfig()
+	late.lockoutThreshold = 100
+
+	tests := []struct {
+		name     string
+		cfg      *loginConfig
+		failures int
+		wait     time.Duration
+		locked   bool
+	}{
+		{"first failure", testLoginConfig(), 1, 0, false},
+		{"before the backoff", testLoginConfig(), 2, 0, false},
+		{"first backoff", testLoginConfig(), 3, time.Second, false},
+		{"doubled", testLoginConfig(), 4, 2 * time.Second, false},
+		{"doubled again", testLoginConfig(), 6, 8 * time.Second, false},
+		{"last before the lockout", testLoginConfig(), 9, 64 * time.Second, false},
+		{"locked", testLoginConfig(), 10, 15 * time.Minute, true},
+		{"past the lockout", testLoginConfig(), 12, 15 * time.Minute, true},
+		{"backoff capped at the lockout", capped, 9, 15 * time.Minute, false},
+		// The shift overflows long before, the wait stays capped
+		{"backoff overflowing", late, 90, 15 * time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &usersUsecase{cfg: tt.cfg}
+			wait, locked := u.backoff(tt.failures)
+			if wait != tt.wait || locked != tt.locked {
+				t.Errorf("backoff(%d) = (%v, %v), want (%v, %v)", tt.failures, wait, locked, tt.wait, tt.locked)
+			}
+		})
+	}
+}
+
+func TestReserveUserAttempt(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		reserved   bool
+		throttle   *users.LoginThrottle
+		want       int
+		err        error
+		retryAfter time.Duration
+		locked     bool // the account is locked by this attempt
+	}{
+		{"reserved", 2, true, nil, 2, nil, 0, false},
+		{"waiting", 0, false, &users.LoginThrottle{Failures: 4, RetryAfter: 3.5}, 0, users.ErrTooManySignIns, 3500 * time.Millisecond, false},
+		{"locked", 0, false, &users.LoginThrottle{Failures: 10, RetryAfter: 600}, 0, users.ErrAccountLocked, 10 * time.Minute, false},
+		// The lockout ran out between the reservation and the lookup
+		{"wait ran out", 0, false, &users.LoginThrottle{Failures: 4}, 0, users.ErrTooManySignIns, time.Second, false},
+		{"concurrent attempts past the threshold", 11, true, nil, 0, users.ErrAccountLocked, 15 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newThrottleRepository()
+			repo.reserveFailures, repo.reserved = tt.failures, tt.reserved
+			if tt.throttle != nil {
+				repo.throttle = tt.throttle
+			}
+			u := &usersUsecase{cfg: testLoginConfig(), usersRepository: repo}
+
+			failures, err := u.reserveUserAttempt("U000001")
+			if failures != tt.want || !errors.Is(err, tt.err) {
+				t.Fatalf("reserveUserAttempt = (%d, %v), want (%d, %v)", failures, err, tt.want, tt.err)
+			}
+			if tt.err != nil {
+				throttled := new(users.SignInThrottledError)
+				if !errors.As(err, &throttled) || throttled.RetryAfter != tt.retryAfter {
+					t.Errorf("reserveUserAttempt = %v, want a retry after %v", err, tt.retryAfter)
+				}
+			}
+			if _, ok := repo.locks[userThrottleKey("U000001")]; ok != tt.locked {
+				t.Errorf("account locked = %v, want %v", ok, tt.locked)
+			}
+		})
+	}
+}
+
+func TestSignInFailed(t *testing.T) {
+	withIp := testLoginConfig()
+	withIp.ipThreshold = 20
+
+	tests := []struct {
+		name       string
+		cfg        *loginConfig
+		userId     string
+		failures   int
+		ipFailures int
+		userLock   time.Duration // 0 for no lock
+		ipLock     time.Duration
+		events     []string
+	}{
+		{"unknown email", testLoginConfig(), "", 0, 0, 0, 0, []string{users.SecurityEventSignInFailed}},
+		{"before the backoff", testLoginConfig(), "U000001", 2, 0, 0, 0, []string{users.SecurityEventSignInFailed}},
+		{"backoff", testLoginConfig(), "U000001", 5, 0, 4 * time.Second, 0, []string{users.SecurityEventSignInFailed}},
+		{"lockout", testLoginConfig(), "U000001", 10, 0, 15 * time.Minute, 0, []string{users.SecurityEventSignInFailed, users.SecurityEventAccountLocked}},
+		{"ip under its threshold", withIp, "", 0, 19, 0, 0, []string{users.SecurityEventSignInFailed}},
+		{"ip blocked", withIp, "", 0, 20, 0, 15 * time.Minute, []string{users.SecurityEventIpBlocked, users.SecurityEventSignInFailed}},
+		// The block is extended, the event is only recorded once
+		{"ip still failing", withIp, "U000001", 1, 21, 0, 15 * time.Minute, []string{users.SecurityEventSignInFailed}},
+	}
+
+	device := &users.Device{Ip: "203.0.113.7", UserAgent: "curl/8.0"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newThrottleRepository()
+			repo.ipFailures = tt.ipFailures
+			u := &usersUsecase{cfg: tt.cfg, usersRepository: repo}
+
+			u.signInFailed(tt.userId, tt.failures, device, nil)
+
+			if got := repo.locks[userThrottleKey(tt.userId)]; tt.userId != "" && got != tt.userLock {
+				t.Errorf("account lock = %v, want %v", got, tt.userLock)
+			}
+			if got := repo.locks[ipThrottleKey(device.Ip)]; got != tt.ipLock {
+				t.Errorf("ip lock = %v, want %v", got, tt.ipLock)
+			}
+			if len(repo.events) != len(tt.events) {
+				t.Fatalf("events = %v, want %v", repo.events, tt.events)
+			}
+			for i := range tt.events {
+				if repo.events[i] != tt.events[i] {
+					t.Errorf("events = %v, want %v", repo.events, tt.events)
+				}
+			}
+		})
+	}
+}
@@ -1,55 +1,101 @@
 package usersUsecases
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/users"
 	"github.com/jetsadawwts/go-restapi/modules/users/usersRepositories"
 	"github.com/jetsadawwts/go-restapi/pkg/auth"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jetsadawwts/go-restapi/pkg/mailer"
+	"github.com/jetsadawwts/go-restapi/pkg/password"
 )
 
 type IUsersUsecase interface {
 	InsertCustomer(req *users.UserRegisterReq) (*users.UserPassport, error)
 	InsertAdmin(req *users.UserRegisterReq) (*users.UserPassport, error)
-	GetPassport(req *users.UserCredential) (*users.UserPassport, error)
-	RefreshPassport(req *users.UserRefreshCredential) (*users.UserPassport, error)
-	DeleteOauth(oauthId string) error
+	GetPassport(req *users.UserCredential, device *users.Device) (*users.UserPassport, error)
+	RefreshPassport(req *users.UserRefreshCredential, device *users.Device) (*users.UserPassport, error)
+	DeleteOauth(userId, oauthId string) error
+	FindSessions(userId, accessToken string) ([]*users.Session, error)
+	DeleteSession(userId, sessionId string) error
+	DeleteSessions(userId string) error
 	GetUserProfile(userId string) (*users.User, error)
+	FindSecurityEvents(userId string) ([]*users.SecurityEvent, error)
+	UnlockUser(adminId, userId string) error
+	ForgotPassword(req *users.ForgotPasswordReq) error
+	ResetPassword(req *users.ResetPasswordReq) error
+	VerifyEmail(req *users.VerifyEmailReq) error
+	ResendVerification(userId string) error
+	SignInTwoFactor(req *users.TwoFactorSignInReq, device *users.Device) (*users.UserPassport, error)
+	SetupTotp(userId string) (*users.TotpSetup, error)
+	EnableTotp(userId string, req *users.TwoFactorReq) (*users.RecoveryCodes, error)
+	DisableTotp(userId string, req *users.TwoFactorReq) error
+	SealTotpSecrets() error
 }
 
 type usersUsecase struct {
 	cfg             config.IConfig
+	keyset          auth.IKeyset
+	mailer          mailer.IMailer
+	hasher          password.IHasher
+	policy          password.IPolicy
 	usersRepository usersRepositories.IUsersRepository
 }
 
-func UsersUsecase(cfg config.IConfig, usersRepository usersRepositories.IUsersRepository) IUsersUsecase {
+func UsersUsecase(cfg config.IConfig, keyset auth.IKeyset, mailer mailer.IMailer, hasher password.IHasher, policy password.IPolicy, usersRepository usersRepositories.IUsersRepository) IUsersUsecase {
 	return &usersUsecase{
 		cfg:             cfg,
+		keyset:          keyset,
+		mailer:          mailer,
+		hasher:          hasher,
+		policy:          policy,
 		usersRepository: usersRepository,
 	}
 }
 
+// hashPassword checks a new password against the policy and hashes it
+func (u *usersUsecase) hashPassword(plain string) (string, error) {
+	if err := u.policy.Check(plain); err != nil {
+		return "", err
+	}
+	return u.hasher.Hash(plain)
+}
+
 func (u *usersUsecase) InsertCustomer(req *users.UserRegisterReq) (*users.UserPassport, error) {
 	//Hashing a password
-	if err := req.BcryptHashing(); err != nil {
+	hashed, err := u.hashPassword(req.Password)
+	if err != nil {
 		return nil, err
 	}
+	req.Password = hashed
 
 	//Insert user
 	result, err := u.usersRepository.InsertUser(req, false)
 	if err != nil {
 		return nil, err
 	}
+
+	// The account exists either way, a lost mail can be resent
+	if err := u.issueVerification(result.User); err != nil {
+		log.Printf("issue verification of %s failed: %v\n", result.User.Id, err)
+	}
 	return result, nil
 }
 
 func (u *usersUsecase) InsertAdmin(req *users.UserRegisterReq) (*users.UserPassport, error) {
 	//Hashing a password
-	if err := req.BcryptHashing(); err != nil {
+	hashed, err := u.hashPassword(req.Password)
+	if err != nil {
 		return nil, err
 	}
+	req.Password = hashed
 
 	//Insert user
 	result, err := u.usersRepository.InsertUser(req, true)
@@ -59,53 +105,95 @@ func (u *usersUsecase) InsertAdmin(req *users.UserRegisterReq) (*users.UserPassp
 	return result, nil
 }
 
-func (u *usersUsecase) GetPassport(req *users.UserCredential) (*users.UserPassport, error) {
+func (u *usersUsecase) GetPassport(req *users.UserCredential, device *users.Device) (*users.UserPassport, error) {
+	if err := u.checkIpThrottle(device); err != nil {
+		return nil, err
+	}
+
 	// Find user
 	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
+	if err != nil {
+		u.signInFailed("", 0, device, map[string]any{"email": req.Email})
+		return nil, err
+	}
+	failures, err := u.reserveUserAttempt(user.Id)
 	if err != nil {
 		return nil, err
 	}
 
 	// Compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	ok, err := u.hasher.Verify(user.Password, req.Password)
+	if err != nil {
+		log.Printf("verify password of %s failed: %v\n", user.Id, err)
+	}
+	if !ok {
+		u.signInFailed(user.Id, failures, device, nil)
 		return nil, fmt.Errorf("password is invalid")
 	}
+	u.rehashPassword(user.Id, user.Password, req.Password)
+
+	// The tokens wait for the second factor, so do the failures to be
+	// forgotten, only this attempt is taken back
+	if user.TotpEnabled {
+		u.releaseUserAttempt(user.Id)
+		challenge, err := auth.NewAuth(auth.Challenge, u.cfg.Jwt(), u.keyset, &users.UserClaims{
+			Id:     user.Id,
+			RoleId: user.RoleId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &users.UserPassport{ChallengeToken: challenge.SignToken()}, nil
+	}
+	u.signInSucceeded(user.Id)
 
+	return u.newPassport(&users.User{
+		Id:            user.Id,
+		Email:         user.Email,
+		Username:      user.Username,
+		RoleId:        user.RoleId,
+		EmailVerified: user.EmailVerified,
+	}, device)
+}
+
+// newPassport signs in a user whose credentials have all been checked
+func (u *usersUsecase) newPassport(user *users.User, device *users.Device) (*users.UserPassport, error) {
 	//Sign token
-	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), &users.UserClaims{
+	accessToken, err := auth.NewAuth(auth.Access, u.cfg.Jwt(), u.keyset, &users.UserClaims{
 		Id:     user.Id,
 		RoleId: user.RoleId,
 	})
-	refreshToken, err := auth.NewAuth(auth.Refresh, u.cfg.Jwt(), &users.UserClaims{
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := auth.NewAuth(auth.Refresh, u.cfg.Jwt(), u.keyset, &users.UserClaims{
 		Id:     user.Id,
 		RoleId: user.RoleId,
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	//Set passport
 	passport := &users.UserPassport{
-		User: &users.User{
-			Id:       user.Id,
-			Email:    user.Email,
-			Username: user.Username,
-			RoleId:   user.RoleId,
-		},
+		User: user,
 		Token: &users.UserToken{
 			AccessToken:  accessToken.SignToken(),
 			RefreshToken: refreshToken.SignToken(),
 		},
 	}
 
-	if err := u.usersRepository.InsertOauth(passport); err != nil {
+	if err := u.usersRepository.InsertOauth(passport, device); err != nil {
 		return nil, err
 	}
 
 	return passport, nil
 }
 
-func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users.UserPassport, error) {
+func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential, device *users.Device) (*users.UserPassport, error) {
 
 	// Parse token
-	claims, err := auth.ParseToken(u.cfg.Jwt(), req.RefreshToken)
+	claims, err := auth.ParseToken(u.keyset, req.RefreshToken)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +203,12 @@ func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users
 	if err != nil {
 		return nil, err
 	}
+	if oauth.RevokedAt != nil {
+		return nil, users.ErrRefreshTokenRevoked
+	}
+	if oauth.UsedAt != nil {
+		return nil, u.revokeReusedFamily(oauth)
+	}
 
 	// Find profile
 	profile, err := u.usersRepository.GetProfile(oauth.UserId)
@@ -130,6 +224,7 @@ func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users
 	accessToken, err := auth.NewAuth(
 		auth.Access,
 		u.cfg.Jwt(),
+		u.keyset,
 		newClaims,
 	)
 	if err != nil {
@@ -137,6 +232,7 @@ func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users
 	}
 	refreshToken := auth.RepeatToken(
 		u.cfg.Jwt(),
+		u.keyset,
 		newClaims,
 		claims.ExpiresAt.Unix(),
 	)
@@ -144,19 +240,63 @@ func (u *usersUsecase) RefreshPassport(req *users.UserRefreshCredential) (*users
 	passport := &users.UserPassport{
 		User: profile,
 		Token: &users.UserToken{
-			Id:           oauth.Id,
 			AccessToken:  accessToken.SignToken(),
 			RefreshToken: refreshToken,
 		},
 	}
-	if err := u.usersRepository.UpdateOauth(passport.Token); err != nil {
+	if err := u.usersRepository.RotateOauth(oauth, passport.Token, device); err != nil {
+		// Another refresh with the same token won the race
+		if errors.Is(err, users.ErrRefreshTokenReused) {
+			return nil, u.revokeReusedFamily(oauth)
+		}
 		return nil, err
 	}
 	return passport, nil
 }
 
-func (u *usersUsecase) DeleteOauth(oauthId string) error {
-	if err := u.usersRepository.DeleteOauth(oauthId); err != nil {
+// revokeReusedFamily handles a refresh token presented twice, either copy may
+// be stolen so the whole family is revoked and the user has to sign in again.
+func (u *usersUsecase) revokeReusedFamily(oauth *users.Oauth) error {
+	if err := u.usersRepository.RevokeOauthFamily(oauth.FamilyId); err != nil {
+		return err
+	}
+	if err := u.usersRepository.InsertSecurityEvent(&users.SecurityEvent{
+		UserId: oauth.UserId,
+		Event:  users.SecurityEventRefreshTokenReuse,
+		Details: map[string]any{
+			"oauth_id":  oauth.Id,
+			"family_id": oauth.FamilyId,
+		},
+	}); err != nil {
+		log.Printf("record security event failed: %v\n", err)
+	}
+	return users.ErrRefreshTokenReused
+}
+
+func (u *usersUsecase) DeleteOauth(userId, oauthId string) error {
+	if err := u.usersRepository.DeleteOauth(userId, oauthId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *usersUsecase) FindSessions(userId, accessToken string) ([]*users.Session, error) {
+	sessions, err := u.usersRepository.FindSessions(userId, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (u *usersUsecase) DeleteSession(userId, sessionId string) error {
+	if err := u.usersRepository.DeleteSession(userId, sessionId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *usersUsecase) DeleteSessions(userId string) error {
+	if err := u.usersRepository.DeleteSessions(userId); err != nil {
 		return err
 	}
 	return nil
@@ -169,3 +309,350 @@ func (u *usersUsecase) GetUserProfile(userId string) (*users.User, error) {
 	}
 	return profile, nil
 }
+
+// ForgotPassword mails a reset link to a registered email, the caller is
+// never told whether the email is registered.
+func (u *usersUsecase) ForgotPassword(req *users.ForgotPasswordReq) error {
+	user, err := u.usersRepository.FindOneUserByEmail(req.Email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := auth.NewOpaqueToken("")
+	if err != nil {
+		return err
+	}
+	expires := u.cfg.Mail().PasswordResetExpires()
+	if err := u.usersRepository.InsertPasswordReset(
+		user.Id,
+		auth.HashOpaqueToken(token),
+		expires,
+		u.cfg.Mail().PasswordResetInterval(),
+	); err != nil {
+		// Answered like any other request, the mail already sent is still usable
+		if errors.Is(err, users.ErrResetThrottled) {
+			return nil
+		}
+		return err
+	}
+
+	// Sent in the background so a registered email takes no longer to answer
+	link := fmt.Sprintf("%s/reset-password?token=%s", u.cfg.Mail().AppUrl(), url.QueryEscape(token))
+	go u.sendMail(&mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Text: fmt.Sprintf(
+			"Someone asked to reset the password of your account.\n\n"+
+				"Open this link within %d minutes to choose a new password:\n%s\n\n"+
+				"If it was not you, ignore this mail, your password stays the same.\n",
+			int(expires.Minutes()),
+			link,
+		),
+	})
+	return nil
+}
+
+// sendMail sends a mail away from the request, failures are only logged
+func (u *usersUsecase) sendMail(msg *mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := u.mailer.Send(ctx, msg); err != nil {
+		log.Printf("send %q failed: %v\n", msg.Subject, err)
+	}
+}
+
+// ResetPassword sets the password with a reset token, every session of the
+// user is signed out.
+func (u *usersUsecase) ResetPassword(req *users.ResetPasswordReq) error {
+	hashed, err := u.hashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	req.Password = hashed
+
+	userId, err := u.usersRepository.ResetPassword(auth.HashOpaqueToken(req.Token), req.Password)
+	if err != nil {
+		return err
+	}
+
+	u.recordSecurityEvent(userId, users.SecurityEventPasswordReset, nil)
+	return nil
+}
+
+// issueVerification mails a link confirming the email of the user
+func (u *usersUsecase) issueVerification(user *users.User) error {
+	token, err := auth.NewOpaqueToken("")
+	if err != nil {
+		return err
+	}
+	expires := u.cfg.Mail().VerificationExpires()
+	if err := u.usersRepository.InsertEmailVerification(
+		user.Id,
+		auth.HashOpaqueToken(token),
+		expires,
+		u.cfg.Mail().VerificationResendInterval(),
+	); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", u.cfg.Mail().AppUrl(), url.QueryEscape(token))
+	go u.sendMail(&mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Verify your email",
+		Text: fmt.Sprintf(
+			"Welcome %s,\n\n"+
+				"Open this link within %d hours to verify your email:\n%s\n\n"+
+				"If you did not sign up, ignore this mail.\n",
+			user.Username,
+			int(expires.Hours()),
+			link,
+		),
+	})
+	return nil
+}
+
+func (u *usersUsecase) VerifyEmail(req *users.VerifyEmailReq) error {
+	if _, err := u.usersRepository.VerifyEmail(auth.HashOpaqueToken(req.Token)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResendVerification mails a new verification link, at most once per the
+// configured interval.
+func (u *usersUsecase) ResendVerification(userId string) error {
+	profile, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return err
+	}
+	if profile.EmailVerified {
+		return users.ErrEmailAlreadyVerified
+	}
+	return u.issueVerification(profile)
+}
+
+// SignInTwoFactor exchanges the challenge of a checked password and a code
+// for the tokens.
+func (u *usersUsecase) SignInTwoFactor(req *users.TwoFactorSignInReq, device *users.Device) (*users.UserPassport, error) {
+	claims, err := auth.ParseChallengeToken(u.keyset, req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	totp, err := u.findTotp(claims.Claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !totp.Enabled {
+		return nil, users.ErrTotpNotEnabled
+	}
+	// Codes are guessed like passwords, they count against the same limits
+	failures, err := u.reserveUserAttempt(claims.Claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.checkSecondFactor(claims.Claims.Id, totp, req.Code); err != nil {
+		if errors.Is(err, users.ErrTwoFactorCodeInvalid) {
+			u.signInFailed(claims.Claims.Id, failures, device, map[string]any{"second_factor": true})
+		} else {
+			u.releaseUserAttempt(claims.Claims.Id)
+		}
+		return nil, err
+	}
+	u.signInSucceeded(claims.Claims.Id)
+
+	profile, err := u.usersRepository.GetProfile(claims.Claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	return u.newPassport(profile, device)
+}
+
+// findTotp is the authenticator of a user with its secret decrypted
+func (u *usersUsecase) findTotp(userId string) (*users.Totp, error) {
+	totp, err := u.usersRepository.FindTotp(userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Secret != nil {
+		secret, err := auth.OpenTotpSecret(u.cfg.Totp().SecretKey(), userId, *totp.Secret)
+		if err != nil {
+			return nil, err
+		}
+		totp.Secret = &secret
+	}
+	return totp, nil
+}
+
+// checkSecondFactor accepts a code of the authenticator once, or a recovery
+// code which is then used up.
+func (u *usersUsecase) checkSecondFactor(userId string, totp *users.Totp, code string) error {
+	code = strings.TrimSpace(code)
+	if totp.Secret == nil || code == "" {
+		return users.ErrTwoFactorCodeInvalid
+	}
+
+	if len(code) == auth.TotpDigits {
+		step, ok := auth.ValidateTotp(*totp.Secret, code, time.Now())
+		if !ok || step <= totp.LastStep {
+			return users.ErrTwoFactorCodeInvalid
+		}
+		return u.usersRepository.UseTotpStep(userId, step)
+	}
+
+	if err := u.usersRepository.UseRecoveryCode(userId, auth.HashRecoveryCode(code)); err != nil {
+		return err
+	}
+	u.recordSecurityEvent(userId, users.SecurityEventRecoveryCodeUsed, nil)
+	return nil
+}
+
+func (u *usersUsecase) recordSecurityEvent(userId, event string, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	if err := u.usersRepository.InsertSecurityEvent(&users.SecurityEvent{
+		UserId:  userId,
+		Event:   event,
+		Details: details,
+	}); err != nil {
+		log.Printf("record security event failed: %v\n", err)
+	}
+}
+
+// SetupTotp makes the secret of a new authenticator, it is only asked for
+// once a first code confirms the enrollment.
+func (u *usersUsecase) SetupTotp(userId string) (*users.TotpSetup, error) {
+	// Secrets are only ever stored encrypted
+	if u.cfg.Totp().SecretKey() == nil {
+		return nil, users.ErrTotpUnavailable
+	}
+
+	profile, err := u.usersRepository.GetProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := auth.NewTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := auth.SealTotpSecret(u.cfg.Totp().SecretKey(), userId, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.usersRepository.SetupTotp(userId, sealed); err != nil {
+		return nil, err
+	}
+	return &users.TotpSetup{
+		Secret: secret,
+		Uri:    auth.TotpUri(u.cfg.Totp().Issuer(), profile.Email, secret),
+	}, nil
+}
+
+// EnableTotp confirms the authenticator set up with one of its codes, the
+// recovery codes are only ever shown here.
+func (u *usersUsecase) EnableTotp(userId string, req *users.TwoFactorReq) (*users.RecoveryCodes, error) {
+	totp, err := u.findTotp(userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, users.ErrTotpAlreadyEnabled
+	}
+	if totp.Secret == nil {
+		return nil, users.ErrTotpNotSetUp
+	}
+
+	step, ok := auth.ValidateTotp(*totp.Secret, strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		return nil, users.ErrTwoFactorCodeInvalid
+	}
+
+	codes, err := auth.NewRecoveryCodes(u.cfg.Totp().RecoveryCodes())
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	if err := u.usersRepository.EnableTotp(userId, step, hashes); err != nil {
+		return nil, err
+	}
+	u.recordSecurityEvent(userId, users.SecurityEventTotpEnabled, nil)
+	return &users.RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableTotp turns two-factor off after a last code, admins, the users whose
+// role grants any permission, cannot when it is mandatory for them.
+func (u *usersUsecase) DisableTotp(userId string, req *users.TwoFactorReq) error {
+	if u.cfg.Totp().AdminRequired() {
+		privileged, err := u.usersRepository.IsPrivileged(userId)
+		if err != nil {
+			return err
+		}
+		if privileged {
+			return users.ErrTotpRequired
+		}
+	}
+
+	totp, err := u.findTotp(userId)
+	if err != nil {
+		return err
+	}
+	if !totp.Enabled {
+		return users.ErrTotpNotEnabled
+	}
+	if err := u.checkSecondFactor(userId, totp, req.Code); err != nil {
+		return err
+	}
+
+	if err := u.usersRepository.DisableTotp(userId); err != nil {
+		return err
+	}
+	u.recordSecurityEvent(userId, users.SecurityEventTotpDisabled, nil)
+	return nil
+}
+
+// SealTotpSecrets encrypts the secrets stored before they were, once a key is
+// configured. They keep working unencrypted until then.
+func (u *usersUsecase) SealTotpSecrets() error {
+	key := u.cfg.Totp().SecretKey()
+	if key == nil {
+		return nil
+	}
+
+	secrets, err := u.usersRepository.FindPlainTotpSecrets(auth.TotpSealedPrefix)
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets {
+		sealed, err := auth.SealTotpSecret(key, s.UserId, s.Secret)
+		if err != nil {
+			return err
+		}
+		if err := u.usersRepository.SealTotpSecret(s.UserId, s.Secret, sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rehashPassword upgrades the hash of a password just verified when it was
+// made with an older algorithm or parameters, the sign in goes on regardless.
+func (u *usersUsecase) rehashPassword(userId, hash, plain string) {
+	if !u.hasher.NeedsRehash(hash) {
+		return
+	}
+	rehashed, err := u.hasher.Hash(plain)
+	if err != nil {
+		log.Printf("rehash password of %s failed: %v\n", userId, err)
+		return
+	}
+	if err := u.usersRepository.UpdatePassword(userId, hash, rehashed); err != nil {
+		log.Printf("rehash password of %s failed: %v\n", userId, err)
+	}
+}
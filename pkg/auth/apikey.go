@@ -0,0 +1,17 @@
+package auth
+
+// ApiKeyPrefixLen is how much of a key is kept in clear to tell keys apart
+const ApiKeyPrefixLen = 12
+
+// NewApiKeySecret makes a random api key, only its hash is ever stored
+func NewApiKeySecret() (key, prefix string, err error) {
+	key, err = NewOpaqueToken("sk_")
+	if err != nil {
+		return "", "", err
+	}
+	return key, key[:ApiKeyPrefixLen], nil
+}
+
+func HashApiKey(key string) string {
+	return HashOpaqueToken(key)
+}
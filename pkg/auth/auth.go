@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/jetsadawwts/go-restapi/config"
 	"github.com/jetsadawwts/go-restapi/modules/users"
 )
@@ -18,11 +19,18 @@ const (
 	Refresh TokenType = "refresh"
 	Admin   TokenType = "admin"
 	ApiKey  TokenType = "apikey"
+	// Challenge stands for a password checked, it is exchanged with a second
+	// factor for the access and refresh tokens.
+	Challenge TokenType = "challenge"
 )
 
+const challengeSubject = "challenge-token"
+
 type auth struct {
 	mapClaims *mapClaims
 	cfg       config.IJwtConfig
+	keyset    IKeyset
+	use       KeyUse
 }
 
 type admin struct {
@@ -58,31 +66,18 @@ func jwtTimeRepeatAdapter(t int64) *jwt.NumericDate {
 	return jwt.NewNumericDate(time.Unix(t, 0))
 }
 
+// SignToken signs with the primary key of the token's use, the kid header
+// tells which key to verify it with.
 func (a *auth) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.SecretKey())
-	return ss
-}
-
-func (a *admin) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.AdminKey())
-	return ss
-}
-
-func (a *apiKey) SignToken() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, a.mapClaims)
-	ss, _ := token.SignedString(a.cfg.ApiKey())
+	key := a.keyset.SigningKey(a.use)
+	token := jwt.NewWithClaims(key.Method, a.mapClaims)
+	token.Header["kid"] = key.Kid
+	ss, _ := token.SignedString(key.signKey)
 	return ss
 }
 
-func ParseToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-		return cfg.SecretKey(), nil
-	})
+func parseToken(keyset IKeyset, use KeyUse, tokenString string) (*mapClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, keyset.Keyfunc(use))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenMalformed) {
 			return nil, fmt.Errorf("token format is invalid")
@@ -100,64 +95,49 @@ func ParseToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
 	}
 }
 
-
-
-func ParseAdminToken(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-		return cfg.AdminKey(), nil
-	})
+func ParseToken(keyset IKeyset, tokenString string) (*mapClaims, error) {
+	claims, err := parseToken(keyset, UserKeys, tokenString)
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, fmt.Errorf("token format is invalid")
-		} else if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, fmt.Errorf("token had expired")
-		} else {
-			return nil, fmt.Errorf("parse token failed: %v", err)
-		}
+		return nil, err
 	}
-
-	if claims, ok := token.Claims.(*mapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("claims type is invalid")
+	// Signed with the same keys, but a challenge grants nothing by itself
+	if claims.Subject == challengeSubject {
+		return nil, fmt.Errorf("token type is invalid")
 	}
+	return claims, nil
 }
 
-
-func ParseApiKey(cfg config.IJwtConfig, tokenString string) (*mapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &mapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("signing method is invalid")
-		}
-		return cfg.ApiKey(), nil
-	})
+func ParseChallengeToken(keyset IKeyset, tokenString string) (*mapClaims, error) {
+	claims, err := parseToken(keyset, UserKeys, tokenString)
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, fmt.Errorf("token format is invalid")
-		} else if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, fmt.Errorf("token had expired")
-		} else {
-			return nil, fmt.Errorf("parse token failed: %v", err)
-		}
+		return nil, err
 	}
-
-	if claims, ok := token.Claims.(*mapClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("claims type is invalid")
+	if claims.Subject != challengeSubject {
+		return nil, fmt.Errorf("token type is invalid")
 	}
+	return claims, nil
+}
+
+func ParseAdminToken(keyset IKeyset, tokenString string) (*mapClaims, error) {
+	return parseToken(keyset, AdminKeys, tokenString)
+}
+
+func ParseApiKey(keyset IKeyset, tokenString string) (*mapClaims, error) {
+	return parseToken(keyset, ApiKeyKeys, tokenString)
 }
 
-func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) string {
+// RepeatToken signs a new refresh token that expires with the one it replaces,
+// the id makes every token of a family unique.
+func RepeatToken(cfg config.IJwtConfig, keyset IKeyset, claims *users.UserClaims, exp int64) string {
 	obj := &auth{
-		cfg: cfg,
+		cfg:    cfg,
+		keyset: keyset,
+		use:    UserKeys,
 		mapClaims: &mapClaims{
 			Claims: claims,
 			RegisteredClaims: jwt.RegisteredClaims{
 				Issuer:    "shop-api",
+				ID:        uuid.NewString(),
 				Subject:   "refresh-token",
 				Audience:  []string{"customer", "admin"},
 				ExpiresAt: jwtTimeRepeatAdapter(exp),
@@ -170,24 +150,28 @@ func RepeatToken(cfg config.IJwtConfig, claims *users.UserClaims, exp int64) str
 	return obj.SignToken()
 }
 
-func NewAuth(TokenType TokenType, cfg config.IJwtConfig, claims *users.UserClaims) (IAuth, error) {
+func NewAuth(TokenType TokenType, cfg config.IJwtConfig, keyset IKeyset, claims *users.UserClaims) (IAuth, error) {
 	switch TokenType {
 	case Access:
-		return newAccessToken(cfg, claims), nil
+		return newAccessToken(cfg, keyset, claims), nil
 	case Refresh:
-		return newRefreshToken(cfg, claims), nil
+		return newRefreshToken(cfg, keyset, claims), nil
 	case Admin:
-		return newAdminToken(cfg), nil
+		return newAdminToken(cfg, keyset), nil
 	case ApiKey:
-		return newApiKey(cfg), nil
+		return newApiKey(cfg, keyset), nil
+	case Challenge:
+		return newChallengeToken(cfg, keyset, claims), nil
 	default:
 		return nil, fmt.Errorf("unknow token type.")
 	}
 }
 
-func newAccessToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
+func newAccessToken(cfg config.IJwtConfig, keyset IKeyset, claims *users.UserClaims) IAuth {
 	return &auth{
-		cfg: cfg,
+		cfg:    cfg,
+		keyset: keyset,
+		use:    UserKeys,
 		mapClaims: &mapClaims{
 			Claims: claims,
 			RegisteredClaims: jwt.RegisteredClaims{
@@ -202,10 +186,12 @@ func newAccessToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 	}
 }
 
-func newAdminToken(cfg config.IJwtConfig) IAdmin {
+func newAdminToken(cfg config.IJwtConfig, keyset IKeyset) IAdmin {
 	return &admin{
 		auth: &auth{
-			cfg: cfg,
+			cfg:    cfg,
+			keyset: keyset,
+			use:    AdminKeys,
 			mapClaims: &mapClaims{
 				Claims: nil,
 				RegisteredClaims: jwt.RegisteredClaims{
@@ -221,10 +207,12 @@ func newAdminToken(cfg config.IJwtConfig) IAdmin {
 	}
 }
 
-func newApiKey(cfg config.IJwtConfig) IAdmin {
+func newApiKey(cfg config.IJwtConfig, keyset IKeyset) IAdmin {
 	return &apiKey{
 		auth: &auth{
-			cfg: cfg,
+			cfg:    cfg,
+			keyset: keyset,
+			use:    ApiKeyKeys,
 			mapClaims: &mapClaims{
 				Claims: nil,
 				RegisteredClaims: jwt.RegisteredClaims{
@@ -240,13 +228,16 @@ func newApiKey(cfg config.IJwtConfig) IAdmin {
 	}
 }
 
-func newRefreshToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
+func newRefreshToken(cfg config.IJwtConfig, keyset IKeyset, claims *users.UserClaims) IAuth {
 	return &auth{
-		cfg: cfg,
+		cfg:    cfg,
+		keyset: keyset,
+		use:    UserKeys,
 		mapClaims: &mapClaims{
 			Claims: claims,
 			RegisteredClaims: jwt.RegisteredClaims{
 				Issuer:    "shop-api",
+				ID:        uuid.NewString(),
 				Subject:   "refresh-token",
 				Audience:  []string{"customer", "admin"},
 				ExpiresAt: jwtTimeDurationCal(cfg.RefreshExpiresAt()),
@@ -256,3 +247,22 @@ func newRefreshToken(cfg config.IJwtConfig, claims *users.UserClaims) IAuth {
 		},
 	}
 }
+
+func newChallengeToken(cfg config.IJwtConfig, keyset IKeyset, claims *users.UserClaims) IAuth {
+	return &auth{
+		cfg:    cfg,
+		keyset: keyset,
+		use:    UserKeys,
+		mapClaims: &mapClaims{
+			Claims: claims,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "shop-api",
+				Subject:   challengeSubject,
+				Audience:  []string{"customer", "admin"},
+				ExpiresAt: jwtTimeDurationCal(cfg.ChallengeExpiresAt()),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		},
+	}
+}
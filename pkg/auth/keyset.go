@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+// KeyUse groups the keys of one kind of token, a key never verifies the
+// tokens of another use.
+type KeyUse string
+
+const (
+	UserKeys   KeyUse = "user" // access and refresh tokens
+	AdminKeys  KeyUse = "admin"
+	ApiKeyKeys KeyUse = "apikey"
+)
+
+type KeyStatus string
+
+const (
+	KeyPrimary KeyStatus = "primary" // signs new tokens, one per use
+	KeyActive  KeyStatus = "active"  // only verifies, a key being introduced or phased out
+	KeyRetired KeyStatus = "retired" // the tokens it signed are rejected
+)
+
+// The kids of the keys made of the JWT_*_KEY secrets when no keyset file is
+// configured, a keyset file keeping these secrets should keep their kids.
+const (
+	LegacyUserKid   = "user-hs256"
+	LegacyAdminKid  = "admin-hs256"
+	LegacyApiKeyKid = "apikey-hs256"
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	ErrKeyRetired  = errors.New("signing key has been retired")
+)
+
+// keyDef is a key of the keyset file, e.g.
+//
+//	{"keys": [
+//		{"kid": "2026-10", "use": "user", "alg": "RS256", "status": "primary", "private_key": "keys/2026-10.pem"},
+//		{"kid": "user-hs256", "use": "user", "alg": "HS256", "status": "active", "secret": "..."}
+//	]}
+//
+// Key files are pem encoded and relative to the keyset file.
+type keyDef struct {
+	Kid        string    `json:"kid"`
+	Use        KeyUse    `json:"use"`
+	Alg        string    `json:"alg"` // HS256 | HS384 | HS512 | RS256 | RS384 | RS512 | EdDSA
+	Status     KeyStatus `json:"status"`
+	Secret     string    `json:"secret"`      // hmac
+	PrivateKey string    `json:"private_key"` // rsa and eddsa
+	PublicKey  string    `json:"public_key"`  // rsa and eddsa keys that only verify
+}
+
+type Key struct {
+	Kid       string
+	Use       KeyUse
+	Status    KeyStatus
+	Method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+type IKeyset interface {
+	// SigningKey is the primary key of the use
+	SigningKey(use KeyUse) *Key
+	// Keyfunc finds the key a token of the use is verified with
+	Keyfunc(use KeyUse) jwt.Keyfunc
+	// Jwks publishes the public keys of the access tokens
+	Jwks() *Jwks
+}
+
+type keyset struct {
+	keys    map[string]*Key
+	byUse   map[KeyUse][]*Key
+	primary map[KeyUse]*Key
+}
+
+// NewKeyset loads the keyset file of the config, or makes one key of each
+// JWT_*_KEY secret when there is none.
+func NewKeyset(cfg config.IJwtConfig) (IKeyset, error) {
+	defs := []*keyDef{
+		{Kid: LegacyUserKid, Use: UserKeys, Alg: "HS256", Status: KeyPrimary, Secret: string(cfg.SecretKey())},
+		{Kid: LegacyAdminKid, Use: AdminKeys, Alg: "HS256", Status: KeyPrimary, Secret: string(cfg.AdminKey())},
+		{Kid: LegacyApiKeyKid, Use: ApiKeyKeys, Alg: "HS256", Status: KeyPrimary, Secret: string(cfg.ApiKey())},
+	}
+	dir := ""
+	if cfg.KeysetFile() != "" {
+		b, err := os.ReadFile(cfg.KeysetFile())
+		if err != nil {
+			return nil, fmt.Errorf("read keyset failed: %v", err)
+		}
+		file := new(struct {
+			Keys []*keyDef `json:"keys"`
+		})
+		if err := json.Unmarshal(b, file); err != nil {
+			return nil, fmt.Errorf("parse keyset failed: %v", err)
+		}
+		defs = file.Keys
+		dir = filepath.Dir(cfg.KeysetFile())
+	}
+
+	k := &keyset{
+		keys:    make(map[string]*Key),
+		byUse:   make(map[KeyUse][]*Key),
+		primary: make(map[KeyUse]*Key),
+	}
+	for _, def := range defs {
+		key, err := newKey(def, dir)
+		if err != nil {
+			return nil, fmt.Errorf("load key %q failed: %v", def.Kid, err)
+		}
+		if _, ok := k.keys[key.Kid]; ok {
+			return nil, fmt.Errorf("load key %q failed: kid is duplicated", key.Kid)
+		}
+		if key.Status == KeyPrimary {
+			if _, ok := k.primary[key.Use]; ok {
+				return nil, fmt.Errorf("load key %q failed: %s already has a primary key", key.Kid, key.Use)
+			}
+			k.primary[key.Use] = key
+		}
+		k.keys[key.Kid] = key
+		k.byUse[key.Use] = append(k.byUse[key.Use], key)
+	}
+
+	for _, use := range []KeyUse{UserKeys, AdminKeys, ApiKeyKeys} {
+		if _, ok := k.primary[use]; !ok {
+			return nil, fmt.Errorf("load keyset failed: %s has no primary key", use)
+		}
+	}
+	return k, nil
+}
+
+func newKey(def *keyDef, dir string) (*Key, error) {
+	if def.Kid == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+	switch def.Use {
+	case UserKeys, AdminKeys, ApiKeyKeys:
+	default:
+		return nil, fmt.Errorf("use %q is invalid", def.Use)
+	}
+	switch def.Status {
+	case KeyPrimary, KeyActive, KeyRetired:
+	default:
+		return nil, fmt.Errorf("status %q is invalid", def.Status)
+	}
+
+	key := &Key{
+		Kid:    def.Kid,
+		Use:    def.Use,
+		Status: def.Status,
+		Method: jwt.GetSigningMethod(def.Alg),
+	}
+	readPem := func(path string) ([]byte, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return os.ReadFile(path)
+	}
+
+	switch key.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if def.Secret == "" {
+			return nil, fmt.Errorf("secret is required")
+		}
+		key.signKey = []byte(def.Secret)
+		key.verifyKey = []byte(def.Secret)
+	case *jwt.SigningMethodRSA:
+		if def.PrivateKey != "" {
+			b, err := readPem(def.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else if def.PublicKey != "" {
+			b, err := readPem(def.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(b); err != nil {
+				return nil, err
+			}
+		}
+	case *jwt.SigningMethodEd25519:
+		if def.PrivateKey != "" {
+			b, err := readPem(def.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(b)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = private.(ed25519.PrivateKey).Public()
+		} else if def.PublicKey != "" {
+			b, err := readPem(def.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(b); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("alg %q is not supported", def.Alg)
+	}
+
+	if key.verifyKey == nil {
+		return nil, fmt.Errorf("private_key or public_key is required")
+	}
+	if key.Status == KeyPrimary && key.signKey == nil {
+		return nil, fmt.Errorf("a primary key needs its private_key")
+	}
+	return key, nil
+}
+
+func (k *keyset) SigningKey(use KeyUse) *Key {
+	return k.primary[use]
+}
+
+func (k *keyset) Keyfunc(use KeyUse) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid != "" {
+			key, ok := k.keys[kid]
+			if !ok || key.Use != use {
+				return nil, ErrKeyNotFound
+			}
+			if key.Status == KeyRetired {
+				return nil, ErrKeyRetired
+			}
+			if key.Method.Alg() != t.Method.Alg() {
+				return nil, fmt.Errorf("signing method is invalid")
+			}
+			return key.verifyKey, nil
+		}
+
+		// Tokens signed before the keyset carry no kid
+		set := jwt.VerificationKeySet{}
+		for _, key := range k.byUse[use] {
+			if key.Status != KeyRetired && key.Method.Alg() == t.Method.Alg() {
+				set.Keys = append(set.Keys, key.verifyKey)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("signing method is invalid")
+		}
+		return set, nil
+	}
+}
+
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type Jwks struct {
+	Keys []*Jwk `json:"keys"`
+}
+
+// Jwks lists the asymmetric keys still verifying access tokens, the hmac
+// secrets are never published.
+func (k *keyset) Jwks() *Jwks {
+	jwks := &Jwks{Keys: make([]*Jwk, 0)}
+	for _, key := range k.byUse[UserKeys] {
+		if key.Status == KeyRetired {
+			continue
+		}
+
+		jwk := &Jwk{
+			Kid: key.Kid,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtConfig stands in for the loaded config
+type jwtConfig struct {
+	keysetFile string
+}
+
+func (c *jwtConfig) SecretKey() []byte          { return []byte("user-secret") }
+func (c *jwtConfig) AdminKey() []byte           { return []byte("admin-secret") }
+func (c *jwtConfig) ApiKey() []byte             { return []byte("apikey-secret") }
+func (c *jwtConfig) KeysetFile() string         { return c.keysetFile }
+func (c *jwtConfig) LegacyApiKeys() bool        { return false }
+func (c *jwtConfig) BootstrapApiKey() string    { return "" }
+func (c *jwtConfig) AccessExpiresAt() int       { return 60 }
+func (c *jwtConfig) RefreshExpiresAt() int      { return 60 }
+func (c *jwtConfig) ChallengeExpiresAt() int    { return 60 }
+func (c *jwtConfig) SetJwtAccessExpires(t int)  {}
+func (c *jwtConfig) SetJwtRefreshExpires(t int) {}
+
+// keysetFixture writes the key files and a keyset file of the defs to a temp
+// dir, the pem paths of the defs are relative to it.
+type keysetFixture struct {
+	dir   string
+	rsa   *rsa.PrivateKey
+	oldEd ed25519.PrivateKey
+	newEd ed25519.PrivateKey
+}
+
+func newKeysetFixture(t *testing.T) *keysetFixture {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key failed: %v", err)
+	}
+	_, oldEd, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key failed: %v", err)
+	}
+	_, newEd, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key failed: %v", err)
+	}
+	f := &keysetFixture{dir: t.TempDir(), rsa: rsaKey, oldEd: oldEd, newEd: newEd}
+
+	f.writePem(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	private, err := x509.MarshalPKCS8PrivateKey(oldEd)
+	if err != nil {
+		t.Fatalf("marshal ed25519 key failed: %v", err)
+	}
+	f.writePem(t, "old-ed.pem", "PRIVATE KEY", private)
+	public, err := x509.MarshalPKIXPublicKey(newEd.Public())
+	if err != nil {
+		t.Fatalf("marshal ed25519 public key failed: %v", err)
+	}
+	f.writePem(t, "new-ed.pub.pem", "PUBLIC KEY", public)
+	return f
+}
+
+func (f *keysetFixture) writePem(t *testing.T, name, blockType string, b []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b})
+	if err := os.WriteFile(filepath.Join(f.dir, name), data, 0600); err != nil {
+		t.Fatalf("write %s failed: %v", name, err)
+	}
+}
+
+func (f *keysetFixture) keyset(t *testing.T, defs []*keyDef) (IKeyset, error) {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{"keys": defs})
+	if err != nil {
+		t.Fatalf("marshal keyset failed: %v", err)
+	}
+	path := filepath.Join(f.dir, "keyset.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("write keyset failed: %v", err)
+	}
+	return NewKeyset(&jwtConfig{keysetFile: path})
+}
+
+// rotatingDefs is a keyset in the middle of moving the user tokens from hmac
+// to rsa, with an ed25519 key retired and another only verifying.
+func rotatingDefs() []*keyDef {
+	return []*keyDef{
+		{Kid: "2026-10", Use: UserKeys, Alg: "RS256", Status: KeyPrimary, PrivateKey: "rsa.pem"},
+		{Kid: LegacyUserKid, Use: UserKeys, Alg: "HS256", Status: KeyActive, Secret: "user-secret"},
+		{Kid: "ed-old", Use: UserKeys, Alg: "EdDSA", Status: KeyRetired, PrivateKey: "old-ed.pem"},
+		{Kid: "ed-new", Use: UserKeys, Alg: "EdDSA", Status: KeyActive, PublicKey: "new-ed.pub.pem"},
+		{Kid: LegacyAdminKid, Use: AdminKeys, Alg: "HS256", Status: KeyPrimary, Secret: "admin-secret"},
+		{Kid: LegacyApiKeyKid, Use: ApiKeyKeys, Alg: "HS256", Status: KeyPrimary, Secret: "apikey-secret"},
+	}
+}
+
+func TestKeysetKeyfunc(t *testing.T) {
+	f := newKeysetFixture(t)
+	k, err := f.keyset(t, rotatingDefs())
+	if err != nil {
+		t.Fatalf("NewKeyset failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    any
+		use    KeyUse
+		err    error // nil for a token that verifies
+	}{
+		{"primary rsa", jwt.SigningMethodRS256, "2026-10", f.rsa, UserKeys, nil},
+		{"active hmac", jwt.SigningMethodHS256, LegacyUserKid, []byte("user-secret"), UserKeys, nil},
+		{"active ed25519", jwt.SigningMethodEdDSA, "ed-new", f.newEd, UserKeys, nil},
+		{"hmac signed before the keyset", jwt.SigningMethodHS256, "", []byte("user-secret"), UserKeys, nil},
+		{"admin hmac", jwt.SigningMethodHS256, LegacyAdminKid, []byte("admin-secret"), AdminKeys, nil},
+		{"retired ed25519", jwt.SigningMethodEdDSA, "ed-old", f.oldEd, UserKeys, ErrKeyRetired},
+		{"unknown kid", jwt.SigningMethodHS256, "2020-01", []byte("user-secret"), UserKeys, ErrKeyNotFound},
+		{"user key for an admin token", jwt.SigningMethodRS256, "2026-10", f.rsa, AdminKeys, ErrKeyNotFound},
+		{"admin secret without a kid for a user token", jwt.SigningMethodHS256, "", []byte("admin-secret"), UserKeys, jwt.ErrTokenSignatureInvalid},
+		{"wrong secret", jwt.SigningMethodHS256, LegacyUserKid, []byte("guessed"), UserKeys, jwt.ErrTokenSignatureInvalid},
+		// The rsa public key must not be taken as an hmac secret
+		{"hmac under the kid of an rsa key", jwt.SigningMethodHS256, "2026-10", []byte("user-secret"), UserKeys, jwt.ErrTokenUnverifiable},
+		{"ed25519 without a kid", jwt.SigningMethodEdDSA, "", f.newEd, UserKeys, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, jwt.MapClaims{"sub": "U000001"})
+			if tt.kid != "" {
+				token.Header["kid"] = tt.kid
+			}
+			signed, err := token.SignedString(tt.key)
+			if err != nil {
+				t.Fatalf("sign token failed: %v", err)
+			}
+
+			_, err = jwt.Parse(signed, k.Keyfunc(tt.use))
+			if tt.err == nil && err != nil {
+				t.Errorf("Parse = %v, want the token verified", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Parse = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestKeysetJwks(t *testing.T) {
+	f := newKeysetFixture(t)
+	k, err := f.keyset(t, rotatingDefs())
+	if err != nil {
+		t.Fatalf("NewKeyset failed: %v", err)
+	}
+
+	// Only the asymmetric user keys still verifying are published
+	want := map[string]*Jwk{
+		"2026-10": {
+			Kty: "RSA", Kid: "2026-10", Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(f.rsa.N.Bytes()),
+			E: "AQAB",
+		},
+		"ed-new": {
+			Kty: "OKP", Kid: "ed-new", Use: "sig", Alg: "EdDSA", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(f.newEd.Public().(ed25519.PublicKey)),
+		},
+	}
+	got := k.Jwks().Keys
+	if len(got) != len(want) {
+		t.Fatalf("Jwks has %d keys, want %d", len(got), len(want))
+	}
+	for _, jwk := range got {
+		if w, ok := want[jwk.Kid]; !ok || *w != *jwk {
+			t.Errorf("Jwks key %+v, want %+v", jwk, w)
+		}
+	}
+
+	legacy, err := NewKeyset(&jwtConfig{})
+	if err != nil {
+		t.Fatalf("NewKeyset without a keyset file failed: %v", err)
+	}
+	if keys := legacy.Jwks().Keys; keys == nil || len(keys) != 0 {
+		t.Errorf("Jwks of the hmac secrets = %v, want an empty list", keys)
+	}
+}
+
+func TestNewKeyset(t *testing.T) {
+	f := newKeysetFixture(t)
+	with := func(change func(defs []*keyDef) []*keyDef) []*keyDef {
+		return change(rotatingDefs())
+	}
+
+	tests := []struct {
+		name string
+		defs []*keyDef
+		ok   bool
+	}{
+		{"rotating", rotatingDefs(), true},
+		{"duplicated kid", with(func(d []*keyDef) []*keyDef { d[1].Kid = "2026-10"; return d }), false},
+		{"two primary keys", with(func(d []*keyDef) []*keyDef { d[1].Status = KeyPrimary; return d }), false},
+		{"use without a primary key", with(func(d []*keyDef) []*keyDef { return d[:5] }), false},
+		{"primary key without its private key", with(func(d []*keyDef) []*keyDef {
+			d[0] = &keyDef{Kid: "ed-new", Use: UserKeys, Alg: "EdDSA", Status: KeyPrimary, PublicKey: "new-ed.pub.pem"}
+			return d[:3]
+		}), false},
+		{"unsupported alg", with(func(d []*keyDef) []*keyDef { d[1].Alg = "none"; return d }), false},
+		{"unknown use", with(func(d []*keyDef) []*keyDef { d[1].Use = "mobile"; return d }), false},
+		{"unknown status", with(func(d []*keyDef) []*keyDef { d[1].Status = "paused"; return d }), false},
+		{"missing kid", with(func(d []*keyDef) []*keyDef { d[1].Kid = ""; return d }), false},
+		{"hmac without a secret", with(func(d []*keyDef) []*keyDef { d[1].Secret = ""; return d }), false},
+		{"missing key file", with(func(d []*keyDef) []*keyDef { d[0].PrivateKey = "missing.pem"; return d }), false},
+		{"key file of another alg", with(func(d []*keyDef) []*keyDef { d[0].PrivateKey = "old-ed.pem"; return d }), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.keyset(t, tt.defs); (err == nil) != tt.ok {
+				t.Errorf("NewKeyset error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	if _, err := NewKeyset(&jwtConfig{keysetFile: filepath.Join(f.dir, "missing.json")}); err == nil {
+		t.Errorf("NewKeyset accepted a missing keyset file")
+	}
+}
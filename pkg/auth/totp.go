@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The RFC 6238 defaults, the only ones every authenticator app understands
+const (
+	TotpDigits = 6
+	TotpPeriod = 30 * time.Second
+	// TotpSkew is how many periods a code may be early or late by
+	TotpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret makes the shared secret of an authenticator, base32 encoded
+// like the apps expect it.
+func NewTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate totp secret failed: %v", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TotpUri is the otpauth uri an authenticator app enrolls with, usually shown
+// as a qr code.
+func TotpUri(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TotpDigits))
+	q.Set("period", fmt.Sprint(int(TotpPeriod.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TotpStep is the counter of the period a time falls in
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / int64(TotpPeriod.Seconds())
+}
+
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TotpDigits, code%1000000)
+}
+
+// TotpCode is the code of a secret at a time
+func TotpCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decode totp secret failed: %v", err)
+	}
+	return totpCode(key, TotpStep(t)), nil
+}
+
+// ValidateTotp checks a code against the periods around a time, it returns
+// the step the code belongs to so the caller can refuse to see it twice.
+func ValidateTotp(secret, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != TotpDigits {
+		return 0, false
+	}
+
+	now := TotpStep(t)
+	for step := now - TotpSkew; step <= now+TotpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryAlphabet leaves out the characters easily mistaken for others
+const recoveryAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewRecoveryCodes makes the single-use codes signing in without the
+// authenticator, e.g. ABCDE-FGHJK. Only their hashes are meant to be stored.
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate recovery code failed: %v", err)
+		}
+		for j := range b {
+			b[j] = recoveryAlphabet[int(b[j])%len(recoveryAlphabet)]
+		}
+		codes = append(codes, string(b[:5])+"-"+string(b[5:]))
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode is the lookup hash of a recovery code however it was typed
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashOpaqueToken(code)
+}
+
+// TotpSealedPrefix marks a secret encrypted by SealTotpSecret, the secrets stored
+// before they were encrypted have none.
+const TotpSealedPrefix = "aesgcm:"
+
+var ErrTotpKeyMissing = errors.New("totp secret is encrypted and no key is configured")
+
+// SealTotpSecret encrypts a secret with AES-256-GCM before it is stored, the
+// user id is authenticated with it so a secret copied to another row fails to open.
+func SealTotpSecret(key []byte, userId, secret string) (string, error) {
+	gcm, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce failed: %v", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(userId))
+	return TotpSealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenTotpSecret decrypts a stored secret, one stored before encryption is
+// returned as it is.
+func OpenTotpSecret(key []byte, userId, stored string) (string, error) {
+	if !IsTotpSecretSealed(stored) {
+		return stored, nil
+	}
+	if len(key) == 0 {
+		return "", ErrTotpKeyMissing
+	}
+	gcm, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, TotpSealedPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("totp secret is malformed")
+	}
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(userId))
+	if err != nil {
+		return "", fmt.Errorf("open totp secret failed: %v", err)
+	}
+	return string(secret), nil
+}
+
+// IsTotpSecretSealed tells a secret encrypted by SealTotpSecret from one stored before
+func IsTotpSecretSealed(stored string) bool {
+	return strings.HasPrefix(stored, TotpSealedPrefix)
+}
+
+func totpCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("totp key is invalid: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the sha1 seed of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, the last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := TotpCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TotpCode(%d) failed: %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("TotpCode(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := TotpCode(rfc6238Secret, now)
+	if err != nil {
+		t.Fatalf("TotpCode failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		step   int64
+		ok     bool
+	}{
+		{"current period", rfc6238Secret, code, now, TotpStep(now), true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, now, TotpStep(now), true},
+		{"one period late", rfc6238Secret, code, now.Add(TotpPeriod), TotpStep(now), true},
+		{"one period early", rfc6238Secret, code, now.Add(-TotpPeriod), TotpStep(now), true},
+		{"two periods late", rfc6238Secret, code, now.Add(2 * TotpPeriod), 0, false},
+		{"two periods early", rfc6238Secret, code, now.Add(-2 * TotpPeriod), 0, false},
+		{"wrong code", rfc6238Secret, "000000", now, 0, false},
+		{"short code", rfc6238Secret, code[:5], now, 0, false},
+		{"secret not base32", "not-base32!", code, now, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTotp(tt.secret, tt.code, tt.at)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("ValidateTotp = (%d, %v), want (%d, %v)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+// The step a code is accepted for stays the same across the skew window, the
+// caller refusing a step it has seen refuses the replayed code.
+func TestValidateTotpReplay(t *testing.T) {
+	issued := time.Unix(1234567890, 0)
+	code, err := TotpCode(rfc6238Secret, issued)
+	if err != nil {
+		t.Fatalf("TotpCode failed: %v", err)
+	}
+
+	first, ok := ValidateTotp(rfc6238Secret, code, issued)
+	if !ok {
+		t.Fatalf("ValidateTotp refused a fresh code")
+	}
+	replayed, ok := ValidateTotp(rfc6238Secret, code, issued.Add(TotpPeriod))
+	if !ok {
+		t.Fatalf("ValidateTotp refused a code within the skew")
+	}
+	if first != replayed {
+		t.Errorf("replayed code got step %d, first use got %d", replayed, first)
+	}
+
+	next, err := TotpCode(rfc6238Secret, issued.Add(TotpPeriod))
+	if err != nil {
+		t.Fatalf("TotpCode failed: %v", err)
+	}
+	step, ok := ValidateTotp(rfc6238Secret, next, issued.Add(TotpPeriod))
+	if !ok || step <= first {
+		t.Errorf("code of the next period got (%d, %v), want a step after %d", step, ok, first)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, err := NewRecoveryCodes(3)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != 3 {
+		t.Fatalf("NewRecoveryCodes made %d codes, want 3", len(codes))
+	}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q is not like ABCDE-FGHJK", code)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		typed string
+		match bool
+	}{
+		{"as issued", "ABCDE-FGHJK", true},
+		{"lowercase", "abcde-fghjk", true},
+		{"without dash", "ABCDEFGHJK", true},
+		{"with spaces", "ABCDE FGHJK", true},
+		{"another code", "ABCDE-FGHJL", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashRecoveryCode(tt.typed) == HashRecoveryCode("ABCDE-FGHJK"); got != tt.match {
+				t.Errorf("HashRecoveryCode(%q) matched = %v, want %v", tt.typed, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestSealTotpSecret(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := SealTotpSecret(key, "U000001", rfc6238Secret)
+	if err != nil {
+		t.Fatalf("SealTotpSecret failed: %v", err)
+	}
+	if !IsTotpSecretSealed(sealed) || strings.Contains(sealed, rfc6238Secret) {
+		t.Fatalf("sealed secret %q is not encrypted", sealed)
+	}
+
+	tests := []struct {
+		name   string
+		key    []byte
+		userId string
+		stored string
+		want   string
+		ok     bool
+	}{
+		{"sealed", key, "U000001", sealed, rfc6238Secret, true},
+		{"stored before encryption", key, "U000001", rfc6238Secret, rfc6238Secret, true},
+		{"stored before encryption without a key", nil, "U000001", rfc6238Secret, rfc6238Secret, true},
+		{"sealed without a key", nil, "U000001", sealed, "", false},
+		{"another key", []byte("fedcba9876543210fedcba9876543210"), "U000001", sealed, "", false},
+		{"copied to another user", key, "U000002", sealed, "", false},
+		{"tampered", key, "U000001", sealed[:len(sealed)-2] + "AA", "", false},
+		{"truncated", key, "U000001", TotpSealedPrefix + "AAAA", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenTotpSecret(tt.key, tt.userId, tt.stored)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("OpenTotpSecret = (%q, %v), want (%q, ok %v)", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
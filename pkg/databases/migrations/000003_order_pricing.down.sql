@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "total_paid";
+ALTER TABLE "products_orders" DROP COLUMN IF EXISTS "line_total";
+ALTER TABLE "products_orders" DROP COLUMN IF EXISTS "unit_price";
+
+COMMIT;
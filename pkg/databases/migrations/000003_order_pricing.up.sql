@@ -0,0 +1,23 @@
+BEGIN;
+
+--Per-line price snapshot taken from the catalog at order time
+ALTER TABLE "products_orders" ADD COLUMN "unit_price" FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE "products_orders" ADD COLUMN "line_total" FLOAT NOT NULL DEFAULT 0;
+
+--Order total persisted instead of re-derived from the product json
+ALTER TABLE "orders" ADD COLUMN "total_paid" FLOAT NOT NULL DEFAULT 0;
+
+--Backfill existing orders from their product snapshots
+UPDATE "products_orders" SET
+    "unit_price" = COALESCE(("product"->>'price')::FLOAT, 0),
+    "line_total" = COALESCE(("product"->>'price')::FLOAT, 0) * "qty";
+
+UPDATE "orders" "o" SET
+    "total_paid" = COALESCE((
+        SELECT
+            SUM("po"."line_total")
+        FROM "products_orders" "po"
+        WHERE "po"."order_id" = "o"."id"
+    ), 0);
+
+COMMIT;
@@ -0,0 +1,35 @@
+BEGIN;
+
+--Orders are marked as paid once the transfer slip has been checked
+ALTER TYPE "order_status" ADD VALUE IF NOT EXISTS 'paid' AFTER 'waiting';
+
+CREATE TABLE "order_status_history" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "order_id" VARCHAR NOT NULL,
+  "from_status" order_status,
+  "to_status" order_status NOT NULL,
+  "changed_by" VARCHAR NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "order_status_history" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE CASCADE;
+
+CREATE INDEX "order_status_history_order_id_idx" ON "order_status_history" ("order_id", "created_at");
+
+--Existing orders start their history at their current status
+INSERT INTO "order_status_history" (
+    "order_id",
+    "from_status",
+    "to_status",
+    "changed_by",
+    "created_at"
+)
+SELECT
+    "o"."id",
+    NULL,
+    "o"."status",
+    "o"."user_id",
+    "o"."created_at"
+FROM "orders" "o";
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "stock_movements" CASCADE;
+
+ALTER TABLE "products_orders" DROP COLUMN IF EXISTS "reserved";
+ALTER TABLE "products_orders" DROP COLUMN IF EXISTS "product_id";
+ALTER TABLE "products" DROP CONSTRAINT IF EXISTS "products_stock_check";
+ALTER TABLE "products" DROP COLUMN IF EXISTS "stock";
+
+COMMIT;
@@ -0,0 +1,30 @@
+BEGIN;
+
+ALTER TABLE "products" ADD COLUMN "stock" INT NOT NULL DEFAULT 0;
+ALTER TABLE "products" ADD CONSTRAINT "products_stock_check" CHECK ("stock" >= 0);
+
+--Order lines reference the product directly so stock can be released, the
+--lines placed before stock was reserved have nothing to release
+ALTER TABLE "products_orders" ADD COLUMN "product_id" VARCHAR;
+ALTER TABLE "products_orders" ADD COLUMN "reserved" BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE "products_orders" SET
+  "product_id" = "product"->>'id',
+  "reserved" = FALSE;
+
+CREATE TABLE "stock_movements" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "qty_change" INT NOT NULL,
+  "stock_after" INT NOT NULL,
+  "reason" VARCHAR NOT NULL,
+  "order_id" VARCHAR,
+  "created_by" VARCHAR,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "stock_movements" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+ALTER TABLE "stock_movements" ADD FOREIGN KEY ("order_id") REFERENCES "orders" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "stock_movements_product_id_idx" ON "stock_movements" ("product_id", "created_at");
+
+COMMIT;
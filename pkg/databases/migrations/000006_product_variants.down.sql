@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE "products_orders" DROP COLUMN IF EXISTS "variant_id";
+ALTER TABLE "stock_movements" DROP COLUMN IF EXISTS "variant_id";
+ALTER TABLE "images" DROP COLUMN IF EXISTS "variant_id";
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_product_variants_table ON "product_variants";
+DROP TABLE IF EXISTS "product_variants" CASCADE;
+
+ALTER TABLE "products" DROP COLUMN IF EXISTS "options";
+
+COMMIT;
@@ -0,0 +1,31 @@
+BEGIN;
+
+--Option names a product varies by, e.g. {size,colour}
+ALTER TABLE "products" ADD COLUMN "options" VARCHAR[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE "product_variants" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "product_id" VARCHAR NOT NULL,
+  "sku" VARCHAR UNIQUE NOT NULL,
+  "options" jsonb NOT NULL DEFAULT '{}'::jsonb,
+  "price" FLOAT,
+  "stock" INT NOT NULL DEFAULT 0 CHECK ("stock" >= 0),
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "product_variants" ADD FOREIGN KEY ("product_id") REFERENCES "products" ("id") ON DELETE CASCADE;
+CREATE INDEX "product_variants_product_id_idx" ON "product_variants" ("product_id");
+
+CREATE TRIGGER set_updated_at_timestamp_product_variants_table BEFORE UPDATE ON "product_variants" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+ALTER TABLE "images" ADD COLUMN "variant_id" uuid;
+ALTER TABLE "images" ADD FOREIGN KEY ("variant_id") REFERENCES "product_variants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "stock_movements" ADD COLUMN "variant_id" uuid;
+--The ledger outlives a removed variant, its movements lose the variant only
+ALTER TABLE "stock_movements" ADD FOREIGN KEY ("variant_id") REFERENCES "product_variants" ("id") ON DELETE SET NULL;
+
+ALTER TABLE "products_orders" ADD COLUMN "variant_id" uuid;
+
+COMMIT;
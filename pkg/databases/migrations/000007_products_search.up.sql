@@ -0,0 +1,11 @@
+BEGIN;
+
+--Search document kept in sync by postgres, titles weigh more than descriptions
+ALTER TABLE "products" ADD COLUMN "search_vector" tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', COALESCE("title", '')), 'A') ||
+    setweight(to_tsvector('simple', COALESCE("description", '')), 'B')
+) STORED;
+
+CREATE INDEX "products_search_vector_idx" ON "products" USING GIN ("search_vector");
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+--Resized copies of the image by name, e.g. {"thumbnail": "https://..."}
+ALTER TABLE "images" ADD COLUMN "variants" jsonb NOT NULL DEFAULT '{}'::jsonb;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS set_updated_at_timestamp_files_table ON "files";
+DROP TABLE IF EXISTS "files" CASCADE;
+
+COMMIT;
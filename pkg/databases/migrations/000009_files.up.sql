@@ -0,0 +1,48 @@
+BEGIN;
+
+--Registry of the objects in storage, a file without an entity is an orphan once its grace period passes
+CREATE TABLE "files" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "storage_key" VARCHAR UNIQUE NOT NULL,
+  "url" VARCHAR NOT NULL,
+  "owner_id" VARCHAR,
+  "size" BIGINT NOT NULL DEFAULT 0,
+  "content_type" VARCHAR NOT NULL DEFAULT '',
+  "checksum" VARCHAR NOT NULL DEFAULT '',
+  "entity_type" VARCHAR,
+  "entity_id" VARCHAR,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "files" ADD FOREIGN KEY ("owner_id") REFERENCES "users" ("id") ON DELETE SET NULL;
+CREATE INDEX "files_url_idx" ON "files" ("url");
+CREATE INDEX "files_entity_idx" ON "files" ("entity_type", "entity_id");
+CREATE INDEX "files_orphan_idx" ON "files" ("updated_at") WHERE "entity_type" IS NULL;
+
+CREATE TRIGGER set_updated_at_timestamp_files_table BEFORE UPDATE ON "files" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+--Product images uploaded before the registry
+INSERT INTO "files" ("storage_key", "url", "entity_type", "entity_id")
+SELECT
+  'images/products/' || "filename",
+  "url",
+  'product_image',
+  "product_id"
+FROM "images"
+ON CONFLICT ("storage_key") DO NOTHING;
+
+--Transfer slips uploaded before the registry, they belong to the customer of the order
+INSERT INTO "files" ("storage_key", "url", "owner_id", "entity_type", "entity_id")
+SELECT
+  'images/slips/' || ("transfer_slip"->>'filename'),
+  "transfer_slip"->>'url',
+  "user_id",
+  'transfer_slip',
+  "id"
+FROM "orders"
+WHERE COALESCE("transfer_slip"->>'filename', '') <> ''
+AND COALESCE("transfer_slip"->>'url', '') <> ''
+ON CONFLICT ("storage_key") DO NOTHING;
+
+COMMIT;
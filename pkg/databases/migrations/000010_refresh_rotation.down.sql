@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP TABLE IF EXISTS "security_events" CASCADE;
+
+DROP INDEX IF EXISTS "oauth_refresh_token_idx";
+DROP INDEX IF EXISTS "oauth_family_id_idx";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "revoked_at";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "used_at";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "family_id";
+
+COMMIT;
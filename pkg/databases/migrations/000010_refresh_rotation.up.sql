@@ -0,0 +1,22 @@
+BEGIN;
+
+--Every refresh issues a new oauth row in the family of the sign in, the previous row is marked used
+ALTER TABLE "oauth" ADD COLUMN "family_id" uuid NOT NULL DEFAULT uuid_generate_v4();
+ALTER TABLE "oauth" ADD COLUMN "used_at" TIMESTAMP;
+ALTER TABLE "oauth" ADD COLUMN "revoked_at" TIMESTAMP;
+
+CREATE INDEX "oauth_family_id_idx" ON "oauth" ("family_id");
+CREATE INDEX "oauth_refresh_token_idx" ON "oauth" ("refresh_token");
+
+CREATE TABLE "security_events" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" VARCHAR NOT NULL,
+  "event" VARCHAR NOT NULL,
+  "details" jsonb NOT NULL DEFAULT '{}'::jsonb,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "security_events" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+CREATE INDEX "security_events_user_id_idx" ON "security_events" ("user_id", "created_at");
+
+COMMIT;
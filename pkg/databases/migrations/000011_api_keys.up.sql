@@ -0,0 +1,23 @@
+BEGIN;
+
+--Only a hash of the key is kept, the key itself is shown once when it is created
+CREATE TABLE "api_keys" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "name" VARCHAR NOT NULL,
+  "prefix" VARCHAR NOT NULL,
+  "key_hash" VARCHAR UNIQUE NOT NULL,
+  "owner_id" VARCHAR NOT NULL,
+  "scopes" VARCHAR[] NOT NULL DEFAULT '{}',
+  "expires_at" TIMESTAMP,
+  "last_used_at" TIMESTAMP,
+  "revoked_at" TIMESTAMP,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now(),
+  "updated_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "api_keys" ADD FOREIGN KEY ("owner_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+CREATE INDEX "api_keys_owner_id_idx" ON "api_keys" ("owner_id");
+
+CREATE TRIGGER set_updated_at_timestamp_api_keys_table BEFORE UPDATE ON "api_keys" FOR EACH ROW EXECUTE PROCEDURE set_updated_at_column();
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "oauth_user_id_idx";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "last_used_at";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "ip";
+ALTER TABLE "oauth" DROP COLUMN IF EXISTS "user_agent";
+
+COMMIT;
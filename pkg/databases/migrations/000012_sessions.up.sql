@@ -0,0 +1,12 @@
+BEGIN;
+
+--The device an oauth was issued to, a session is the family of a sign in
+ALTER TABLE "oauth" ADD COLUMN "user_agent" VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE "oauth" ADD COLUMN "ip" VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE "oauth" ADD COLUMN "last_used_at" TIMESTAMP NOT NULL DEFAULT now();
+
+UPDATE "oauth" SET "last_used_at" = "updated_at";
+
+CREATE INDEX "oauth_user_id_idx" ON "oauth" ("user_id");
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+--Accounts created before verification existed are trusted as they are
+ALTER TABLE "users" ADD COLUMN "email_verified" BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE "users" SET "email_verified" = TRUE;
+
+--Only a hash of the token is kept, the token itself is only in the mail
+CREATE TABLE "email_verifications" (
+  "id" uuid NOT NULL UNIQUE PRIMARY KEY DEFAULT uuid_generate_v4(),
+  "user_id" VARCHAR NOT NULL,
+  "token_hash" VARCHAR UNIQUE NOT NULL,
+  "expires_at" TIMESTAMP NOT NULL,
+  "used_at" TIMESTAMP,
+  "created_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE "email_verifications" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+CREATE INDEX "email_verifications_user_id_idx" ON "email_verifications" ("user_id");
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX IF EXISTS "security_events_event_idx";
+DELETE FROM "security_events" WHERE "user_id" IS NULL;
+ALTER TABLE "security_events" ALTER COLUMN "user_id" SET NOT NULL;
+
+DROP TABLE IF EXISTS "login_throttles" CASCADE;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+--The failed sign ins of an account (user:<id>) or of an ip (ip:<address>)
+CREATE TABLE "login_throttles" (
+  "key" VARCHAR PRIMARY KEY,
+  "failures" INT NOT NULL DEFAULT 0,
+  "locked_until" TIMESTAMP,
+  "last_failed_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+--Failed sign ins of unknown emails belong to no user
+ALTER TABLE "security_events" ALTER COLUMN "user_id" DROP NOT NULL;
+CREATE INDEX "security_events_event_idx" ON "security_events" ("event", "created_at");
+
+COMMIT;
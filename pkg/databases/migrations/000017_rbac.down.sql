@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE "roles" DROP COLUMN IF EXISTS "permissions";
+
+COMMIT;
@@ -0,0 +1,25 @@
+BEGIN;
+
+--The named permissions a role grants, see modules/roles for the known ones
+ALTER TABLE "roles" ADD COLUMN "permissions" VARCHAR[] NOT NULL DEFAULT '{}';
+
+UPDATE "roles" SET
+  "permissions" = ARRAY[
+    'products:write',
+    'stock:read',
+    'stock:write',
+    'categories:write',
+    'orders:read_all',
+    'orders:write_all',
+    'files:upload',
+    'files:read_all',
+    'files:delete',
+    'users:read_all',
+    'users:write_all',
+    'users:create_admin',
+    'apikeys:manage',
+    'roles:manage'
+  ]::varchar[]
+WHERE "title" = 'admin';
+
+COMMIT;
@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Formats maps the derivative formats to their extension and content type
+var Formats = map[string][2]string{
+	"jpeg": {"jpg", "image/jpeg"},
+	"webp": {"webp", "image/webp"},
+}
+
+// Resize scales an image down so its longest edge fits maxSize, smaller images are kept as they are
+func Resize(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+
+	if width >= height {
+		height = height * maxSize / width
+		width = maxSize
+	} else {
+		width = width * maxSize / height
+		height = maxSize
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode writes the image in the given format, webp needs a build with the webp tag
+func Encode(w io.Writer, img image.Image, format string) error {
+	if format == "webp" {
+		return encodeWebp(w, img)
+	}
+
+	// Jpeg has no alpha, transparent pixels are laid on white
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: 85})
+}
@@ -0,0 +1,203 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+)
+
+// Acceptable maps the sniffed content types to the extension they are stored with
+var Acceptable = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+}
+
+type Info struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Inspect detects the content type from the magic bytes and decodes the image
+// header, the returned error is meant to be shown to the uploader.
+func Inspect(r io.Reader) (*Info, error) {
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+
+	contentType := http.DetectContentType(head)
+	ext, ok := Acceptable[contentType]
+	if !ok {
+		return nil, fmt.Errorf("content type %s is not acceptable", contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(br)
+	if err != nil {
+		return nil, fmt.Errorf("file is not a valid image")
+	}
+	return &Info{
+		ContentType: contentType,
+		Ext:         ext,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}, nil
+}
+
+// StripMetadata copies an image without its metadata segments (EXIF, XMP,
+// IPTC, comments and text chunks), the pixel data is copied untouched.
+func StripMetadata(dst io.Writer, src io.Reader, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return stripJpeg(dst, bufio.NewReader(src))
+	case "image/png":
+		return stripPng(dst, bufio.NewReader(src))
+	default:
+		_, err := io.Copy(dst, src)
+		return err
+	}
+}
+
+// jpegStripped are APP1 (EXIF, XMP), APP13 (IPTC) and COM, APP0 (JFIF), APP2 (ICC)
+// and APP14 (Adobe) are kept as they affect how the pixels are decoded. The
+// EXIF orientation is kept too, in an APP1 of its own.
+var jpegStripped = map[byte]bool{
+	0xE1: true,
+	0xED: true,
+	0xFE: true,
+}
+
+func stripJpeg(dst io.Writer, src *bufio.Reader) error {
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(src, soi); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return fmt.Errorf("jpeg start of image is missing")
+	}
+	if _, err := dst.Write(soi); err != nil {
+		return err
+	}
+
+	for {
+		b, err := src.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read jpeg marker failed: %v", err)
+		}
+		if b != 0xFF {
+			return fmt.Errorf("jpeg marker is invalid")
+		}
+		marker, err := src.ReadByte()
+		for err == nil && marker == 0xFF {
+			marker, err = src.ReadByte()
+		}
+		if err != nil {
+			return fmt.Errorf("read jpeg marker failed: %v", err)
+		}
+
+		// Markers without a payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			if _, err := dst.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+		if marker == 0xD9 {
+			_, err := dst.Write([]byte{0xFF, marker})
+			return err
+		}
+
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(src, size); err != nil {
+			return fmt.Errorf("read jpeg segment failed: %v", err)
+		}
+		length := int64(binary.BigEndian.Uint16(size)) - 2
+		if length < 0 {
+			return fmt.Errorf("jpeg segment is invalid")
+		}
+
+		if marker == 0xE1 {
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(src, payload); err != nil {
+				return fmt.Errorf("read jpeg segment failed: %v", err)
+			}
+			if orientation := exifOrientation(payload); orientation != 1 {
+				exif := exifWithOrientation(orientation)
+				header := []byte{0xFF, marker, 0, 0}
+				binary.BigEndian.PutUint16(header[2:], uint16(len(exif)+2))
+				if _, err := dst.Write(append(header, exif...)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if jpegStripped[marker] {
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return fmt.Errorf("read jpeg segment failed: %v", err)
+			}
+			continue
+		}
+
+		if _, err := dst.Write([]byte{0xFF, marker, size[0], size[1]}); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, length); err != nil {
+			return fmt.Errorf("copy jpeg segment failed: %v", err)
+		}
+
+		// Start of scan, the entropy coded data runs to the end of the image
+		if marker == 0xDA {
+			_, err := io.Copy(dst, src)
+			return err
+		}
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngStripped = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPng(dst io.Writer, src *bufio.Reader) error {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(src, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("png signature is missing")
+	}
+	if _, err := dst.Write(signature); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			return fmt.Errorf("read png chunk failed: %v", err)
+		}
+		chunkType := string(header[4:])
+		// data and crc
+		length := int64(binary.BigEndian.Uint32(header[:4])) + 4
+
+		if pngStripped[chunkType] {
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return fmt.Errorf("read png chunk failed: %v", err)
+			}
+			continue
+		}
+
+		if _, err := dst.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, length); err != nil {
+			return fmt.Errorf("copy png chunk failed: %v", err)
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// withJpegSegment puts a segment right after the start of image
+func withJpegSegment(data []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{0xFF, 0xD8}, append(segment, payload...)...)
+	return append(out, data[2:]...)
+}
+
+// pngFixture is a 2x2 png with the chunks put right after IHDR
+func pngFixture(t *testing.T, chunks map[string][]byte) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("encode fixture failed: %v", err)
+	}
+
+	// signature and the 13 bytes IHDR with its length, type and crc
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	data := append([]byte(nil), buf.Bytes()[:ihdrEnd]...)
+	for chunkType, payload := range chunks {
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		chunk = append(chunk, chunkType...)
+		chunk = append(chunk, payload...)
+		data = append(data, binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))...)
+	}
+	return append(data, buf.Bytes()[ihdrEnd:]...)
+}
+
+func TestStripMetadataJpeg(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  byte
+		payload string
+		kept    bool
+	}{
+		{"exif without orientation", 0xE1, "Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\x00\x00Canon", false},
+		{"xmp", 0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>", false},
+		{"iptc", 0xED, "Photoshop 3.0\x008BIM\x04\x04Jane Doe", false},
+		{"comment", 0xFE, "taken at 13.7563 N 100.5018 E", false},
+		{"jfif", 0xE0, "JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00", true},
+		{"icc profile", 0xE2, "ICC_PROFILE\x00\x01\x01sRGB", true},
+		{"adobe", 0xEE, "Adobe\x00\x64\x00\x00\x00\x00\x01", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := withJpegSegment(jpegFixture(t, nil), tt.marker, []byte(tt.payload))
+			stripped := new(bytes.Buffer)
+			if err := StripMetadata(stripped, bytes.NewReader(data), "image/jpeg"); err != nil {
+				t.Fatalf("StripMetadata failed: %v", err)
+			}
+			if got := bytes.Contains(stripped.Bytes(), []byte(tt.payload)); got != tt.kept {
+				t.Errorf("segment kept = %v, want %v", got, tt.kept)
+			}
+			if !tt.kept && stripped.Len() != len(data)-len(tt.payload)-4 {
+				t.Errorf("stripped %d bytes, want only the %d of the segment", len(data)-stripped.Len(), len(tt.payload)+4)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(stripped.Bytes())); err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataPng(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkType string
+		payload   string
+		kept      bool
+	}{
+		{"exif", "eXIf", "MM\x00\x2A\x00\x00\x00\x08Canon", false},
+		{"text", "tEXt", "Author\x00Jane Doe", false},
+		{"compressed text", "zTXt", "Comment\x00\x00x\x9c compressed", false},
+		{"international text", "iTXt", "Location\x00\x00\x00\x00\x00Bangkok", false},
+		{"time", "tIME", "\x07\xE8\x01\x02\x03\x04\x05", false},
+		{"gamma", "gAMA", "\x00\x00\xB1\x8F", true},
+		{"srgb", "sRGB", "\x00", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := pngFixture(t, map[string][]byte{tt.chunkType: []byte(tt.payload)})
+			stripped := new(bytes.Buffer)
+			if err := StripMetadata(stripped, bytes.NewReader(data), "image/png"); err != nil {
+				t.Fatalf("StripMetadata failed: %v", err)
+			}
+			if got := bytes.Contains(stripped.Bytes(), []byte(tt.chunkType)); got != tt.kept {
+				t.Errorf("chunk kept = %v, want %v", got, tt.kept)
+			}
+			if _, err := png.Decode(bytes.NewReader(stripped.Bytes())); err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	jpegData := jpegFixture(t, nil)
+	pngData := pngFixture(t, nil)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"jpeg without start of image", pngData, "image/jpeg"},
+		{"jpeg cut in a segment header", jpegData[:5], "image/jpeg"},
+		{"jpeg cut in a stripped segment", withJpegSegment(jpegData, 0xFE, []byte("comment"))[:8], "image/jpeg"},
+		{"jpeg segment shorter than its length", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x01}, "image/jpeg"},
+		{"png without signature", jpegData, "image/png"},
+		{"png without end", pngData[:len(pngData)-12], "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := StripMetadata(new(bytes.Buffer), bytes.NewReader(tt.data), tt.contentType); err == nil {
+				t.Errorf("StripMetadata accepted a malformed image")
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+const (
+	exifHeader     = "Exif\x00\x00"
+	tagOrientation = 0x0112
+)
+
+// exifOrientation reads the orientation tag of an APP1 EXIF payload, 1 (as
+// stored) when the payload has none or is malformed.
+func exifOrientation(payload []byte) int {
+	if !bytes.HasPrefix(payload, []byte(exifHeader)) {
+		return 1
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != tagOrientation {
+			continue
+		}
+		orientation := int(order.Uint16(tiff[entry+8:]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+	return 1
+}
+
+// exifWithOrientation is an APP1 EXIF payload holding only the orientation tag
+func exifWithOrientation(orientation int) []byte {
+	payload := []byte(exifHeader)
+	// Big endian tiff header, the first ifd right after it
+	payload = append(payload, 'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08)
+	// One entry, a short of count 1 padded to 4 bytes, and no next ifd
+	payload = binary.BigEndian.AppendUint16(payload, 1)
+	payload = binary.BigEndian.AppendUint16(payload, tagOrientation)
+	payload = binary.BigEndian.AppendUint16(payload, 3)
+	payload = binary.BigEndian.AppendUint32(payload, 1)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(orientation))
+	payload = append(payload, 0x00, 0x00)
+	return binary.BigEndian.AppendUint32(payload, 0)
+}
+
+// Decode decodes an image along with its EXIF orientation, the pixels are as
+// stored and still need Orient to be shown upright.
+func Decode(r io.Reader) (image.Image, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, jpegOrientation(data), nil
+}
+
+// jpegOrientation finds the EXIF orientation among the segments before the image data
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if payload := data[i+4 : i+2+length]; bytes.HasPrefix(payload, []byte(exifHeader)) {
+				return exifOrientation(payload)
+			}
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// Orient turns an image upright according to its EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// 5 to 8 swap the width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90 counterclockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,150 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifFixture is an EXIF payload like a phone writes, little endian with the
+// camera make before the orientation.
+func exifFixture(orientation int) []byte {
+	payload := []byte(exifHeader)
+	payload = append(payload, 'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00)
+	payload = binary.LittleEndian.AppendUint16(payload, 2)
+	// Make, ascii of 6 bytes stored after the ifd
+	payload = binary.LittleEndian.AppendUint16(payload, 0x010F)
+	payload = binary.LittleEndian.AppendUint16(payload, 2)
+	payload = binary.LittleEndian.AppendUint32(payload, 6)
+	payload = binary.LittleEndian.AppendUint32(payload, 8+2+2*12+4)
+	// Orientation
+	payload = binary.LittleEndian.AppendUint16(payload, tagOrientation)
+	payload = binary.LittleEndian.AppendUint16(payload, 3)
+	payload = binary.LittleEndian.AppendUint32(payload, 1)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(orientation))
+	payload = append(payload, 0x00, 0x00)
+	payload = binary.LittleEndian.AppendUint32(payload, 0)
+	return append(payload, "Canon\x00"...)
+}
+
+// jpegFixture is a 4x2 jpeg, left half red and right half blue, with the
+// EXIF payload right after the start of image.
+func jpegFixture(t *testing.T, exif []byte) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("encode fixture failed: %v", err)
+	}
+	if exif == nil {
+		return buf.Bytes()
+	}
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	data := append([]byte{0xFF, 0xD8}, append(segment, exif...)...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+func TestStripMetadataKeepsOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		exif        []byte
+		orientation int
+	}{
+		{"rotated 90 clockwise", exifFixture(6), 6},
+		{"rotated 180", exifFixture(3), 3},
+		{"upright", exifFixture(1), 1},
+		{"no exif", nil, 1},
+		{"not exif", []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stripped := new(bytes.Buffer)
+			if err := StripMetadata(stripped, bytes.NewReader(jpegFixture(t, tt.exif)), "image/jpeg"); err != nil {
+				t.Fatalf("StripMetadata failed: %v", err)
+			}
+			if bytes.Contains(stripped.Bytes(), []byte("Canon")) || bytes.Contains(stripped.Bytes(), []byte("xmpmeta")) {
+				t.Errorf("stripped image still has the metadata")
+			}
+			if got := jpegOrientation(stripped.Bytes()); got != tt.orientation {
+				t.Errorf("orientation = %d, want %d", got, tt.orientation)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(stripped.Bytes())); err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeOrient(t *testing.T) {
+	img, orientation, err := Decode(bytes.NewReader(jpegFixture(t, exifFixture(6))))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if orientation != 6 {
+		t.Fatalf("orientation = %d, want 6", orientation)
+	}
+
+	upright := Orient(img, orientation)
+	if b := upright.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("upright image is %dx%d, want 2x4", b.Dx(), b.Dy())
+	}
+	// Turned clockwise the red left half ends up on top
+	if r, _, b, _ := upright.At(0, 0).RGBA(); r < b {
+		t.Errorf("top of the upright image is not red")
+	}
+	if r, _, b, _ := upright.At(0, 3).RGBA(); b < r {
+		t.Errorf("bottom of the upright image is not blue")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2, the pixels numbered in reading order
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i + 1)
+	}
+
+	tests := []struct {
+		orientation int
+		width       int
+		pixels      []uint8
+	}{
+		{1, 3, []uint8{1, 2, 3, 4, 5, 6}},
+		{2, 3, []uint8{3, 2, 1, 6, 5, 4}},
+		{3, 3, []uint8{6, 5, 4, 3, 2, 1}},
+		{4, 3, []uint8{4, 5, 6, 1, 2, 3}},
+		{5, 2, []uint8{1, 4, 2, 5, 3, 6}},
+		{6, 2, []uint8{4, 1, 5, 2, 6, 3}},
+		{7, 2, []uint8{6, 3, 5, 2, 4, 1}},
+		{8, 2, []uint8{3, 6, 2, 5, 1, 4}},
+	}
+
+	for _, tt := range tests {
+		img := Orient(src, tt.orientation)
+		if img.Bounds().Dx() != tt.width {
+			t.Errorf("Orient(%d) width = %d, want %d", tt.orientation, img.Bounds().Dx(), tt.width)
+			continue
+		}
+		for i, want := range tt.pixels {
+			gray := color.GrayModel.Convert(img.At(i%tt.width, i/tt.width)).(color.Gray)
+			if gray.Y != want {
+				t.Errorf("Orient(%d) pixel %d = %d, want %d", tt.orientation, i, gray.Y, want)
+			}
+		}
+	}
+}
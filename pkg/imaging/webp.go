@@ -0,0 +1,20 @@
+//go:build webp && cgo
+
+package imaging
+
+import (
+	"image"
+	"io"
+
+	"github.com/bep/gowebp/libwebp"
+	"github.com/bep/gowebp/libwebp/webpoptions"
+)
+
+const WebpSupported = true
+
+func encodeWebp(w io.Writer, img image.Image) error {
+	return libwebp.Encode(w, img, webpoptions.EncodingOptions{
+		Quality:        80,
+		EncodingPreset: webpoptions.EncodingPresetPhoto,
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !webp || !cgo
+
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// WebpSupported is false unless built with cgo and the webp tag
+const WebpSupported = false
+
+func encodeWebp(w io.Writer, img image.Image) error {
+	return fmt.Errorf("webp encoding needs a build with cgo and the webp tag")
+}
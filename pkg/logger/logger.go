@@ -10,6 +10,37 @@ import (
 	"time"
 )
 
+// redacted replaces what must never reach the logs
+const redacted = "never gonna give you up"
+
+// secretBodies are the routes whose request bodies hold passwords, tokens or
+// second factor codes
+var secretBodies = map[string]bool{
+	"/v1/users/signup":         true,
+	"/v1/users/signup-admin":   true,
+	"/v1/users/signin":         true,
+	"/v1/users/signin/2fa":     true,
+	"/v1/users/refresh":        true,
+	"/v1/users/reset-password": true,
+	"/v1/users/verify-email":   true,
+	"/v1/users/2fa/setup":      true,
+	"/v1/users/2fa/enable":     true,
+	"/v1/users/2fa/disable":    true,
+}
+
+// secretResponses are the routes answering with secrets shown only once
+var secretResponses = map[string]bool{
+	"POST /v1/users/2fa/setup":  true, // totp secret and uri
+	"POST /v1/users/2fa/enable": true, // recovery codes
+	"POST /v1/appinfo/apikeys":  true, // the plaintext api key
+}
+
+// route is a path the way the router matches it, regardless of case and of
+// a trailing slash
+func route(path string) string {
+	return strings.ToLower(strings.TrimSuffix(path, "/"))
+}
+
 type ILogger interface {
 	Print() ILogger
 	Save()
@@ -76,14 +107,17 @@ func (l *logger) SetBody(c *fiber.Ctx) {
 		log.Printf("body parser error: %v", err)
 	}
 
-	switch l.Path {
-	case "v1/users/signup":
-		l.Body = "never gonna give you up"
-	default:
-		l.Body = body
+	if secretBodies[route(l.Path)] {
+		l.Body = redacted
+		return
 	}
+	l.Body = body
 }
 
 func (l *logger) SetResponse(res any) {
+	if secretResponses[l.Method+" "+route(l.Path)] {
+		l.Response = redacted
+		return
+	}
 	l.Response = res
 }
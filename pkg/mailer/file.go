@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fileMailer writes each mail to an .eml file, for development
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func FileMailer(dir, from string) IMailer {
+	return &fileMailer{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := encode(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0777); err != nil {
+		return fmt.Errorf("mkdir %q failed: %v", m.dir, err)
+	}
+	name := filepath.Join(m.dir, fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405"), uuid.NewString()))
+	if err := os.WriteFile(name, data, 0666); err != nil {
+		return fmt.Errorf("write mail failed: %v", err)
+	}
+	log.Printf("mail %q to %v written to %s\n", msg.Subject, msg.To, name)
+	return nil
+}
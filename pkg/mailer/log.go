@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// logMailer prints each mail to the log instead of sending it, the default
+// until a driver is configured
+type logMailer struct {
+	from string
+}
+
+func LogMailer(from string) IMailer {
+	return &logMailer{from: from}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg *Message) error {
+	// Rejects what the other drivers would
+	if _, err := encode(m.from, msg); err != nil {
+		return err
+	}
+	log.Printf("mail %q to %v not sent, MAIL_DRIVER is log:\n%s\n", msg.Subject, msg.To, msg.Text)
+	return nil
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	Html    string // optional, sent as an alternative to the text
+}
+
+type IMailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+func NewMailer(cfg config.IMailConfig) (IMailer, error) {
+	switch cfg.Driver() {
+	case "smtp":
+		return SmtpMailer(cfg)
+	case "file":
+		return FileMailer(cfg.FileDir(), cfg.From()), nil
+	case "memory":
+		return MemoryMailer(cfg.From()), nil
+	case "log":
+		return LogMailer(cfg.From()), nil
+	default:
+		return nil, fmt.Errorf("mail driver %q is not supported", cfg.Driver())
+	}
+}
+
+// encode renders a message as it goes on the wire, the drivers differ only
+// in where they put it.
+func encode(from string, msg *Message) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("message has no recipient")
+	}
+	for _, v := range append([]string{from, msg.Subject}, msg.To...) {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("header %q is invalid", v)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+
+	if msg.Html == "" {
+		fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuoted(buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.Html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuoted(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuoted(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return fmt.Errorf("encode mail failed: %v", err)
+	}
+	return qp.Close()
+}
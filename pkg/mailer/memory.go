@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+type IMemoryMailer interface {
+	IMailer
+	// Messages are the mails sent so far, oldest first
+	Messages() []*Message
+}
+
+// memoryMailer keeps the mails in the process, for tests
+type memoryMailer struct {
+	mu       sync.Mutex
+	from     string
+	messages []*Message
+}
+
+func MemoryMailer(from string) IMemoryMailer {
+	return &memoryMailer{
+		from:     from,
+		messages: make([]*Message, 0),
+	}
+}
+
+func (m *memoryMailer) Send(ctx context.Context, msg *Message) error {
+	// Rejects what the other drivers would
+	if _, err := encode(m.from, msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *memoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
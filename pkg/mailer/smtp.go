@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// SmtpMailer relays through a server, STARTTLS is used whenever it is offered
+func SmtpMailer(cfg config.IMailConfig) (IMailer, error) {
+	if cfg.SmtpHost() == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+
+	m := &smtpMailer{
+		addr: net.JoinHostPort(cfg.SmtpHost(), strconv.Itoa(cfg.SmtpPort())),
+		from: cfg.From(),
+	}
+	if cfg.SmtpUsername() != "" {
+		m.auth = smtp.PlainAuth("", cfg.SmtpUsername(), cfg.SmtpPassword(), cfg.SmtpHost())
+	}
+	return m, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := encode(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp takes no context, the send is abandoned rather than cancelled
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, msg.To, data)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("send mail failed: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("send mail failed: %v", ctx.Err())
+	}
+}
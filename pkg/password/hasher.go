@@ -0,0 +1,168 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jetsadawwts/go-restapi/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrHashUnknown = errors.New("password hash format is unknown")
+
+type IHasher interface {
+	// Hash makes a hash with the configured algorithm and parameters
+	Hash(password string) (string, error)
+	// Verify checks a password against a hash of any supported algorithm
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash tells a hash was not made the way new ones are
+	NeedsRehash(hash string) bool
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLen     int
+	keyLen      uint32
+}
+
+type hasher struct {
+	algorithm  string
+	argon2     *argon2Params
+	bcryptCost int
+	// slots bounds the argon2 keys derived at once, each holds its memory
+	// until done and a burst of sign ins would otherwise exhaust the host.
+	slots chan struct{}
+}
+
+func NewHasher(cfg config.IPasswordConfig) (IHasher, error) {
+	if cfg.Argon2Parallelism() > 255 {
+		return nil, fmt.Errorf("argon2 parallelism %d is above 255", cfg.Argon2Parallelism())
+	}
+	return &hasher{
+		algorithm: cfg.Hasher(),
+		argon2: &argon2Params{
+			memory:      uint32(cfg.Argon2Memory()),
+			iterations:  uint32(cfg.Argon2Iterations()),
+			parallelism: uint8(cfg.Argon2Parallelism()),
+			saltLen:     16,
+			keyLen:      32,
+		},
+		bcryptCost: cfg.BcryptCost(),
+		slots:      make(chan struct{}, cfg.Argon2Concurrency()),
+	}, nil
+}
+
+func (h *hasher) idKey(password string, salt []byte, p *argon2Params, keyLen uint32) []byte {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	return argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, keyLen)
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	if h.algorithm == "bcrypt" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("hashed password failed: %v", err)
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, h.argon2.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("hashed password failed: %v", err)
+	}
+	key := h.idKey(password, salt, h.argon2, h.argon2.keyLen)
+	return encodeArgon2(h.argon2, salt, key), nil
+}
+
+func (h *hasher) Verify(hash, password string) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("verify password failed: %v", err)
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+	other := h.idKey(password, salt, params, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *hasher) NeedsRehash(hash string) bool {
+	if isBcrypt(hash) {
+		if h.algorithm != "bcrypt" {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.bcryptCost
+	}
+
+	if h.algorithm != "argon2id" {
+		return true
+	}
+	params, _, _, err := decodeArgon2(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.argon2.memory ||
+		params.iterations != h.argon2.iterations ||
+		params.parallelism != h.argon2.parallelism
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// encodeArgon2 writes the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func encodeArgon2(p *argon2Params, salt, key []byte) string {
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.memory,
+		p.iterations,
+		p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodeArgon2(hash string) (*argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrHashUnknown
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrHashUnknown
+	}
+	p := new(argon2Params)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, nil, nil, ErrHashUnknown
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrHashUnknown
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrHashUnknown
+	}
+	return p, salt, key, nil
+}
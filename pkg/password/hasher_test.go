@@ -0,0 +1,200 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordConfig stands in for the loaded config, argon2 is kept cheap so the
+// tests stay fast.
+type passwordConfig struct {
+	hasher       string
+	argon2Memory int
+	bcryptCost   int
+	minLength    int
+	maxLength    int
+	breachedList string
+}
+
+func testConfig() *passwordConfig {
+	return &passwordConfig{
+		hasher:       "argon2id",
+		argon2Memory: 64,
+		bcryptCost:   bcrypt.MinCost,
+		minLength:    8,
+		maxLength:    64,
+	}
+}
+
+func (c *passwordConfig) Hasher() string         { return c.hasher }
+func (c *passwordConfig) Argon2Memory() int      { return c.argon2Memory }
+func (c *passwordConfig) Argon2Iterations() int  { return 1 }
+func (c *passwordConfig) Argon2Parallelism() int { return 1 }
+func (c *passwordConfig) Argon2Concurrency() int { return 2 }
+func (c *passwordConfig) BcryptCost() int        { return c.bcryptCost }
+func (c *passwordConfig) MinLength() int         { return c.minLength }
+func (c *passwordConfig) MaxLength() int         { return c.maxLength }
+func (c *passwordConfig) BreachedList() string   { return c.breachedList }
+
+func newTestHasher(t *testing.T, cfg *passwordConfig) IHasher {
+	t.Helper()
+	h, err := NewHasher(cfg)
+	if err != nil {
+		t.Fatalf("NewHasher failed: %v", err)
+	}
+	return h
+}
+
+func TestHasherVerify(t *testing.T) {
+	argon2Cfg := testConfig()
+	bcryptCfg := testConfig()
+	bcryptCfg.hasher = "bcrypt"
+
+	tests := []struct {
+		name     string
+		cfg      *passwordConfig
+		password string
+		attempt  string
+		ok       bool
+	}{
+		{"argon2id match", argon2Cfg, "correct horse", "correct horse", true},
+		{"argon2id mismatch", argon2Cfg, "correct horse", "correct horsE", false},
+		{"argon2id empty attempt", argon2Cfg, "correct horse", "", false},
+		{"bcrypt match", bcryptCfg, "correct horse", "correct horse", true},
+		{"bcrypt mismatch", bcryptCfg, "correct horse", "battery staple", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHasher(t, tt.cfg)
+			hash, err := h.Hash(tt.password)
+			if err != nil {
+				t.Fatalf("Hash failed: %v", err)
+			}
+			ok, err := h.Verify(hash, tt.attempt)
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if ok != tt.ok {
+				t.Errorf("Verify = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+// Hashes made by either algorithm verify whatever new hashes are made with
+func TestHasherVerifyAcrossAlgorithms(t *testing.T) {
+	bcryptCfg := testConfig()
+	bcryptCfg.hasher = "bcrypt"
+
+	old, err := newTestHasher(t, bcryptCfg).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	ok, err := newTestHasher(t, testConfig()).Verify(old, "correct horse")
+	if err != nil || !ok {
+		t.Errorf("argon2id hasher Verify of a bcrypt hash = (%v, %v), want (true, nil)", ok, err)
+	}
+}
+
+func TestHasherVerifyMalformed(t *testing.T) {
+	h := newTestHasher(t, testConfig())
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"plain text", "correct horse"},
+		{"other algorithm", "$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"other version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$"},
+		{"bad params", "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := h.Verify(tt.hash, "correct horse")
+			if ok || !errors.Is(err, ErrHashUnknown) {
+				t.Errorf("Verify = (%v, %v), want (false, ErrHashUnknown)", ok, err)
+			}
+		})
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	argon2Cfg := testConfig()
+	bcryptCfg := testConfig()
+	bcryptCfg.hasher = "bcrypt"
+	strongerCfg := testConfig()
+	strongerCfg.argon2Memory = 128
+	costlierCfg := testConfig()
+	costlierCfg.hasher = "bcrypt"
+	costlierCfg.bcryptCost = bcrypt.MinCost + 1
+
+	argon2Hash, err := newTestHasher(t, argon2Cfg).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	bcryptHash, err := newTestHasher(t, bcryptCfg).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		cfg   *passwordConfig
+		hash  string
+		needs bool
+	}{
+		{"argon2id with the same params", argon2Cfg, argon2Hash, false},
+		{"argon2id with more memory", strongerCfg, argon2Hash, true},
+		{"argon2id under bcrypt", bcryptCfg, argon2Hash, true},
+		{"bcrypt with the same cost", bcryptCfg, bcryptHash, false},
+		{"bcrypt with a higher cost", costlierCfg, bcryptHash, true},
+		{"bcrypt under argon2id", argon2Cfg, bcryptHash, true},
+		{"malformed", argon2Cfg, "$argon2id$broken", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestHasher(t, tt.cfg).NeedsRehash(tt.hash); got != tt.needs {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.needs)
+			}
+		})
+	}
+}
+
+func TestHasherFormat(t *testing.T) {
+	hash, err := newTestHasher(t, testConfig()).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %s, want the PHC format with the configured params", hash)
+	}
+
+	other, err := newTestHasher(t, testConfig()).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if hash == other {
+		t.Errorf("two hashes of a password are equal, the salt is not random")
+	}
+}
+
+func TestNewHasherParallelism(t *testing.T) {
+	cfg := &parallelConfig{passwordConfig: testConfig()}
+	if _, err := NewHasher(cfg); err == nil {
+		t.Errorf("NewHasher accepted a parallelism above 255")
+	}
+}
+
+type parallelConfig struct {
+	*passwordConfig
+}
+
+func (c *parallelConfig) Argon2Parallelism() int { return 256 }
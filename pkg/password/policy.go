@@ -0,0 +1,75 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+// ErrPolicy is wrapped by every policy refusal, the message tells why
+var ErrPolicy = errors.New("password does not meet the policy")
+
+type IPolicy interface {
+	Check(password string) error
+}
+
+type policy struct {
+	minLength int
+	maxLength int
+	breached  map[string]struct{}
+}
+
+// NewPolicy loads the breached password list once, it is kept in memory.
+// Passwords are compared ignoring case.
+func NewPolicy(cfg config.IPasswordConfig) (IPolicy, error) {
+	if cfg.MinLength() > cfg.MaxLength() {
+		return nil, fmt.Errorf("password min length %d is above the max length %d", cfg.MinLength(), cfg.MaxLength())
+	}
+
+	p := &policy{
+		minLength: cfg.MinLength(),
+		maxLength: cfg.MaxLength(),
+		breached:  make(map[string]struct{}),
+	}
+	if cfg.BreachedList() == "" {
+		return p, nil
+	}
+
+	file, err := os.Open(cfg.BreachedList())
+	if err != nil {
+		return nil, fmt.Errorf("open breached passwords failed: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached passwords failed: %v", err)
+	}
+	return p, nil
+}
+
+func (p *policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		return fmt.Errorf("%w: it needs at least %d characters", ErrPolicy, p.minLength)
+	}
+	if length > p.maxLength {
+		return fmt.Errorf("%w: it may have at most %d characters", ErrPolicy, p.maxLength)
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return fmt.Errorf("%w: it appears in a list of breached passwords", ErrPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(list, []byte("# known breached\n\nPassword1\n  letmein123  \n"), 0666); err != nil {
+		t.Fatalf("write breached list failed: %v", err)
+	}
+	cfg := testConfig()
+	cfg.breachedList = list
+
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"long enough", "correct horse", true},
+		{"exactly the min length", "abcdefgh", true},
+		{"too short", "abcdefg", false},
+		{"exactly the max length", strings.Repeat("a", 64), true},
+		{"too long", strings.Repeat("a", 65), false},
+		// Counted in characters, not bytes
+		{"multibyte at the min length", "รหัสผ่านดี", true},
+		{"multibyte too short", "ñññññññ", false},
+		{"breached", "Password1", false},
+		{"breached in another case", "PASSWORD1", false},
+		{"breached line with spaces", "letmein123", false},
+		{"comment is not a password", "# known breached", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password)
+			if tt.ok && err != nil {
+				t.Errorf("Check(%q) = %v, want nil", tt.password, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrPolicy) {
+				t.Errorf("Check(%q) = %v, want ErrPolicy", tt.password, err)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	inverted := testConfig()
+	inverted.minLength = 65
+	missing := testConfig()
+	missing.breachedList = filepath.Join(t.TempDir(), "missing.txt")
+
+	tests := []struct {
+		name string
+		cfg  *passwordConfig
+		ok   bool
+	}{
+		{"defaults", testConfig(), true},
+		{"min above max", inverted, false},
+		{"missing breached list", missing, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy(tt.cfg); (err == nil) != tt.ok {
+				t.Errorf("NewPolicy error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
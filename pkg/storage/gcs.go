@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+type gcsStorage struct {
+	client *storage.Client
+	bucket string
+}
+
+func GcsStorage(bucket string) (IStorage, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	return &gcsStorage{
+		client: client,
+		bucket: bucket,
+	}, nil
+}
+
+func (s *gcsStorage) Upload(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	o := s.client.Bucket(s.bucket).Object(key)
+
+	wc := o.NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := io.Copy(wc, r); err != nil {
+		wc.Close()
+		return "", fmt.Errorf("io.Copy: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return "", fmt.Errorf("Writer.Close: %v", err)
+	}
+
+	// Private objects keep the bucket default, readable only with credentials
+	if !IsPrivate(key) {
+		if err := o.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+			return "", fmt.Errorf("ACLHandle.Set: %v", err)
+		}
+	}
+	return s.Url(key), nil
+}
+
+func (s *gcsStorage) Delete(ctx context.Context, key string) error {
+	o := s.client.Bucket(s.bucket).Object(key)
+
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("Object(%q).Delete: %w", key, ErrNotFound)
+		}
+		return fmt.Errorf("object.Attrs:  %v", err)
+	}
+
+	o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	if err := o.Delete(ctx); err != nil {
+		return fmt.Errorf("Object(%q).Delete: %v", key, err)
+	}
+	return nil
+}
+
+func (s *gcsStorage) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	rc, err := s.client.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("Object(%q).NewReader: %v", key, err)
+	}
+	return rc, &ObjectInfo{
+		Size:        rc.Attrs.Size,
+		ContentType: rc.Attrs.ContentType,
+		ModTime:     rc.Attrs.LastModified,
+		// Every write of an object is a new generation
+		ETag: fmt.Sprintf("\"%d\"", rc.Attrs.Generation),
+	}, nil
+}
+
+func (s *gcsStorage) Exists(ctx context.Context, key string) (bool, error) {
+	if _, err := s.client.Bucket(s.bucket).Object(key).Attrs(ctx); err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Object(%q).Attrs: %v", key, err)
+	}
+	return true, nil
+}
+
+func (s *gcsStorage) Url(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, key)
+}
+
+func (s *gcsStorage) SelfHosted() bool { return false }
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type localStorage struct {
+	root    string
+	baseUrl string
+	etags   sync.Map // path to *localETag, hashing a file once per version
+}
+
+type localETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func LocalStorage(root, baseUrl string) IStorage {
+	return &localStorage{
+		root:    root,
+		baseUrl: baseUrl,
+	}
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) Upload(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	dest, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("mkdir %q failed: %v", filepath.Dir(dest), err)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return "", fmt.Errorf("create file failed: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		os.Remove(dest)
+		return "", fmt.Errorf("write file failed: %v", err)
+	}
+	return s.Url(key), nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.etags.Delete(dest)
+	if err := os.Remove(dest); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove file: %s failed: %w", key, ErrNotFound)
+		}
+		return fmt.Errorf("remove file: %s failed: %v", key, err)
+	}
+	return nil
+}
+
+func (s *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	dest, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(dest)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("open file failed: %v", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+
+	etag, err := s.etag(dest, file, stat)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, &ObjectInfo{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+		ETag:        etag,
+	}, nil
+}
+
+func (s *localStorage) Exists(ctx context.Context, key string) (bool, error) {
+	dest, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	stat, err := os.Stat(dest)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat file failed: %v", err)
+	}
+	return !stat.IsDir(), nil
+}
+
+// etag hashes the content of a file, again only when the file has changed
+func (s *localStorage) etag(dest string, file *os.File, stat fs.FileInfo) (string, error) {
+	if cached, ok := s.etags.Load(dest); ok {
+		c := cached.(*localETag)
+		if c.size == stat.Size() && c.modTime.Equal(stat.ModTime()) {
+			return c.etag, nil
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("hash file failed: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seek file failed: %v", err)
+	}
+
+	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
+	s.etags.Store(dest, &localETag{
+		size:    stat.Size(),
+		modTime: stat.ModTime(),
+		etag:    etag,
+	})
+	return etag, nil
+}
+
+func (s *localStorage) Url(key string) string {
+	key, _ = cleanKey(key)
+	return fmt.Sprintf("%s/%s", s.baseUrl, key)
+}
+
+func (s *localStorage) SelfHosted() bool { return true }
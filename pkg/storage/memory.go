@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+	etag        string
+}
+
+// memoryStorage keeps objects in the process, for development and tests
+type memoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+	baseUrl string
+}
+
+func MemoryStorage(baseUrl string) IStorage {
+	return &memoryStorage{
+		objects: make(map[string]*memoryObject),
+		baseUrl: baseUrl,
+	}
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error { return nil }
+
+func (s *memoryStorage) Upload(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("read object failed: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &memoryObject{
+		data:        data,
+		contentType: contentType,
+		modTime:     time.Now(),
+		etag:        contentETag(data),
+	}
+	return s.Url(key), nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return fmt.Errorf("remove object: %s failed: %w", key, ErrNotFound)
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStorage) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return memoryReader{bytes.NewReader(object.data)}, &ObjectInfo{
+		Size:        int64(len(object.data)),
+		ContentType: object.contentType,
+		ModTime:     object.modTime,
+		ETag:        object.etag,
+	}, nil
+}
+
+func (s *memoryStorage) Exists(ctx context.Context, key string) (bool, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *memoryStorage) Url(key string) string {
+	key, _ = cleanKey(key)
+	return fmt.Sprintf("%s/%s", s.baseUrl, key)
+}
+
+func (s *memoryStorage) SelfHosted() bool { return true }
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+// PrivatePrefix marks the keys of objects never made public, they are only
+// downloaded through the signed urls of the app.
+const PrivatePrefix = "private/"
+
+// DownloadPath is the app route serving the private objects
+const DownloadPath = "/v1/files/download/"
+
+var (
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	ErrSignatureExpired = errors.New("signature has expired")
+)
+
+func IsPrivate(key string) bool {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(cleaned, PrivatePrefix)
+}
+
+type ISigner interface {
+	// Url is the stable, unsigned url of a private object
+	Url(key string) string
+	// Sign adds an expiry and a signature to the url of a private object
+	Sign(rawUrl string) string
+	Verify(key, expires, signature string) error
+}
+
+type signer struct {
+	secret  []byte
+	baseUrl string
+	expires time.Duration
+}
+
+func NewSigner(cfg config.IConfig) ISigner {
+	return &signer{
+		secret:  cfg.Storage().UrlSecret(),
+		baseUrl: cfg.Storage().PublicUrl(),
+		expires: cfg.Storage().UrlExpires(),
+	}
+}
+
+func (s *signer) Url(key string) string {
+	cleaned, _ := cleanKey(key)
+	return s.baseUrl + DownloadPath + cleaned
+}
+
+func (s *signer) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *signer) Sign(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || !strings.HasPrefix(u.Path, DownloadPath) {
+		return rawUrl
+	}
+	key, err := cleanKey(strings.TrimPrefix(u.Path, DownloadPath))
+	if err != nil {
+		return rawUrl
+	}
+	expires := time.Now().Add(s.expires).Unix()
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", s.signature(key, expires))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *signer) Verify(key, expires, signature string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(key, exp))) {
+		return ErrSignatureInvalid
+	}
+	if time.Now().Unix() > exp {
+		return ErrSignatureExpired
+	}
+	return nil
+}
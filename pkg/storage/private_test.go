@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(expires time.Duration) *signer {
+	return &signer{
+		secret:  []byte("0123456789abcdef0123456789abcdef"),
+		baseUrl: "https://cdn.example.com",
+		expires: expires,
+	}
+}
+
+// signed returns the query of a signed url of the key
+func signed(t *testing.T, s ISigner, key string) url.Values {
+	t.Helper()
+	u, err := url.Parse(s.Sign(s.Url(key)))
+	if err != nil {
+		t.Fatalf("signed url is invalid: %v", err)
+	}
+	return u.Query()
+}
+
+func TestSignerUrl(t *testing.T) {
+	s := newTestSigner(time.Minute)
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"key", "private/slips/O000001.png", "https://cdn.example.com/v1/files/download/private/slips/O000001.png"},
+		{"leading slash", "/private/slips/O000001.png", "https://cdn.example.com/v1/files/download/private/slips/O000001.png"},
+		{"dot segments", "private/../private/./slips/O000001.png", "https://cdn.example.com/v1/files/download/private/slips/O000001.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Url(tt.key); got != tt.want {
+				t.Errorf("Url(%q) = %s, want %s", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignerSign(t *testing.T) {
+	s := newTestSigner(time.Minute)
+
+	tests := []struct {
+		name   string
+		rawUrl string
+		signed bool
+	}{
+		{"private url", s.Url("private/slips/O000001.png"), true},
+		{"relative private url", DownloadPath + "private/slips/O000001.png", true},
+		{"public url", "https://cdn.example.com/images/P000001.png", false},
+		{"download path without a key", DownloadPath, false},
+		{"not a url", "://cdn", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Sign(tt.rawUrl)
+			if isSigned := strings.Contains(got, "signature="); isSigned != tt.signed {
+				t.Errorf("Sign(%q) = %s, want signed %v", tt.rawUrl, got, tt.signed)
+			}
+			if !tt.signed && got != tt.rawUrl {
+				t.Errorf("Sign(%q) = %s, want the url unchanged", tt.rawUrl, got)
+			}
+		})
+	}
+}
+
+func TestSignerVerify(t *testing.T) {
+	s := newTestSigner(time.Minute)
+	key := "private/slips/O000001.png"
+	q := signed(t, s, key)
+	expired := signed(t, newTestSigner(-time.Minute), key)
+	other := newTestSigner(time.Minute)
+	other.secret = []byte("fedcba9876543210fedcba9876543210")
+
+	tests := []struct {
+		name      string
+		signer    ISigner
+		key       string
+		expires   string
+		signature string
+		want      error
+	}{
+		{"signed", s, key, q.Get("expires"), q.Get("signature"), nil},
+		{"same key with a leading slash", s, "/" + key, q.Get("expires"), q.Get("signature"), nil},
+		{"another key", s, "private/slips/O000002.png", q.Get("expires"), q.Get("signature"), ErrSignatureInvalid},
+		{"expiry pushed back", s, key, "99999999999", q.Get("signature"), ErrSignatureInvalid},
+		{"expiry not a number", s, key, "soon", q.Get("signature"), ErrSignatureInvalid},
+		{"tampered signature", s, key, q.Get("expires"), strings.ToUpper(q.Get("signature")), ErrSignatureInvalid},
+		{"missing signature", s, key, q.Get("expires"), "", ErrSignatureInvalid},
+		{"another secret", other, key, q.Get("expires"), q.Get("signature"), ErrSignatureInvalid},
+		{"expired", s, key, expired.Get("expires"), expired.Get("signature"), ErrSignatureExpired},
+		{"empty key", s, "", q.Get("expires"), q.Get("signature"), ErrSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signer.Verify(tt.key, tt.expires, tt.signature); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+// s3Storage works with AWS and S3-compatible services such as MinIO
+type s3Storage struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	region   string
+	endpoint string
+}
+
+func S3Storage(cfg config.IStorageConfig) (IStorage, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(cfg.S3Region()),
+	}
+	// Without keys the default credential chain is used
+	if cfg.S3AccessKey() != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.S3AccessKey(), cfg.S3SecretKey(), ""),
+		))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("config.LoadDefaultConfig: %v", err)
+	}
+
+	endpoint := strings.TrimSuffix(cfg.S3Endpoint(), "/")
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.UsePathStyle = cfg.S3PathStyle()
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &s3Storage{
+		client:   client,
+		uploader: manager.NewUploader(client),
+		bucket:   cfg.S3Bucket(),
+		region:   cfg.S3Region(),
+		endpoint: endpoint,
+	}, nil
+}
+
+func (s *s3Storage) Upload(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	acl := types.ObjectCannedACLPublicRead
+	if IsPrivate(key) {
+		acl = types.ObjectCannedACLPrivate
+	}
+
+	if _, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+		ACL:         acl,
+	}); err != nil {
+		return "", fmt.Errorf("upload object %q failed: %v", key, err)
+	}
+	return s.Url(key), nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("delete object %q failed: %v", key, err)
+	}
+	return nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("get object %q failed: %v", key, err)
+	}
+	return out.Body, &ObjectInfo{
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: aws.ToString(out.ContentType),
+		ModTime:     aws.ToTime(out.LastModified),
+		ETag:        aws.ToString(out.ETag),
+	}, nil
+}
+
+func (s *s3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	if _, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		// A HEAD response has no body, the error code is all there is
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("head object %q failed: %v", key, err)
+	}
+	return true, nil
+}
+
+func (s *s3Storage) Url(key string) string {
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, key)
+}
+
+func (s *s3Storage) SelfHosted() bool { return false }
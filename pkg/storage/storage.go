@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jetsadawwts/go-restapi/config"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type IStorage interface {
+	// Upload writes the object under key and returns its url
+	Upload(ctx context.Context, key string, r io.Reader, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Exists checks for an object without reading it
+	Exists(ctx context.Context, key string) (bool, error)
+	Url(key string) string
+	// SelfHosted tells whether the objects are served by this app rather than by the provider
+	SelfHosted() bool
+}
+
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string // quoted, changes with the content
+}
+
+func NewStorage(cfg config.IConfig) (IStorage, error) {
+	baseUrl := cfg.Storage().PublicUrl()
+
+	switch cfg.Storage().Driver() {
+	case "local":
+		return LocalStorage(cfg.Storage().LocalRoot(), baseUrl), nil
+	case "memory":
+		return MemoryStorage(baseUrl), nil
+	case "gcs":
+		return GcsStorage(cfg.App().GCPBucket())
+	case "s3":
+		return S3Storage(cfg.Storage())
+	default:
+		return nil, fmt.Errorf("storage driver %q is not supported", cfg.Storage().Driver())
+	}
+}
+
+// contentETag is the strong validator of an object's bytes
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:]))
+}
+
+// cleanKey keeps a key inside the storage root
+func cleanKey(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("key is invalid")
+	}
+	return cleaned, nil
+}